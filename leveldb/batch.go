@@ -0,0 +1,100 @@
+package leveldb
+
+import (
+	"slices"
+	"time"
+)
+
+// WriteBatch collects puts and deletes to be applied to a DB together by DB.Write: after a crash, either all of them
+// are recovered or none are.  The zero value is an empty batch, whose writes go to the default column family.
+type WriteBatch struct {
+	entries []BatchEntry
+	// family is the column family the writes added next go to
+	family uint32
+}
+
+// BatchEntry is a single write held by a WriteBatch.
+type BatchEntry struct {
+	DataEntry
+	// Deleted marks a delete of Key, which carries no Value.
+	Deleted bool
+	// Limit, if set on a delete, has it delete every key in [Key, Limit] rather than Key alone.
+	Limit Key
+	// ExpiresAt, unless zero, is when a put's Value expires, after which the key reads as deleted.
+	ExpiresAt time.Time
+	// Merge marks Value as an operand to merge into Key's value, rather than a value to replace it with.
+	Merge bool
+	// ColumnFamily is the ID of the column family the write goes to, 0 being the default one.
+	ColumnFamily uint32
+}
+
+// SetColumnFamily has the writes added to the batch from now on go to the column family numbered id, so that a single
+// batch can update several families atomically.  Not every DB has column families; those that do not reject batches
+// writing to any but the default one, numbered 0.
+func (b *WriteBatch) SetColumnFamily(id uint32) {
+	b.family = id
+}
+
+// Put adds setting key to value to the batch.  Both are copied, so the caller may reuse them.
+func (b *WriteBatch) Put(key Key, value Value) {
+	b.entries = append(b.entries, BatchEntry{
+		DataEntry:    DataEntry{Key: slices.Clone(key), Value: slices.Clone(value)},
+		ColumnFamily: b.family,
+	})
+}
+
+// PutWithExpiry adds setting key to value until expiresAt to the batch.  Both are copied, so the caller may reuse them.
+// Not every DB supports expiring values; those that do not reject the batch.
+func (b *WriteBatch) PutWithExpiry(key Key, value Value, expiresAt time.Time) {
+	b.entries = append(b.entries, BatchEntry{
+		DataEntry:    DataEntry{Key: slices.Clone(key), Value: slices.Clone(value)},
+		ExpiresAt:    expiresAt,
+		ColumnFamily: b.family,
+	})
+}
+
+// Merge adds merging operand into the value of key to the batch, as the DB's MergeOperator does.  Both are copied, so
+// the caller may reuse them.  Not every DB supports merges; those that do not reject the batch.
+func (b *WriteBatch) Merge(key Key, operand Value) {
+	b.entries = append(b.entries, BatchEntry{
+		DataEntry:    DataEntry{Key: slices.Clone(key), Value: slices.Clone(operand)},
+		Merge:        true,
+		ColumnFamily: b.family,
+	})
+}
+
+// Delete adds deleting key to the batch.  The key is copied, so the caller may reuse it.
+func (b *WriteBatch) Delete(key Key) {
+	b.entries = append(b.entries, BatchEntry{
+		DataEntry:    DataEntry{Key: slices.Clone(key)},
+		Deleted:      true,
+		ColumnFamily: b.family,
+	})
+}
+
+// DeleteRange adds deleting every key in [start, limit] to the batch.  The keys are copied, so the caller may reuse
+// them.
+func (b *WriteBatch) DeleteRange(start Key, limit Key) {
+	b.entries = append(b.entries, BatchEntry{
+		DataEntry:    DataEntry{Key: slices.Clone(start)},
+		Deleted:      true,
+		Limit:        append(Key{}, limit...), // set even if limit is blank, which DB.Write rejects
+		ColumnFamily: b.family,
+	})
+}
+
+// Len returns the number of writes in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// Entries returns the writes in the batch, in the order they were added and will be applied.  Later writes to a key
+// override earlier ones.
+func (b *WriteBatch) Entries() []BatchEntry {
+	return b.entries
+}
+
+// Reset empties the batch so that it can be reused, with its writes going to the default column family again.
+func (b *WriteBatch) Reset() {
+	b.entries, b.family = b.entries[:0], 0
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+const numShards = 16
+
+// Cache is a least-recently-used cache bounded by the total charge of its entries, which is up to its callers: bytes,
+// say, or one per entry.  It is split into shards, each with its own lock and an equal share of the capacity, so that
+// concurrent readers rarely wait on one another.  Cache is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	shards []shard[K, V]
+	// hash spreads keys across the shards
+	hash func(key K) uint64
+	// onEvict, if set, is called for each entry the cache lets go of, once its shard is unlocked
+	onEvict      func(key K, value V)
+	hits, misses atomic.Uint64
+}
+
+// Stats reports how well a Cache has served its lookups.
+type Stats struct {
+	Hits   uint64
+	Misses uint64
+	// Charge is the total charge of the entries held
+	Charge int64
+}
+
+// New returns an empty Cache holding entries with a total charge of up to capacity, spread across the shards by hash.
+// A capacity smaller than the usual number of shards is split across fewer of them, so that every shard holds a
+// charge of at least 1.
+//
+// onEvict, which may be nil, is called with each entry evicted to make room for another, replaced by Add, or too
+// large to be cached at all; it is not called for entries taken out with Remove.
+func New[K comparable, V any](capacity int64, hash func(key K) uint64, onEvict func(key K, value V)) *Cache[K, V] {
+	var c = &Cache[K, V]{
+		shards:  make([]shard[K, V], min(numShards, max(capacity, 1))),
+		hash:    hash,
+		onEvict: onEvict,
+	}
+	var count = int64(len(c.shards))
+	for j := range c.shards {
+		c.shards[j] = shard[K, V]{
+			capacity: capacity / count,
+			entries:  make(map[K]*list.Element),
+			lru:      list.New(),
+		}
+		// the shards share out any remainder, so that together they hold exactly capacity
+		if int64(j) < capacity%count {
+			c.shards[j].capacity++
+		}
+	}
+	return c
+}
+
+// Get returns the value cached for key, if there is one, and marks it as the most recently used in its shard.
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	value, ok := c.shardFor(key).get(key)
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	return value, ok
+}
+
+// Add caches value for key, replacing any value already cached for it, then evicts the least recently used entries
+// of its shard until the shard is back within its capacity.  An entry charged more than a shard may hold is not
+// cached at all.
+func (c *Cache[K, V]) Add(key K, value V, charge int64) {
+	var evicted = c.shardFor(key).add(key, value, charge)
+	if c.onEvict == nil {
+		return
+	}
+	for _, e := range evicted {
+		c.onEvict(e.key, e.value)
+	}
+}
+
+// Remove takes the value cached for key out of the cache, if there is one, and returns it.
+func (c *Cache[K, V]) Remove(key K) (V, bool) {
+	return c.shardFor(key).take(key)
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	var stats = Stats{Hits: c.hits.Load(), Misses: c.misses.Load()}
+	for j := range c.shards {
+		stats.Charge += c.shards[j].totalCharge()
+	}
+	return stats
+}
+
+func (c *Cache[K, V]) shardFor(key K) *shard[K, V] {
+	return &c.shards[c.hash(key)%uint64(len(c.shards))]
+}
+
+// shard is an LRU list of entries, most recently used first, and a map to find them by key.
+type shard[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int64
+	charge   int64
+	entries  map[K]*list.Element
+	lru      *list.List
+}
+
+type entry[K comparable, V any] struct {
+	key    K
+	value  V
+	charge int64
+}
+
+func (s *shard[K, V]) get(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	s.lru.MoveToFront(element)
+	return element.Value.(*entry[K, V]).value, true
+}
+
+// add caches an entry and returns the entries it pushed out, the entry itself included if it is too large to cache.
+func (s *shard[K, V]) add(key K, value V, charge int64) []*entry[K, V] {
+	var added = &entry[K, V]{key: key, value: value, charge: charge}
+	if charge > s.capacity {
+		return []*entry[K, V]{added}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var evicted []*entry[K, V]
+	if element, ok := s.entries[key]; ok {
+		evicted = append(evicted, s.remove(element))
+	}
+	s.entries[key] = s.lru.PushFront(added)
+	s.charge += charge
+	for s.charge > s.capacity {
+		evicted = append(evicted, s.remove(s.lru.Back()))
+	}
+	return evicted
+}
+
+func (s *shard[K, V]) take(key K) (V, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return s.remove(element).value, true
+}
+
+// remove drops an entry and returns it.  The caller holds s.mu.
+func (s *shard[K, V]) remove(element *list.Element) *entry[K, V] {
+	var removed = s.lru.Remove(element).(*entry[K, V])
+	delete(s.entries, removed.key)
+	s.charge -= removed.charge
+	return removed
+}
+
+func (s *shard[K, V]) totalCharge() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.charge
+}
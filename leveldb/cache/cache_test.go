@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// sameShard hashes every key to the same shard, so that a test can fill one shard predictably.
+func sameShard(int) uint64 { return 0 }
+
+func TestCache(t *testing.T) {
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		// each shard holds a charge of 3
+		var c = New[int, string](3*numShards, sameShard, nil)
+		for key := range 3 {
+			c.Add(key, fmt.Sprint(key), 1)
+		}
+		if _, ok := c.Get(0); !ok {
+			t.Fatal("expected key 0 to be cached")
+		}
+		c.Add(3, "3", 1) // evicts key 1, now the least recently used
+		for key, expected := range []bool{true, false, true, true} {
+			if _, ok := c.Get(key); ok != expected {
+				t.Errorf("expected key %d cached to be %t, got %t", key, expected, ok)
+			}
+		}
+	})
+	t.Run("ChargesAgainstCapacity", func(t *testing.T) {
+		var c = New[int, string](10*numShards, sameShard, nil)
+		c.Add(0, "small", 4)
+		c.Add(1, "large", 8) // evicts key 0
+		c.Add(2, "too large", 11)
+		if _, ok := c.Get(0); ok {
+			t.Error("expected key 0 to be evicted to make room for key 1")
+		}
+		if value, ok := c.Get(1); !ok || value != "large" {
+			t.Errorf("expected key 1 to be cached as %q, got %q", "large", value)
+		}
+		if _, ok := c.Get(2); ok {
+			t.Error("expected an entry charged more than a shard holds not to be cached")
+		}
+		if stats := c.Stats(); stats.Charge != 8 || stats.Hits != 1 || stats.Misses != 2 {
+			t.Errorf("expected a charge of 8 with 1 hit and 2 misses, got %+v", stats)
+		}
+	})
+	t.Run("ReportsEvictions", func(t *testing.T) {
+		var evicted []string
+		var c = New[int, string](2*numShards, sameShard, func(key int, value string) {
+			evicted = append(evicted, fmt.Sprintf("%d=%s", key, value))
+		})
+		c.Add(0, "zero", 1)
+		c.Add(1, "one", 1)
+		c.Add(0, "nought", 1) // replaces key 0
+		c.Add(2, "two", 1)    // evicts key 1
+		c.Add(3, "three", 3)  // too large to cache
+		if value, ok := c.Remove(2); !ok || value != "two" {
+			t.Errorf("expected Remove() to take out key 2 cached as %q, got %q", "two", value)
+		}
+		if fmt.Sprint(evicted) != "[0=zero 1=one 3=three]" {
+			t.Errorf("expected the replaced, evicted and uncached entries to be reported, got %v", evicted)
+		}
+		if _, ok := c.Get(2); ok {
+			t.Error("expected key 2 to be gone once removed")
+		}
+	})
+	t.Run("SmallCapacity", func(t *testing.T) {
+		var c = New[int, int](3, func(key int) uint64 { return uint64(key) }, nil)
+		for key := range 10 {
+			c.Add(key, key, 1)
+		}
+		if stats := c.Stats(); stats.Charge != 3 {
+			t.Errorf("expected a capacity under the number of shards to be held in full, got a charge of %d", stats.Charge)
+		}
+	})
+	t.Run("Replaces", func(t *testing.T) {
+		var c = New[int, string](10*numShards, sameShard, nil)
+		c.Add(0, "old", 5)
+		c.Add(0, "new", 2)
+		if value, ok := c.Get(0); !ok || value != "new" {
+			t.Errorf("expected key 0 to be cached as %q, got %q", "new", value)
+		}
+		if stats := c.Stats(); stats.Charge != 2 {
+			t.Errorf("expected the replaced entry's charge to be dropped, got a charge of %d", stats.Charge)
+		}
+	})
+}
+
+// TestCache_ConcurrentUse has readers and writers share a cache across shards.  Run it with -race.
+func TestCache_ConcurrentUse(t *testing.T) {
+	var (
+		c  = New[int, int](64, func(key int) uint64 { return uint64(key) }, nil)
+		wg sync.WaitGroup
+	)
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 1000 {
+				var key = (g*7 + j) % 100
+				if value, ok := c.Get(key); ok && value != key {
+					t.Errorf("expected key %d cached as itself, got %d", key, value)
+					return
+				}
+				c.Add(key, key, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats := c.Stats(); stats.Hits+stats.Misses != 8000 || stats.Charge > 64 {
+		t.Errorf("expected 8000 lookups and a charge of at most 64, got %+v", stats)
+	}
+}
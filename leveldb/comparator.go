@@ -0,0 +1,23 @@
+package leveldb
+
+import "bytes"
+
+// Comparator orders keys.  A database sorts and searches its keys by one Comparator for its whole life, so it records
+// the Comparator's Name and refuses to be opened with another.
+type Comparator interface {
+	// Compare returns a negative number if a sorts before b, a positive one if it sorts after, and zero if they are
+	// equal.  Only identical keys may compare equal, since lookups and bloom filters match keys byte for byte.
+	Compare(a, b Key) int
+
+	// Name identifies the ordering.  A Comparator must change its name whenever it changes how keys are ordered.
+	Name() string
+}
+
+// BytewiseComparator orders keys lexicographically by their bytes, as Key.Compare does.  It is the default.
+var BytewiseComparator Comparator = bytewiseComparator{}
+
+type bytewiseComparator struct{}
+
+func (bytewiseComparator) Compare(a, b Key) int { return bytes.Compare(a, b) }
+
+func (bytewiseComparator) Name() string { return "leveldb.BytewiseComparator" }
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// DefaultColumnFamily is the name of the column family every database starts with.  It has ID 0, so reads and writes
+// that name no family go to it, and cannot be dropped.
+const DefaultColumnFamily = "default"
+
+// ColumnFamily identifies a column family: a keyspace of its own within a database, with its own memTable, SSTables
+// and Comparator.  Every family shares the database's WAL and sequence numbers, so a leveldb.WriteBatch writing to
+// several of them is still applied atomically, and a snapshot covers them all.
+type ColumnFamily struct {
+	// ID selects the family, with leveldb.ToColumnFamily, leveldb.FromColumnFamily or leveldb.WriteBatch's
+	// SetColumnFamily.  IDs are never reused, even once a family is dropped.
+	ID   uint32
+	Name string
+}
+
+// ColumnFamilies is implemented by databases holding column families.
+type ColumnFamilies interface {
+	// CreateColumnFamily adds an empty family named name, configured by opts, which may be nil.
+	CreateColumnFamily(name string, opts *Options) (ColumnFamily, error)
+
+	// DropColumnFamily deletes the family named name, along with every key in it.
+	DropColumnFamily(name string) error
+
+	// ListColumnFamilies returns the database's families, by ID.
+	ListColumnFamilies() []ColumnFamily
+}
+
+// columnFamily is the state of one column family: its options, the memTables it writes to and the version holding its
+// tables.  The rest, the WAL, the manifest and the sequence and file numbers among it, belongs to the database and is
+// shared by every family.  memTable, immutable and current are guarded by db.mu, as readers load them.
+type columnFamily struct {
+	id   uint32
+	name string
+	db   *db
+	// options configures the family.  The WAL, caches, recovery and clock are the database's, so its settings for
+	// those are ignored.
+	options  *Options
+	memTable *memTable
+	// immutable is the memTable being flushed, which readers consult until the table it is flushed to is current
+	immutable *memTable
+	current   *version
+	// tables holds the family's SSTables open for reading, sharing the limit on open tables with every other family
+	tables   *tableCache
+	strategy compactionStrategy
+}
+
+// newColumnFamily returns an empty family of db's.  The default family, created first, starts the table cache the
+// others share.
+func newColumnFamily(db *db, id uint32, name string, opts *Options) *columnFamily {
+	var cf = &columnFamily{
+		id:       id,
+		name:     name,
+		db:       db,
+		options:  opts,
+		memTable: newMemTable(opts),
+		strategy: newCompactionStrategy(opts),
+	}
+	if db.columnFamily == nil {
+		cf.tables = newTableCache(db.dir, opts.tableCacheSize(), cf.openTable)
+	} else {
+		cf.tables = db.tables.sharing(cf.openTable)
+	}
+	cf.installVersion(&version{tables: cf.tables, comparator: opts.Comparator})
+	return cf
+}
+
+// familyOptions returns the options a family other than the default one is configured with, given the options it was
+// created with, if any.
+func (db *db) familyOptions(opts *Options) *Options {
+	opts = opts.withDefaults()
+	opts.now = db.options.now
+	return opts
+}
+
+// CreateColumnFamily records the family in the manifest before returning, so that it is there to replay writes to it
+// from the WAL after a crash.  Only databases created by Open, which keep a manifest, hold families besides the
+// default one.  When the database is reopened, the family takes its options from Options.ColumnFamilies.
+func (db *db) CreateColumnFamily(name string, opts *Options) (ColumnFamily, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if db.dir == "" {
+		return ColumnFamily{}, errors.New("db.CreateColumnFamily: only databases created by Open hold column families")
+	}
+	if name == "" {
+		return ColumnFamily{}, errors.New("db.CreateColumnFamily: cannot create column family with blank name")
+	}
+	if db.familyByName(name) != nil {
+		return ColumnFamily{}, fmt.Errorf("db.CreateColumnFamily: column family %q already exists", name)
+	}
+	var (
+		cf   = newColumnFamily(db, db.nextFamily, name, db.familyOptions(opts))
+		edit = new(versionEdit)
+	)
+	edit.setFamily(cf.id)
+	edit.addFamily(name)
+	edit.setComparator(cf.options.Comparator.Name())
+	edit.setNextFamily(cf.id + 1)
+	if err := db.manifest.append(edit); err != nil {
+		_ = cf.current.unref()
+		return ColumnFamily{}, fmt.Errorf("db.CreateColumnFamily: error recording column family in manifest: %v", err)
+	}
+	db.nextFamily++
+	db.mu.Lock()
+	db.families[cf.id] = cf
+	db.mu.Unlock()
+	return ColumnFamily{ID: cf.id, Name: name}, nil
+}
+
+// DropColumnFamily forgets the family at once, and deletes its tables once no reader is left using them.  Its writes
+// still in the WAL are skipped when it is replayed.
+func (db *db) DropColumnFamily(name string) error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if name == DefaultColumnFamily {
+		return errors.New("db.DropColumnFamily: cannot drop the default column family")
+	}
+	var cf = db.familyByName(name)
+	if cf == nil {
+		return fmt.Errorf("db.DropColumnFamily: no column family named %q", name)
+	}
+	var edit = new(versionEdit)
+	edit.setFamily(cf.id)
+	edit.dropFamily()
+	if err := db.manifest.append(edit); err != nil {
+		return fmt.Errorf("db.DropColumnFamily: error recording drop in manifest: %v", err)
+	}
+	db.mu.Lock()
+	delete(db.families, cf.id)
+	db.mu.Unlock()
+	// tables that fail to close have nothing left to lose, having been dropped
+	_ = cf.current.unref()
+	db.removeObsoleteFiles()
+	return nil
+}
+
+func (db *db) ListColumnFamilies() []ColumnFamily {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var families = make([]ColumnFamily, 0, len(db.families))
+	for _, cf := range db.sortedFamilies() {
+		families = append(families, ColumnFamily{ID: cf.id, Name: cf.name})
+	}
+	return families
+}
+
+// familyByName returns the live family named name, or nil if there is none.  The caller holds writeMu or mu.
+func (db *db) familyByName(name string) *columnFamily {
+	for _, cf := range db.families {
+		if cf.name == name {
+			return cf
+		}
+	}
+	return nil
+}
+
+// sortedFamilies returns the live families by ID, so that they are flushed and recorded in a stable order.  The caller
+// holds writeMu or mu.
+func (db *db) sortedFamilies() []*columnFamily {
+	var families = make([]*columnFamily, 0, len(db.families))
+	for _, cf := range db.families {
+		families = append(families, cf)
+	}
+	slices.SortFunc(families, func(a, b *columnFamily) int {
+		return cmp.Compare(a.id, b.id)
+	})
+	return families
+}
+
+// family returns the live family with the given ID.  The caller holds writeMu or mu.
+func (db *db) family(id uint32) (*columnFamily, error) {
+	cf, ok := db.families[id]
+	if !ok {
+		return nil, fmt.Errorf("no column family with ID %d", id)
+	}
+	return cf, nil
+}
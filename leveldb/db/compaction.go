@@ -0,0 +1,393 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"leveldb"
+	"leveldb/sst"
+	"os"
+	"slices"
+)
+
+// compactionStrategy decides which tables to merge, and when.  The rest of the work, writing the merged tables and
+// swapping them in, is shared by every strategy.
+type compactionStrategy interface {
+	// pickCompaction returns the next compaction to run against v, or nil if none is due.
+	pickCompaction(v *version) *compaction
+}
+
+func newCompactionStrategy(opts *Options) compactionStrategy {
+	switch opts.CompactionStyle {
+	case SizeTieredCompaction:
+		return &sizeTieredStrategy{options: opts}
+	default:
+		return &leveledStrategy{options: opts}
+	}
+}
+
+// compaction merges a set of tables from one level into another.
+type compaction struct {
+	level       int
+	outputLevel int
+	// inputs holds the tables taken from level, then any tables in outputLevel they overlap.
+	inputs [2][]*fileMetadata
+	// maxOutputFileSize is the size at which the output is split into another table, or zero for a single table.
+	maxOutputFileSize int
+	// olderTables holds tables outside the inputs that may hold older entries for keys within them.  A tombstone for a
+	// key none of them contains has nothing left to hide, and is dropped.
+	olderTables []*fileMetadata
+}
+
+// isTrivialMove reports whether the compaction can be done by moving a table to the output level, without rewriting it.
+func (c *compaction) isTrivialMove() bool {
+	return c.level != c.outputLevel && len(c.inputs[0]) == 1 && len(c.inputs[1]) == 0
+}
+
+func (c *compaction) inputLevel(which int) int {
+	if which == 0 {
+		return c.level
+	}
+	return c.outputLevel
+}
+
+// isBaseLevelForKey reports whether no table holding older data than the inputs has a range including key.
+func (c *compaction) isBaseLevelForKey(key leveldb.Key, comparator leveldb.Comparator) bool {
+	return c.isBaseLevelForRange(key, key, comparator)
+}
+
+// isBaseLevelForRange reports whether no table holding older data than the inputs has a range overlapping [start,
+// limit].
+func (c *compaction) isBaseLevelForRange(start leveldb.Key, limit leveldb.Key, comparator leveldb.Comparator) bool {
+	for _, meta := range c.olderTables {
+		if meta.overlaps(start, limit, comparator) {
+			return false
+		}
+	}
+	return true
+}
+
+// maybeCompact runs compactions until the strategy has none left to pick.
+func (cf *columnFamily) maybeCompact() error {
+	for c := cf.strategy.pickCompaction(cf.current); c != nil; c = cf.strategy.pickCompaction(cf.current) {
+		if err := cf.runCompaction(c); err != nil {
+			return fmt.Errorf("error compacting level %d: %v", c.level, err)
+		}
+	}
+	return nil
+}
+
+// runCompaction merges the compaction's inputs into new tables in the output level.  Writes to a key that every reader
+// sees past or sees deleted by a range tombstone are dropped, as are deletes, range tombstones and expired values that
+// every reader sees once no older table could hold a value for them to hide.  Merge operands every reader sees are
+// merged into a value once the value they apply to is found, or no older table could hold one.  The inputs are
+// swapped for the outputs with a single manifest edit, so a crash leaves one set or the other live.
+func (cf *columnFamily) runCompaction(c *compaction) error {
+	var edit = new(versionEdit)
+	for which, files := range c.inputs {
+		for _, meta := range files {
+			edit.deleteFile(c.inputLevel(which), meta.number)
+		}
+	}
+
+	if c.isTrivialMove() {
+		edit.addFile(c.outputLevel, c.inputs[0][0])
+	} else {
+		outputs, err := cf.writeCompactionOutputs(c)
+		if err != nil {
+			return err
+		}
+		for _, meta := range outputs {
+			edit.addFile(c.outputLevel, meta)
+			cf.db.stats.bytesCompacted += meta.size
+		}
+	}
+
+	edit.setFamily(cf.id)
+	edit.setNextFileNumber(cf.db.nextFileNumber)
+	if err := cf.db.manifest.append(edit); err != nil {
+		return fmt.Errorf("error recording compaction in manifest: %v", err)
+	}
+	// the inputs are closed once no reader is left using them
+	cf.installVersion(cf.current.apply(edit))
+	cf.db.removeObsoleteFiles()
+	return nil
+}
+
+// writeCompactionOutputs streams the merged inputs into as many tables as it takes to keep each one near the
+// compaction's maxOutputFileSize.  Each range tombstone kept goes to the table holding the keys around its start, and
+// tables are only cut past the end of its range, so that tables in a level still hold ranges that do not overlap.
+// On error, tables already written are evicted from the table cache and left for removeObsoleteFiles.
+func (cf *columnFamily) writeCompactionOutputs(c *compaction) (outputs []*fileMetadata, err error) {
+	var output *compactionOutput
+	defer func() {
+		if err == nil {
+			return
+		}
+		if output != nil {
+			_ = output.f.Close()
+		}
+		for _, meta := range outputs {
+			_ = cf.tables.evict(meta.number)
+		}
+	}()
+
+	var (
+		sources    []leveldb.Iterator
+		handles    []*tableHandle
+		tombstones rangeTombstones
+	)
+	defer func() {
+		// the inputs have been read in full by now, so an iterator or a table failing to close loses nothing
+		for _, iterator := range sources {
+			_ = iterator.Close()
+		}
+		_ = releaseAll(handles)
+	}()
+	// the first inputs are ordered oldest to newest if they overlap; the merge does not depend on it, since sequence
+	// numbers order writes to the same key.  The inputs are read once and then deleted, so they bypass the block cache.
+	for _, files := range c.inputs {
+		for _, meta := range files {
+			handle, err := cf.tables.acquire(meta.number)
+			if err != nil {
+				return nil, err
+			}
+			handles = append(handles, handle)
+			iterator, err := handle.scan(meta.smallest, meta.largest, leveldb.WithBypassCache(true))
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, iterator)
+			tombstones = append(tombstones, handle.tombstones...)
+		}
+	}
+
+	var (
+		merged           = newInternalMergingIterator(cf.options.internalComparator(), sources...)
+		comparator       = cf.options.Comparator
+		smallestSnapshot = cf.db.smallestSnapshot()
+		now              = cf.options.now().UnixNano()
+		// userKey is the user key of the entries being merged, and lastSequence the sequence number of the previous
+		// entry for it, or maxSequence for its first
+		userKey      leveldb.Key
+		lastSequence uint64
+		// pending holds the range tombstones to keep that have yet to be written, in order
+		pending rangeTombstones
+		// operands holds the merge operands for userKey that every reader sees, keyed by internal key and newest first,
+		// until they are merged into a value or written as they are
+		operands []leveldb.DataEntry
+	)
+	for _, tombstone := range tombstones.sorted(cf.options.internalComparator()) {
+		if tombstone.seq <= smallestSnapshot && c.isBaseLevelForRange(tombstone.start, tombstone.limit, comparator) {
+			continue // nothing left for the tombstone to delete once the entries it covers here are dropped
+		}
+		pending = append(pending, tombstone)
+	}
+	// writePending writes the pending tombstones starting no later than key, or every one left if key is nil
+	var writePending = func(key leveldb.Key) error {
+		for len(pending) > 0 && (key == nil || comparator.Compare(pending[0].start, key) <= 0) {
+			if output == nil {
+				if output, err = cf.newCompactionOutput(); err != nil {
+					return err
+				}
+			}
+			if err := output.addRangeTombstone(pending[0]); err != nil {
+				return err
+			}
+			pending = pending[1:]
+		}
+		return nil
+	}
+	// add writes an entry kept, after the pending tombstones starting no later than its key
+	var add = func(key internalKey, value leveldb.Value) error {
+		var userKey = key.userKey()
+		if err := writePending(userKey); err != nil {
+			return err
+		}
+		// cut outputs only between user keys, so that a lookup finds every write to a key in one table per level, and
+		// past every range tombstone written, which the table's range takes in
+		if output != nil && c.maxOutputFileSize > 0 && output.writer.Size() >= int64(c.maxOutputFileSize) &&
+			comparator.Compare(userKey, output.meta.largest) > 0 {
+			meta, err := output.finish(cf.tables)
+			if err != nil {
+				return err
+			}
+			outputs, output = append(outputs, meta), nil
+		}
+		if output == nil {
+			var err error
+			if output, err = cf.newCompactionOutput(); err != nil {
+				return err
+			}
+		}
+		return output.add(key, value)
+	}
+	// writeOperands writes the operands stacked for userKey merged into existing, if found is set, as the value that
+	// was written over.  If not, they are merged into nothing once no older table could hold a value for them, and
+	// otherwise written as they are.
+	var writeOperands = func(existing leveldb.Value, found bool) error {
+		if len(operands) == 0 {
+			return nil
+		}
+		defer func() { operands = nil }()
+		if !found && !c.isBaseLevelForKey(userKey, comparator) {
+			for _, operand := range operands {
+				if err := add(internalKey(operand.Key), operand.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		var values = make([]leveldb.Value, 0, len(operands))
+		for _, operand := range operands {
+			values = append(values, operand.Value)
+		}
+		value, err := mergeOperands(cf.options.MergeOperator, userKey, existing, values)
+		if err != nil {
+			return err
+		}
+		// the value takes the place of the newest operand
+		return add(makeInternalKey(userKey, internalKey(operands[0].Key).sequence(), kindValue), value)
+	}
+	for merged.Next() {
+		var key, value = internalKey(merged.Key()), merged.Value()
+		if userKey == nil || !bytes.Equal(key.userKey(), userKey) {
+			if err = writeOperands(nil, false); err != nil {
+				return outputs, err
+			}
+			userKey, lastSequence = slices.Clone(key.userKey()), maxSequence
+		}
+		// every reader, snapshots included, takes an expired value for a delete
+		var _, live = liveValue(key, value, now)
+		if key.kind() == kindValueWithExpiry && !live {
+			key, value = makeInternalKey(userKey, key.sequence(), kindDelete), nil
+		}
+		var deleted = key.sequence() < tombstones.deletedBefore(userKey, smallestSnapshot, comparator)
+		if len(operands) > 0 {
+			// every reader sees the entry below the operands stacked, so it is stacked with them or merged into
+			lastSequence = key.sequence()
+			if key.kind() == kindMerge && !deleted {
+				operands = append(operands, leveldb.DataEntry{Key: slices.Clone(merged.Key()), Value: slices.Clone(value)})
+				continue
+			}
+			var existing, _ = liveValue(key, value, now)
+			if deleted {
+				existing = nil
+			}
+			if err = writeOperands(existing, true); err != nil {
+				return outputs, err
+			}
+			continue
+		}
+		var drop bool
+		switch {
+		case lastSequence <= smallestSnapshot:
+			drop = true // a newer write to the key is seen by every reader
+		case !live && key.kind() != kindMerge && key.sequence() <= smallestSnapshot &&
+			c.isBaseLevelForKey(userKey, comparator):
+			drop = true // nothing left for the delete to hide
+		case deleted:
+			drop = true // deleted by a range tombstone every reader sees
+		}
+		lastSequence = key.sequence()
+		if drop {
+			continue
+		}
+		if key.kind() == kindMerge && key.sequence() <= smallestSnapshot {
+			operands = append(operands, leveldb.DataEntry{Key: slices.Clone(merged.Key()), Value: slices.Clone(value)})
+			continue
+		}
+		if err = add(key, value); err != nil {
+			return outputs, err
+		}
+	}
+	if err = merged.Error(); err != nil {
+		return outputs, fmt.Errorf("error merging inputs: %v", err)
+	}
+	if err = writeOperands(nil, false); err != nil {
+		return outputs, err
+	}
+	if err = writePending(nil); err != nil {
+		return outputs, err
+	}
+	if output != nil {
+		meta, err := output.finish(cf.tables)
+		if err != nil {
+			return outputs, err
+		}
+		outputs, output = append(outputs, meta), nil
+	}
+	return outputs, nil
+}
+
+// compactionOutput is a table being written by a compaction.
+type compactionOutput struct {
+	f          *os.File
+	writer     *sst.Writer
+	meta       *fileMetadata
+	comparator leveldb.Comparator
+}
+
+func (cf *columnFamily) newCompactionOutput() (*compactionOutput, error) {
+	var number = cf.db.newFileNumber()
+	f, err := os.Create(tableFileName(cf.db.dir, number))
+	if err != nil {
+		return nil, err
+	}
+	writer, err := cf.newTableWriter(f, number)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &compactionOutput{
+		f:          f,
+		writer:     writer,
+		meta:       &fileMetadata{number: number},
+		comparator: cf.options.Comparator,
+	}, nil
+}
+
+func (output *compactionOutput) add(key internalKey, value leveldb.Value) error {
+	if err := output.writer.Add(leveldb.Key(key), value); err != nil {
+		return err
+	}
+	output.extend(key.userKey(), key.userKey())
+	return nil
+}
+
+// addRangeTombstone adds a range tombstone, which the table's key range takes in.
+func (output *compactionOutput) addRangeTombstone(tombstone rangeTombstone) error {
+	if err := output.writer.AddRangeTombstone(leveldb.Key(tombstone.key()), leveldb.Value(tombstone.limit)); err != nil {
+		return err
+	}
+	output.extend(tombstone.start, tombstone.limit)
+	return nil
+}
+
+// extend widens the table's key range to take in [smallest, largest].
+func (output *compactionOutput) extend(smallest leveldb.Key, largest leveldb.Key) {
+	if output.meta.smallest == nil || output.comparator.Compare(smallest, output.meta.smallest) < 0 {
+		output.meta.smallest = smallest
+	}
+	if output.meta.largest == nil || output.comparator.Compare(largest, output.meta.largest) > 0 {
+		output.meta.largest = largest
+	}
+}
+
+// finish completes the table and syncs it, so that it is durable before the manifest refers to it, then leaves it
+// open in tables for the reads that follow.
+func (output *compactionOutput) finish(tables *tableCache) (*fileMetadata, error) {
+	table, err := output.writer.Finish()
+	if err != nil {
+		return nil, err
+	}
+	info, err := output.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	output.meta.size = uint64(info.Size())
+	if err := output.f.Sync(); err != nil {
+		return nil, err
+	}
+	tables.add(output.meta.number, table)
+	return output.meta, nil
+}
@@ -0,0 +1,587 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"leveldb"
+	"math/rand/v2"
+	"slices"
+	"testing"
+	"time"
+)
+
+// smallOptions keeps tables and levels tiny, so that a few thousand writes exercise several levels of compaction.
+var smallOptions = &Options{
+	WriteBufferSize:      256,
+	MaxFileSize:          512,
+	L0CompactionTrigger:  2,
+	MaxBytesForLevelBase: 1024,
+}
+
+func TestCompaction_Leveled(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		rng      = rand.New(rand.NewPCG(1, 2))
+		expected = make(map[string]string)
+	)
+	database, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	for j := range 3000 {
+		var key = fmt.Sprintf("key%04d", rng.IntN(400))
+		if rng.IntN(5) == 0 {
+			_ = database.Delete(leveldb.Key(key)) // may fail for keys already deleted in the memTable
+			delete(expected, key)
+			continue
+		}
+		var value = fmt.Sprintf("value%05d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value(value)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+		expected[key] = value
+	}
+
+	var current = database.(*db).current
+	checkLevels(t, current)
+	if len(current.levels[0]) >= smallOptions.L0CompactionTrigger {
+		t.Errorf("expected level 0 to have been compacted, found %d tables", len(current.levels[0]))
+	}
+	if len(current.levels[2]) == 0 {
+		t.Error("expected compaction to have reached level 2")
+	}
+	checkContents(t, database, expected)
+
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	reopened, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	checkLevels(t, reopened.(*db).current)
+	checkContents(t, reopened, expected)
+	if _, tables := countFiles(t, dir); tables != reopened.(*db).current.numFiles() {
+		t.Errorf("expected only live tables on disk, found %d for %d live", tables, reopened.(*db).current.numFiles())
+	}
+}
+
+// TestCompaction_LeveledRangeDeletes mixes range deletes into the writes of TestCompaction_Leveled, so that range
+// tombstones are compacted down through the levels alongside the keys they delete.
+func TestCompaction_LeveledRangeDeletes(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		rng      = rand.New(rand.NewPCG(5, 6))
+		expected = make(map[string]string)
+	)
+	database, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	for j := range 3000 {
+		var from = rng.IntN(400)
+		if rng.IntN(50) == 0 {
+			var to = min(from+rng.IntN(30), 399)
+			var start, limit = fmt.Sprintf("key%04d", from), fmt.Sprintf("key%04d", to)
+			if err := database.(RangeDeleter).DeleteRange(leveldb.Key(start), leveldb.Key(limit)); err != nil {
+				t.Fatal("unexpected error executing DeleteRange()", err)
+			}
+			for key := range expected {
+				if key >= start && key <= limit {
+					delete(expected, key)
+				}
+			}
+			continue
+		}
+		var key, value = fmt.Sprintf("key%04d", from), fmt.Sprintf("value%05d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value(value)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+		expected[key] = value
+	}
+
+	checkLevels(t, database.(*db).current)
+	checkContents(t, database, expected)
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	reopened, err := Open(dir, smallOptions)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	checkLevels(t, reopened.(*db).current)
+	checkContents(t, reopened, expected)
+}
+
+func TestCompaction_Tombstones(t *testing.T) {
+	tests := []struct {
+		name string
+		// deeper says whether a level below the compaction's output holds the tombstoned key
+		deeper bool
+	}{
+		{name: "DroppedAtBaseLevel"},
+		{name: "KeptAboveOlderValue", deeper: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			database, err := Open(t.TempDir(), nil)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			defer func() { _ = database.Close() }()
+			var db = database.(*db)
+
+			if tc.deeper {
+				writeKeys(t, db, "key", 0, 3)
+				flushAndMoveTo(t, db, 3)
+			}
+			if err := db.Delete(leveldb.Key("key001")); err != nil {
+				t.Fatal("unexpected error executing Delete()", err)
+			}
+			writeKeys(t, db, "other", 0, 2)
+			flushAndMoveTo(t, db, 1)
+
+			// compact level 1 into level 2
+			var c = &compaction{level: 1, outputLevel: 2, inputs: [2][]*fileMetadata{db.current.levels[1], nil}}
+			c.inputs[1] = append(c.inputs[1], writeTable(t, db, 2, "other", 5, 6))
+			c.olderTables = db.current.levels[3]
+			if err := db.runCompaction(c); err != nil {
+				t.Fatal("unexpected error compacting:", err)
+			}
+
+			var tombstones int
+			for _, meta := range db.current.levels[2] {
+				var iterator = scanTable(t, db, meta)
+				for iterator.Next() {
+					if internalKey(iterator.Key()).kind() == kindDelete {
+						tombstones++
+					}
+				}
+			}
+			if tc.deeper && tombstones != 1 {
+				t.Errorf("expected tombstone to be kept above the value it hides, found %d tombstones", tombstones)
+			} else if !tc.deeper && tombstones != 0 {
+				t.Errorf("expected tombstone to be dropped at the base level, found %d tombstones", tombstones)
+			}
+			if val, err := db.Get(leveldb.Key("key001")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected deleted key to stay deleted, got %q (err %v)", val, err)
+			}
+		})
+	}
+}
+
+func TestCompaction_RangeTombstones(t *testing.T) {
+	tests := []struct {
+		name string
+		// deeper says whether a level below the compaction's output holds keys in the deleted range
+		deeper bool
+		// maxOutputFileSize is small enough to cut the output after every key
+		maxOutputFileSize int
+	}{
+		{name: "DroppedAtBaseLevel"},
+		{name: "KeptAboveOlderValues", deeper: true},
+		{name: "NotSplitAcrossOutputs", deeper: true, maxOutputFileSize: 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			database, err := Open(t.TempDir(), nil)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			defer func() { _ = database.Close() }()
+			var db = database.(*db)
+
+			if tc.deeper {
+				writeKeys(t, db, "key", 0, 3)
+				flushAndMoveTo(t, db, 3)
+			}
+			writeKeys(t, db, "key", 10, 13)
+			if err := db.DeleteRange(leveldb.Key("key000"), leveldb.Key("key019")); err != nil {
+				t.Fatal("unexpected error executing DeleteRange()", err)
+			}
+			writeKeys(t, db, "key", 15, 17)
+			writeKeys(t, db, "other", 0, 2)
+			flushAndMoveTo(t, db, 1)
+
+			// compact level 1 into level 2
+			var c = &compaction{
+				level:             1,
+				outputLevel:       2,
+				inputs:            [2][]*fileMetadata{db.current.levels[1], nil},
+				maxOutputFileSize: tc.maxOutputFileSize,
+			}
+			c.inputs[1] = append(c.inputs[1], writeTable(t, db, 2, "other", 5, 6))
+			c.olderTables = db.current.levels[3]
+			if err := db.runCompaction(c); err != nil {
+				t.Fatal("unexpected error compacting:", err)
+			}
+			checkLevels(t, db.current)
+
+			var (
+				keys       []string
+				tombstones int
+			)
+			for _, meta := range db.current.levels[2] {
+				var iterator = scanTable(t, db, meta)
+				for iterator.Next() {
+					keys = append(keys, string(internalKey(iterator.Key()).userKey()))
+				}
+				handle, err := db.tables.acquire(meta.number)
+				if err != nil {
+					t.Fatal("unexpected error opening table:", err)
+				}
+				tombstones += len(handle.tombstones)
+				_ = handle.release()
+			}
+			if fmt.Sprint(keys) != "[key015 key016 other000 other001 other005]" {
+				t.Errorf("expected the keys the range tombstone deletes to be dropped, got %v", keys)
+			}
+			if tc.deeper && tombstones != 1 {
+				t.Errorf("expected range tombstone to be kept above the values it hides, found %d", tombstones)
+			} else if !tc.deeper && tombstones != 0 {
+				t.Errorf("expected range tombstone to be dropped at the base level, found %d", tombstones)
+			}
+			for _, key := range []string{"key001", "key011"} {
+				if val, err := db.Get(leveldb.Key(key)); !errors.Is(err, leveldb.ErrKeyNotFound) {
+					t.Errorf("expected %q to stay deleted, got %q (err %v)", key, val, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompaction_ExpiredValues(t *testing.T) {
+	tests := []struct {
+		name string
+		// deeper says whether a level below the compaction's output holds the expired key
+		deeper bool
+	}{
+		{name: "DroppedAtBaseLevel"},
+		{name: "TombstonedAboveOlderValue", deeper: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var clock = &testClock{time: time.Unix(1_700_000_000, 0)}
+			database, err := Open(t.TempDir(), &Options{now: clock.now})
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			defer func() { _ = database.Close() }()
+			var db = database.(*db)
+
+			if tc.deeper {
+				writeKeys(t, db, "key", 0, 3)
+				flushAndMoveTo(t, db, 3)
+			}
+			if err := db.PutWithTTL(leveldb.Key("key001"), leveldb.Value("expiring"), time.Minute); err != nil {
+				t.Fatal("unexpected error executing PutWithTTL()", err)
+			}
+			if err := db.PutWithTTL(leveldb.Key("key002"), leveldb.Value("lasting"), time.Hour); err != nil {
+				t.Fatal("unexpected error executing PutWithTTL()", err)
+			}
+			flushAndMoveTo(t, db, 1)
+			clock.time = clock.time.Add(2 * time.Minute)
+
+			// compact level 1 into level 2
+			var c = &compaction{level: 1, outputLevel: 2, inputs: [2][]*fileMetadata{db.current.levels[1], nil}}
+			c.inputs[1] = append(c.inputs[1], writeTable(t, db, 2, "other", 5, 6))
+			c.olderTables = db.current.levels[3]
+			if err := db.runCompaction(c); err != nil {
+				t.Fatal("unexpected error compacting:", err)
+			}
+
+			var kinds []keyKind
+			for _, meta := range db.current.levels[2] {
+				var iterator = scanTable(t, db, meta)
+				for iterator.Next() {
+					kinds = append(kinds, internalKey(iterator.Key()).kind())
+				}
+			}
+			// the expired key001 is dropped or left as a tombstone, while key002 keeps its expiring value
+			var expected = []keyKind{kindValueWithExpiry, kindValue}
+			if tc.deeper {
+				expected = []keyKind{kindDelete, kindValueWithExpiry, kindValue}
+			}
+			if !slices.Equal(kinds, expected) {
+				t.Errorf("expected entries of kinds %v after compacting, got %v", expected, kinds)
+			}
+			if val, err := db.Get(leveldb.Key("key001")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected expired key to stay gone, got %q (err %v)", val, err)
+			}
+			if val, err := db.Get(leveldb.Key("key002")); err != nil || string(val) != "lasting" {
+				t.Errorf("expected %q before it expires, got %q (err %v)", "lasting", val, err)
+			}
+		})
+	}
+}
+
+func TestCompaction_SizeTiered(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		rng      = rand.New(rand.NewPCG(3, 4))
+		expected = make(map[string]string)
+		options  = &Options{
+			WriteBufferSize:        256,
+			CompactionStyle:        SizeTieredCompaction,
+			SizeTieredMinThreshold: 3,
+		}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	for j := range 3000 {
+		var key = fmt.Sprintf("key%04d", rng.IntN(400))
+		if rng.IntN(5) == 0 {
+			_ = database.Delete(leveldb.Key(key)) // may fail for keys already deleted in the memTable
+			delete(expected, key)
+			continue
+		}
+		var value = fmt.Sprintf("value%05d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value(value)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+		expected[key] = value
+	}
+
+	var current = database.(*db).current
+	for level := 1; level < numLevels; level++ {
+		if len(current.levels[level]) > 0 {
+			t.Errorf("expected every table to stay in level 0, found %d in level %d", len(current.levels[level]), level)
+		}
+	}
+	if picked := database.(*db).strategy.pickCompaction(current); picked != nil {
+		t.Errorf("expected no bucket left due for compaction, found one of %d tables", len(picked.inputs[0]))
+	}
+	var stats = database.(StatsReporter).Stats()
+	if stats.BytesCompacted == 0 || stats.WriteAmplification <= 1 {
+		t.Errorf("expected compaction to have rewritten data, got %+v", stats)
+	}
+	if stats.ReadAmplification != len(current.levels[0]) || stats.Tables[0] != len(current.levels[0]) {
+		t.Errorf("expected read amplification of one per run (%d), got %+v", len(current.levels[0]), stats)
+	}
+	checkContents(t, database, expected)
+
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	reopened, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	checkContents(t, reopened, expected)
+}
+
+func TestVersion_ApplyKeepsLevel0Order(t *testing.T) {
+	var v = new(version)
+	for number := uint64(1); number <= 4; number++ {
+		var edit = new(versionEdit)
+		edit.addFile(0, &fileMetadata{number: number})
+		v = v.apply(edit)
+	}
+
+	// merging the middle two tables puts the output between the oldest and the newest
+	var edit = new(versionEdit)
+	edit.deleteFile(0, 2)
+	edit.deleteFile(0, 3)
+	edit.addFile(0, &fileMetadata{number: 5})
+	v = v.apply(edit)
+
+	var numbers []uint64
+	for _, meta := range v.levels[0] {
+		numbers = append(numbers, meta.number)
+	}
+	if !slices.Equal(numbers, []uint64{1, 5, 4}) {
+		t.Errorf("expected level 0 to be ordered [1 5 4], got %v", numbers)
+	}
+}
+
+func TestCompaction_MergeOperands(t *testing.T) {
+	tests := []struct {
+		name string
+		// deeper says whether a level below the compaction's output holds the merged key
+		deeper bool
+		// put says whether the inputs hold a value for the operands to be merged into
+		put      bool
+		expected string
+		// kinds are those of the entries left for the merged key
+		kinds []keyKind
+	}{
+		{name: "MergedAtBaseLevel", expected: "1,2", kinds: []keyKind{kindValue}},
+		{name: "MergedIntoValue", deeper: true, put: true, expected: "base,1,2", kinds: []keyKind{kindValue}},
+		{
+			name:     "StackedAboveOlderValues",
+			deeper:   true,
+			expected: "value of key001,1,2",
+			kinds:    []keyKind{kindMerge, kindMerge},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			database, err := Open(t.TempDir(), &Options{MergeOperator: appendOperator{}})
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			defer func() { _ = database.Close() }()
+			var db = database.(*db)
+
+			if tc.deeper {
+				writeKeys(t, db, "key", 0, 3)
+				flushAndMoveTo(t, db, 3)
+			}
+			if tc.put {
+				if err := db.Put(leveldb.Key("key001"), leveldb.Value("base")); err != nil {
+					t.Fatal("unexpected error executing Put()", err)
+				}
+			}
+			for _, operand := range []string{"1", "2"} {
+				if err := db.Merge(leveldb.Key("key001"), leveldb.Value(operand)); err != nil {
+					t.Fatal("unexpected error executing Merge()", err)
+				}
+			}
+			flushAndMoveTo(t, db, 1)
+
+			// compact level 1 into level 2
+			var c = &compaction{level: 1, outputLevel: 2, inputs: [2][]*fileMetadata{db.current.levels[1], nil}}
+			c.inputs[1] = append(c.inputs[1], writeTable(t, db, 2, "other", 5, 6))
+			c.olderTables = db.current.levels[3]
+			if err := db.runCompaction(c); err != nil {
+				t.Fatal("unexpected error compacting:", err)
+			}
+
+			var kinds []keyKind
+			for _, meta := range db.current.levels[2] {
+				var iterator = scanTable(t, db, meta)
+				for iterator.Next() {
+					if key := internalKey(iterator.Key()); string(key.userKey()) == "key001" {
+						kinds = append(kinds, key.kind())
+					}
+				}
+			}
+			if !slices.Equal(kinds, tc.kinds) {
+				t.Errorf("expected entries of kinds %v after compacting, got %v", tc.kinds, kinds)
+			}
+			if val, err := db.Get(leveldb.Key("key001")); err != nil || string(val) != tc.expected {
+				t.Errorf("expected %q once compacted, got %q (err %v)", tc.expected, val, err)
+			}
+		})
+	}
+}
+
+// flushAndMoveTo flushes the memTable and moves the resulting table straight to the given level.
+func flushAndMoveTo(t *testing.T, db *db, level int) {
+	t.Helper()
+	if err := db.compactMemTable(); err != nil {
+		t.Fatal("unexpected error flushing memtable:", err)
+	}
+	var meta = db.current.levels[0][len(db.current.levels[0])-1]
+	var edit = new(versionEdit)
+	edit.deleteFile(0, meta.number)
+	edit.addFile(level, meta)
+	if err := db.manifest.append(edit); err != nil {
+		t.Fatal("unexpected error appending to manifest:", err)
+	}
+	db.installVersion(db.current.apply(edit))
+}
+
+// writeTable writes keys [from, to) with the given prefix straight into a table at the given level, numbering them as
+// the next writes.
+func writeTable(t *testing.T, db *db, level int, prefix string, from int, to int) *fileMetadata {
+	t.Helper()
+	output, err := db.newCompactionOutput()
+	if err != nil {
+		t.Fatal("unexpected error creating table:", err)
+	}
+	for j := from; j < to; j++ {
+		var key = fmt.Sprintf("%s%03d", prefix, j)
+		db.lastSequence++
+		var internal = makeInternalKey(leveldb.Key(key), db.lastSequence, kindValue)
+		if err := output.add(internal, leveldb.Value("value of "+key)); err != nil {
+			t.Fatal("unexpected error adding to table:", err)
+		}
+	}
+	meta, err := output.finish(db.tables)
+	if err != nil {
+		t.Fatal("unexpected error finishing table:", err)
+	}
+	var edit = new(versionEdit)
+	edit.addFile(level, meta)
+	if err := db.manifest.append(edit); err != nil {
+		t.Fatal("unexpected error appending to manifest:", err)
+	}
+	db.installVersion(db.current.apply(edit))
+	return meta
+}
+
+// scanTable iterates over every entry in a table, deletes included, keeping the table open until the test ends.
+func scanTable(t *testing.T, db *db, meta *fileMetadata) leveldb.Iterator {
+	t.Helper()
+	handle, err := db.tables.acquire(meta.number)
+	if err != nil {
+		t.Fatal("unexpected error opening table:", err)
+	}
+	t.Cleanup(func() { _ = handle.release() })
+	iterator, err := handle.scan(meta.smallest, meta.largest)
+	if err != nil {
+		t.Fatal("unexpected error scanning table:", err)
+	}
+	t.Cleanup(func() { _ = iterator.Close() })
+	return iterator
+}
+
+// checkLevels verifies that every level below 0 is sorted and free of overlapping tables.
+func checkLevels(t *testing.T, v *version) {
+	t.Helper()
+	for level := 1; level < numLevels; level++ {
+		for j := 1; j < len(v.levels[level]); j++ {
+			var prev, curr = v.levels[level][j-1], v.levels[level][j]
+			if bytes.Compare(prev.largest, curr.smallest) >= 0 {
+				t.Errorf(
+					"level %d: table %d [%q, %q] overlaps or precedes table %d [%q, %q]",
+					level, curr.number, curr.smallest, curr.largest, prev.number, prev.smallest, prev.largest,
+				)
+			}
+		}
+	}
+}
+
+// checkContents verifies that Get and RangeScan agree with the expected contents.
+func checkContents(t *testing.T, database leveldb.DB, expected map[string]string) {
+	t.Helper()
+	var keys = make([]string, 0, len(expected))
+	for key, value := range expected {
+		keys = append(keys, key)
+		val, err := database.Get(leveldb.Key(key))
+		if err != nil {
+			t.Errorf("unexpected error getting %q: %v", key, err)
+		} else if string(val) != value {
+			t.Errorf("expected %q=%q, got %q", key, value, val)
+		}
+	}
+	slices.Sort(keys)
+
+	results, err := database.RangeScan(leveldb.Key(""), leveldb.Key("\xff"))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	defer results.Close()
+	var j int
+	for ; results.Next(); j++ {
+		if j >= len(keys) {
+			t.Fatalf("got more results than expected: %q=%q", results.Key(), results.Value())
+		}
+		if string(results.Key()) != keys[j] || string(results.Value()) != expected[keys[j]] {
+			t.Errorf("expected %q=%q, got %q=%q", keys[j], expected[keys[j]], results.Key(), results.Value())
+		}
+	}
+	if err := results.Error(); err != nil {
+		t.Fatal("iterator generated unexpected error", err)
+	}
+	if j != len(keys) {
+		t.Errorf("expected %d results, got %d", len(keys), j)
+	}
+}
@@ -0,0 +1,306 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"leveldb"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+// These tests are meant to be run with -race as well, which checks the synchronization they exercise.
+
+const (
+	stressWriters = 4
+	stressReaders = 4
+	stressKeys    = 50
+	stressRounds  = 20
+)
+
+// stressOptions keep the memTable and tables small, so that the writers flush and compact throughout.
+var stressOptions = &Options{
+	WriteBufferSize:      1 << 10,
+	MaxFileSize:          4 << 10,
+	L0CompactionTrigger:  2,
+	MaxBytesForLevelBase: 16 << 10,
+}
+
+func stressKey(writer int, j int) leveldb.Key {
+	return leveldb.Key(fmt.Sprintf("w%d-key%03d", writer, j))
+}
+
+// stressRound returns the round a value was written in.
+func stressRound(value leveldb.Value) (int, error) {
+	var round int
+	if _, err := fmt.Sscanf(string(value), "round %03d", &round); err != nil {
+		return 0, fmt.Errorf("unexpected value %q", value)
+	}
+	return round, nil
+}
+
+// TestDb_ConcurrentReadersAndWriters has writers overwrite their keys round after round, alternating between batches
+// and single puts, while readers check that what they see is consistent: a key never goes back to an older round, a
+// scan sees a single point in time, and so do repeated reads from a snapshot.
+func TestDb_ConcurrentReadersAndWriters(t *testing.T) {
+	var setups = []struct {
+		name string
+		open func(t *testing.T) leveldb.DB
+	}{
+		{name: "InMemory", open: func(t *testing.T) leveldb.DB { return NewInMemoryDb(nil) }},
+		{name: "NewDb", open: func(t *testing.T) leveldb.DB { return NewDb(nil) }},
+		{
+			name: "Open",
+			open: func(t *testing.T) leveldb.DB {
+				database, err := Open(t.TempDir(), stressOptions)
+				if err != nil {
+					t.Fatal("unexpected error opening database:", err)
+				}
+				return database
+			},
+		},
+		{
+			name: "OpenLockedMemTable",
+			open: func(t *testing.T) leveldb.DB {
+				var opts = *stressOptions
+				opts.MemTable = LockedMemTable
+				database, err := Open(t.TempDir(), &opts)
+				if err != nil {
+					t.Fatal("unexpected error opening database:", err)
+				}
+				return database
+			},
+		},
+	}
+	for _, setup := range setups {
+		t.Run(setup.name, func(t *testing.T) {
+			var database = setup.open(t)
+			defer func() { _ = database.Close() }()
+
+			var writers, readers sync.WaitGroup
+			var done = make(chan struct{})
+			for w := 0; w < stressWriters; w++ {
+				writers.Add(1)
+				go func() {
+					defer writers.Done()
+					if err := writeRounds(database, w); err != nil {
+						t.Errorf("writer %d: %v", w, err)
+					}
+				}()
+			}
+			for r := 0; r < stressReaders; r++ {
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					if err := readUntilDone(database, rand.New(rand.NewSource(int64(r))), done); err != nil {
+						t.Errorf("reader %d: %v", r, err)
+					}
+				}()
+			}
+			writers.Wait()
+			close(done)
+			readers.Wait()
+
+			var expected = make(map[string]string)
+			for w := 0; w < stressWriters; w++ {
+				for j := 0; j < stressKeys; j++ {
+					expected[string(stressKey(w, j))] = fmt.Sprintf("round %03d", stressRounds-1)
+				}
+			}
+			checkContents(t, database, expected)
+		})
+	}
+}
+
+// writeRounds writes each of the writer's keys once per round: with a single batch in even rounds, and one put at a
+// time in key order in odd ones.  Before the last round, every other key is deleted, to be written again by it.
+func writeRounds(database leveldb.DB, writer int) error {
+	for round := 0; round < stressRounds; round++ {
+		var value = leveldb.Value(fmt.Sprintf("round %03d", round))
+		if round%2 == 0 {
+			var batch leveldb.WriteBatch
+			for j := 0; j < stressKeys; j++ {
+				batch.Put(stressKey(writer, j), value)
+			}
+			if err := database.Write(&batch); err != nil {
+				return err
+			}
+			continue
+		}
+		if round == stressRounds-1 {
+			for j := 0; j < stressKeys; j += 2 {
+				if err := database.Delete(stressKey(writer, j)); err != nil {
+					return err
+				}
+			}
+		}
+		for j := 0; j < stressKeys; j++ {
+			if err := database.Put(stressKey(writer, j), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readUntilDone runs random reads until done is closed.
+func readUntilDone(database leveldb.DB, random *rand.Rand, done <-chan struct{}) error {
+	// lastRounds holds the latest round seen for each key, which later reads must not go back on
+	var lastRounds = make(map[string]int)
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		var writer = random.Intn(stressWriters)
+		var err error
+		switch random.Intn(3) {
+		case 0:
+			err = checkGet(database, stressKey(writer, random.Intn(stressKeys)), lastRounds)
+		case 1:
+			err = checkScan(database, writer)
+		case 2:
+			err = checkSnapshot(database, stressKey(writer, random.Intn(stressKeys)))
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func checkGet(database leveldb.DB, key leveldb.Key, lastRounds map[string]int) error {
+	value, err := database.Get(key)
+	if errors.Is(err, leveldb.ErrKeyNotFound) {
+		return nil // not yet written, or deleted before the last round
+	}
+	if err != nil {
+		return err
+	}
+	round, err := stressRound(value)
+	if err != nil {
+		return err
+	}
+	if last, ok := lastRounds[string(key)]; ok && round < last {
+		return fmt.Errorf("%q went back from round %d to round %d", key, last, round)
+	}
+	lastRounds[string(key)] = round
+	return nil
+}
+
+// checkScan scans the writer's keys, which are written in key order, so at any one point in time the rounds they were
+// last written in only ever drop going through them, and by one at most.
+func checkScan(database leveldb.DB, writer int) error {
+	results, err := database.RangeScan(stressKey(writer, 0), stressKey(writer, stressKeys-1))
+	if err != nil {
+		return err
+	}
+	defer results.Close()
+	var (
+		lastKey               leveldb.Key
+		newest, previousRound = -1, -1
+	)
+	for results.Next() {
+		if lastKey != nil && bytes.Compare(results.Key(), lastKey) <= 0 {
+			return fmt.Errorf("scan returned %q after %q", results.Key(), lastKey)
+		}
+		lastKey = results.Key()
+		round, err := stressRound(results.Value())
+		if err != nil {
+			return err
+		}
+		if newest < 0 {
+			newest = round
+		}
+		if (previousRound >= 0 && round > previousRound) || round < newest-1 {
+			return fmt.Errorf("scan saw %q at round %d, after rounds %d to %d", results.Key(), round, newest, previousRound)
+		}
+		previousRound = round
+	}
+	return results.Error()
+}
+
+// checkSnapshot reads the key twice from a snapshot, expecting the same result both times.
+func checkSnapshot(database leveldb.DB, key leveldb.Key) error {
+	var snapshot = database.GetSnapshot()
+	defer snapshot.Release()
+	first, firstErr := database.Get(key, leveldb.WithSnapshot(snapshot))
+	for j := 0; j < 10; j++ {
+		if _, err := database.Get(key); err != nil && !errors.Is(err, leveldb.ErrKeyNotFound) {
+			return err
+		}
+	}
+	second, secondErr := database.Get(key, leveldb.WithSnapshot(snapshot))
+	var firstFound, secondFound = firstErr == nil, secondErr == nil
+	if !bytes.Equal(first, second) || firstFound != secondFound {
+		return fmt.Errorf("snapshot read %q as %q (err %v), then as %q (err %v)", key, first, firstErr, second, secondErr)
+	}
+	return nil
+}
+
+// TestDb_IteratorOutlivesCompaction checks that an iterator keeps reading the tables it started with after writes
+// flush and compact them away, and lets go of them once closed.
+func TestDb_IteratorOutlivesCompaction(t *testing.T) {
+	database, err := Open(t.TempDir(), stressOptions)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	writeKeys(t, database, "key", 0, 200)
+
+	var (
+		impl = database.(*db)
+		// tableNumbers returns the numbers of the tables in the current version
+		tableNumbers = func() map[uint64]bool {
+			impl.mu.Lock()
+			defer impl.mu.Unlock()
+			var numbers = make(map[uint64]bool)
+			for _, level := range impl.current.levels {
+				for _, meta := range level {
+					numbers[meta.number] = true
+				}
+			}
+			return numbers
+		}
+		scanned = tableNumbers()
+	)
+	results, err := database.RangeScan(leveldb.Key("key"), leveldb.Key("key999"))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	var count int
+	for ; count < 10 && results.Next(); count++ {
+	}
+	for j := 0; j < 200; j++ {
+		var key = fmt.Sprintf("key%03d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value("overwritten")); err != nil {
+			t.Fatal("unexpected error overwriting key:", err)
+		}
+	}
+	if stats := database.(StatsReporter).Stats(); stats.BytesCompacted == 0 {
+		t.Fatal("expected the overwrites to be compacted, got no compaction")
+	}
+
+	for ; results.Next(); count++ {
+		if expected := "value of " + string(results.Key()); string(results.Value()) != expected {
+			t.Errorf("expected %q=%q, got %q", results.Key(), expected, results.Value())
+		}
+	}
+	if err := results.Error(); err != nil {
+		t.Fatal("iterator generated unexpected error", err)
+	}
+	if count != 200 {
+		t.Errorf("expected the 200 keys present when the scan started, got %d", count)
+	}
+
+	if err := results.Close(); err != nil {
+		t.Fatal("unexpected error closing iterator:", err)
+	}
+	var current = tableNumbers()
+	for number := range scanned {
+		if !current[number] && impl.tables.live(number) {
+			t.Errorf("expected table %d, since compacted away, to be obsolete once the iterator is closed", number)
+		}
+	}
+}
@@ -1,7 +1,8 @@
 package db
 
 import (
-	"bufio"
+	"bytes"
+	"container/list"
 	"errors"
 	"fmt"
 	"io"
@@ -11,39 +12,93 @@ import (
 	"leveldb/sst"
 	"leveldb/wal"
 	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
 )
 
+// db is safe for concurrent use.  Writers take turns, but readers never wait on them: a read loads the memTables,
+// version and sequence number current when it starts, then goes on without any lock held, seeing only the writes
+// published by then.
 type db struct {
-	memTable   *skiplist.SkipList
-	tombstones *skiplist.SkipList
-	ssTables   []leveldb.ReadOnlyDB
-	wal        *wal.Log
+	// columnFamily is the default column family, which reads and writes go to unless they name another
+	*columnFamily
+	// writeMu serializes writers, along with the flushes and compactions they run, and is held across their I/O.  The
+	// fields below, and those of each family, are only modified while holding it.
+	writeMu sync.Mutex
+	// mu guards the fields readers load, families and each one's memTable, immutable and current, lastSequence and
+	// snapshots, so that they are seen together.  It is only held briefly, never across I/O.
+	mu  sync.Mutex
+	wal *wal.Log
+	// options configures the database as a whole, along with the default family
+	options *Options
+	stats   stats
+	// blockCache is shared by every table, or nil if disabled
+	blockCache *sst.BlockCache
+	// families holds the live column families by ID, the default one included
+	families map[uint32]*columnFamily
+	// lastSequence is the sequence number of the last write published, and the newest one readers see
+	lastSequence uint64
+	// unpublished counts the writes logged to the WAL but not yet published, which are waiting on their sync or their
+	// turn to be applied.  They are numbered after lastSequence.
+	unpublished uint64
+	// published is signalled whenever lastSequence advances
+	published *sync.Cond
+	// snapshots holds the live snapshots, oldest first
+	snapshots *list.List
+
+	// The remaining fields are only set for databases created by Open, which manage their own directory.
+	dir string
+	// logFile is the WAL segment currently being written, numbered logFileNumber.
+	logFile       *os.File
+	logFileNumber uint64
+	// logNumber is the oldest WAL segment holding entries that have not been flushed, as recorded in the manifest.
+	logNumber      uint64
+	manifest       *manifestWriter
+	nextFileNumber uint64
+	// nextFamily is the ID the next column family created takes
+	nextFamily uint32
 }
 
 func NewDbFromWal(rw io.ReadWriter) (leveldb.DB, error) {
-	reader := bufio.NewReader(rw)
-	entries, err := encoding.DecodeLogFile(reader)
+	return NewDbFromWalWithOptions(rw, nil)
+}
+
+// NewDbFromWalWithOptions is like NewDbFromWal, but takes options; of those, only StrictRecovery and the WAL sync
+// settings apply.  The log is recovered up to its last intact record, and the bytes dropped reported by Stats.  Those
+// after the last intact record, a torn record or zero padding, are truncated away so that new records follow on from
+// the intact ones, which needs rw to support Truncate and Seek, as *os.File does.  Damaged records that intact ones
+// follow are skipped, and left in place.
+func NewDbFromWalWithOptions(rw io.ReadWriter, opts *Options) (leveldb.DB, error) {
+	opts = opts.withDefaults()
+	recovered, err := wal.ReadLog(rw, opts.StrictRecovery)
 	if err != nil {
 		return nil, err
 	}
-	db := NewDb(rw)
-	for _, entry := range entries {
-		switch entry.Operation {
-		case encoding.OpPut:
-			if err := db.Put(
-				leveldb.Key(entry.Key),
-				leveldb.Value(entry.Value),
-			); err != nil {
-				return nil, err
-			}
-		case encoding.OpDelete:
-			if err := db.Delete(leveldb.Key(entry.Key)); err != nil {
-				return nil, err
-			}
-		default:
-			return nil, fmt.Errorf("unrecognized opcode %s", entry.Operation)
+	if recovered.Discarded > 0 {
+		truncater, ok := rw.(interface {
+			io.Seeker
+			Truncate(size int64) error
+		})
+		if !ok {
+			return nil, fmt.Errorf(
+				"db.NewDbFromWal: cannot drop the %d bytes after the last intact record from a %T",
+				recovered.Discarded, rw,
+			)
+		}
+		if err := truncater.Truncate(recovered.Size); err != nil {
+			return nil, fmt.Errorf("db.NewDbFromWal: error dropping the bytes after the last intact record: %v", err)
+		}
+		if _, err := truncater.Seek(recovered.Size, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("db.NewDbFromWal: error seeking to the end of the log: %v", err)
 		}
 	}
+	var db = newDb(wal.NewLogAt(rw, recovered.Size, opts.walOptions()...), opts)
+	db.stats.walBytesDiscarded = uint64(recovered.Discarded + recovered.Skipped)
+	if err := db.replay(recovered.Operations); err != nil {
+		return nil, err
+	}
 	return db, nil
 }
 
@@ -53,19 +108,200 @@ func NewDb(walLog io.ReadWriter) leveldb.DB {
 		log = wal.NewLog(walLog)
 	}
 
-	return &db{
-		memTable:   skiplist.NewSkipList(),
-		tombstones: skiplist.NewSkipList(),
+	return newDb(log, (*Options)(nil).withDefaults())
+}
+
+// newDb returns an empty database with no SSTables, logging to log unless it is nil.  opts must have their defaults
+// filled in.
+func newDb(log *wal.Log, opts *Options) *db {
+	var db = &db{
 		wal:        log,
+		options:    opts,
+		snapshots:  list.New(),
+		blockCache: opts.newBlockCache(),
 	}
+	db.published = sync.NewCond(&db.mu)
+	db.addDefaultFamily()
+	return db
 }
 
-func (db *db) Get(key leveldb.Key) (leveldb.Value, error) {
-	return db.memTable.Search(key)
+// addDefaultFamily starts the database off with the default column family alone.
+func (db *db) addDefaultFamily() {
+	db.columnFamily = newColumnFamily(db, 0, DefaultColumnFamily, db.options)
+	db.families = map[uint32]*columnFamily{0: db.columnFamily}
+	db.nextFamily = 1
 }
 
-func (db *db) Has(key leveldb.Key) (bool, error) {
-	val, err := db.memTable.Search(key)
+// Open opens the database stored in dir, creating it if need be.  The directory holds numbered WAL segments and
+// SSTables, along with a MANIFEST recording which of those files are live and a CURRENT file naming the MANIFEST.
+func Open(dir string, opts *Options) (leveldb.DB, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("db.Open: error creating directory: %v", err)
+	}
+	opts = opts.withDefaults()
+	var db = &db{
+		snapshots:      list.New(),
+		dir:            dir,
+		options:        opts,
+		nextFileNumber: 1,
+		blockCache:     opts.newBlockCache(),
+	}
+	db.published = sync.NewCond(&db.mu)
+	db.addDefaultFamily()
+	if err := db.recover(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("db.Open: %v", err)
+	}
+	return db, nil
+}
+
+// readState is what a read sees: the memTables and version current when it started, and the sequence number of the
+// last write visible to it.  now is the time, in unix nanoseconds, by which it takes values to have expired.
+// bypassCache has the tables it reads leave their blocks out of the block cache.  merger combines the merge operands
+// it finds.
+type readState struct {
+	memTables   []*memTable
+	current     *version
+	seq         uint64
+	now         int64
+	bypassCache bool
+	merger      leveldb.MergeOperator
+}
+
+// loadReadState returns the state a read with the given options sees, in the column family they name.  It holds a
+// reference to its version, which the caller drops with release once done reading.
+func (db *db) loadReadState(opts []leveldb.ReadOption) (*readState, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	seq, err := db.readSequence(opts)
+	if err != nil {
+		return nil, err
+	}
+	var readOptions = leveldb.NewReadOptions(opts...)
+	cf, err := db.family(readOptions.ColumnFamily)
+	if err != nil {
+		return nil, err
+	}
+	var state = &readState{
+		memTables:   []*memTable{cf.memTable},
+		current:     cf.current,
+		seq:         seq,
+		now:         db.options.now().UnixNano(),
+		bypassCache: readOptions.BypassCache,
+		merger:      cf.options.MergeOperator,
+	}
+	if cf.immutable != nil {
+		state.memTables = append(state.memTables, cf.immutable)
+	}
+	state.current.ref()
+	return state, nil
+}
+
+func (state *readState) release() {
+	state.current.unref()
+}
+
+// Get consults the memTables, then each SSTable that may hold the key from newest to oldest.  The first layer holding
+// a write to the key visible to the read decides the result, so a delete in a newer layer hides a value in an older
+// one.
+func (db *db) Get(key leveldb.Key, opts ...leveldb.ReadOption) (leveldb.Value, error) {
+	state, err := db.loadReadState(opts)
+	if err != nil {
+		return nil, fmt.Errorf("db.Get: %v", err)
+	}
+	defer state.release()
+	return state.get(key)
+}
+
+// get returns the value of the newest write to key visible to the read.  A merge operand takes the writes below it to
+// work out the value, so once one is found the key is read with a scan instead.
+func (state *readState) get(key leveldb.Key) (leveldb.Value, error) {
+	var (
+		lookup     = lookupKey(key, state.seq)
+		comparator = state.current.comparator
+	)
+	for _, mem := range state.memTables {
+		var deletedBefore = mem.rangeTombstones().deletedBefore(key, state.seq, comparator)
+		foundKey, value, _, err := mem.find(lookup)
+		if err != nil {
+			return nil, err
+		}
+		if value, decided, err := resolve(key, foundKey, value, deletedBefore, state.now); decided {
+			return state.orMerged(key, value, err)
+		}
+	}
+
+	for _, meta := range state.current.tablesForKey(key) {
+		foundKey, value, tombstones, err := state.current.tables.find(
+			meta.number,
+			leveldb.Key(lookup),
+			leveldb.WithBypassCache(state.bypassCache),
+		)
+		if err != nil && !errors.Is(err, leveldb.ErrKeyNotFound) {
+			return nil, fmt.Errorf("db.Get: error reading SSTable: %v", err)
+		}
+		var deletedBefore = tombstones.deletedBefore(key, state.seq, comparator)
+		if value, decided, err := resolve(key, internalKey(foundKey), value, deletedBefore, state.now); decided {
+			return state.orMerged(key, value, err)
+		}
+	}
+	return nil, leveldb.NewNotFoundError(key)
+}
+
+// orMerged returns the value and error resolve decided a lookup of key with, unless it found a merge operand, in which
+// case it merges the key's operands instead.
+func (state *readState) orMerged(key leveldb.Key, value leveldb.Value, err error) (leveldb.Value, error) {
+	if !errors.Is(err, errMergeOperand) {
+		return value, err
+	}
+	// closing the iterator releases the tables it read before Get returns, and Get releases the version
+	iterator, err := state.scan(key, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("db.Get: %v", err)
+	}
+	defer func() {
+		// the value is read by then, so a table failing to close loses nothing
+		_ = iterator.Close()
+	}()
+	if !iterator.Next() {
+		if err := iterator.Error(); err != nil {
+			return nil, fmt.Errorf("db.Get: %v", err)
+		}
+		return nil, leveldb.NewNotFoundError(key)
+	}
+	return slices.Clone(iterator.Value()), nil
+}
+
+// resolve decides a lookup for key from what one layer holds: the entry found by the lookup, if any, and the newest of
+// the layer's range tombstones covering key, if any.  The entry is the first at or after the lookup key, so if it
+// belongs to key at all it is the write the lookup sees, unless the tombstone is newer.  It reports whether the layer
+// decides the result, or older layers must be searched.  A range tombstone is only ever older than the writes it
+// covers in newer layers, so a layer holding one decides the result even without an entry for key.  A merge operand
+// decides nothing by itself, and is reported with errMergeOperand.
+func resolve(
+	key leveldb.Key,
+	found internalKey,
+	value leveldb.Value,
+	deletedBefore uint64,
+	now int64,
+) (leveldb.Value, bool, error) {
+	if found != nil && bytes.Equal(found.userKey(), key) && found.sequence() > deletedBefore {
+		if found.kind() == kindMerge {
+			return nil, true, errMergeOperand
+		}
+		if value, live := liveValue(found, value, now); live {
+			return value, true, nil
+		}
+		return nil, true, leveldb.NewNotFoundError(key)
+	}
+	if deletedBefore > 0 {
+		return nil, true, leveldb.NewNotFoundError(key)
+	}
+	return nil, false, nil
+}
+
+func (db *db) Has(key leveldb.Key, opts ...leveldb.ReadOption) (bool, error) {
+	val, err := db.Get(key, opts...)
 	if err != nil { // FIXME: slow because of reflection
 		if errors.Is(err, leveldb.ErrKeyNotFound) {
 			return false, nil
@@ -75,90 +311,896 @@ func (db *db) Has(key leveldb.Key) (bool, error) {
 	return val != nil, nil
 }
 
-func (db *db) Put(key leveldb.Key, value leveldb.Value) error {
+// Put inserts the key and value.  Whether it waits for the WAL to be synced first depends on Options.WALSyncPolicy, and
+// on whether opts include leveldb.WithSync.
+func (db *db) Put(key leveldb.Key, value leveldb.Value, opts ...leveldb.WriteOption) error {
+	if len(key) == 0 {
+		return errors.New("cannot insert blank key")
+	}
+	if len(value) == 0 {
+		return errors.New("cannot insert blank value")
+	}
+	var batch = newSingleWriteBatch(opts)
+	batch.Put(key, value)
+	return db.write(&batch, opts, nil)
+}
+
+// TTLWriter is implemented by databases whose values can be written to expire.
+type TTLWriter interface {
+	// PutWithTTL sets the value for the given key until ttl from now, after which the key reads as deleted.
+	PutWithTTL(key leveldb.Key, value leveldb.Value, ttl time.Duration, opts ...leveldb.WriteOption) error
+}
+
+// PutWithTTL inserts the key and value like Put, but has the value expire once ttl has passed.  The expiry is stored
+// with the value in the WAL, the memTable and SSTables.  Reads made after it stop seeing the value, even through
+// snapshots taken before, and compaction drops it.
+func (db *db) PutWithTTL(key leveldb.Key, value leveldb.Value, ttl time.Duration, opts ...leveldb.WriteOption) error {
+	if len(key) == 0 {
+		return errors.New("cannot insert blank key")
+	}
 	if len(value) == 0 {
 		return errors.New("cannot insert blank value")
 	}
-	if err := db.wal.Put(key, value); err != nil {
+	if ttl <= 0 {
+		return fmt.Errorf("db.PutWithTTL: ttl must be positive, got %v", ttl)
+	}
+	var batch = newSingleWriteBatch(opts)
+	batch.PutWithExpiry(key, value, db.options.now().Add(ttl))
+	return db.write(&batch, opts, nil)
+}
+
+// Delete deletes the key.  Deleting a key whose last write in the memTable is already a delete, a range delete, or a
+// value since expired, is reported as an error; keys only in SSTables, or not present at all, are not checked.
+func (db *db) Delete(key leveldb.Key, opts ...leveldb.WriteOption) error {
+	if len(key) == 0 {
+		return errors.New("cannot delete blank key")
+	}
+	var batch = newSingleWriteBatch(opts)
+	batch.Delete(key)
+	return db.write(&batch, opts, func() error {
+		// the batch has been checked to go to a live family by now
+		var cf = db.families[leveldb.NewWriteOptions(opts...).ColumnFamily]
+		newest, value, _, err := cf.memTable.find(lookupKey(key, maxSequence))
+		if err != nil {
+			return err
+		}
+		var deletedBefore = cf.memTable.rangeTombstones().deletedBefore(key, maxSequence, cf.options.Comparator)
+		_, _, err = resolve(key, newest, value, deletedBefore, db.options.now().UnixNano())
+		if errors.Is(err, leveldb.ErrKeyNotFound) {
+			return fmt.Errorf("db.Delete: %w", err)
+		}
+		return nil
+	})
+}
+
+// RangeDeleter is implemented by databases that can delete a range of keys at once.
+type RangeDeleter interface {
+	// DeleteRange deletes the values for every key in [start, limit], as RangeScan bounds them, whether or not any
+	// are present.
+	DeleteRange(start leveldb.Key, limit leveldb.Key, opts ...leveldb.WriteOption) error
+}
+
+// DeleteRange deletes every key in [start, limit] with a single range tombstone, however many keys it covers.  Unlike
+// Delete, the range need not hold any keys.
+func (db *db) DeleteRange(start leveldb.Key, limit leveldb.Key, opts ...leveldb.WriteOption) error {
+	var batch = newSingleWriteBatch(opts)
+	batch.DeleteRange(start, limit)
+	return db.Write(&batch, opts...)
+}
+
+// Merger is implemented by databases that can merge operands into values.
+type Merger interface {
+	// Merge has operand combined with the value of the given key by the database's MergeOperator.
+	Merge(key leveldb.Key, operand leveldb.Value, opts ...leveldb.WriteOption) error
+}
+
+// Merge stacks operand on the value of key without reading it, as Put writes a value.  Operands are kept in the WAL,
+// the memTable and SSTables, and combined by Options.MergeOperator when the key is read, or by compaction once it
+// reaches the value they were written over or the bottom of the tree.
+func (db *db) Merge(key leveldb.Key, operand leveldb.Value, opts ...leveldb.WriteOption) error {
+	var batch = newSingleWriteBatch(opts)
+	batch.Merge(key, operand)
+	return db.Write(&batch, opts...)
+}
+
+// newSingleWriteBatch returns a batch for a single write, going to the column family opts name.
+func newSingleWriteBatch(opts []leveldb.WriteOption) leveldb.WriteBatch {
+	var batch leveldb.WriteBatch
+	batch.SetColumnFamily(leveldb.NewWriteOptions(opts...).ColumnFamily)
+	return batch
+}
+
+// Write logs the batch as a single WAL record, then applies each of its writes to the memTable of its column family.
+// Its writes take consecutive sequence numbers, which are published together once all of them are in the memTables,
+// so that readers see all of the batch or none of it, whichever families they read.  A batch holding a blank key or
+// value, a write to a family that does not exist, a range to delete that ends before it starts, or a merge without a
+// MergeOperator, is rejected as a whole before anything is written.  Unlike Delete, a batch may delete keys that are
+// not present.
+func (db *db) Write(batch *leveldb.WriteBatch, opts ...leveldb.WriteOption) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+	for _, entry := range batch.Entries() {
+		if len(entry.Key) == 0 {
+			return errors.New("db.Write: cannot write blank key")
+		}
+		if !entry.Deleted && len(entry.Value) == 0 {
+			return fmt.Errorf("db.Write: cannot insert blank value for %q", entry.Key)
+		}
+	}
+	return db.write(batch, opts, nil)
+}
+
+// checkBatch rejects a batch writing to a family that does not exist, or that its family's options rule out.  Families
+// come and go, so the batch is checked under writeMu.
+func (db *db) checkBatch(batch *leveldb.WriteBatch) error {
+	for _, entry := range batch.Entries() {
+		cf, err := db.family(entry.ColumnFamily)
+		if err != nil {
+			return fmt.Errorf("db.Write: cannot write %q: %v", entry.Key, err)
+		}
+		if entry.Deleted && entry.Limit != nil {
+			if len(entry.Limit) == 0 || cf.options.Comparator.Compare(entry.Key, entry.Limit) > 0 {
+				return fmt.Errorf("db.Write: cannot delete range from %q to %q", entry.Key, entry.Limit)
+			}
+		}
+		if entry.Merge && cf.options.MergeOperator == nil {
+			return fmt.Errorf("db.Write: cannot merge into %q without a MergeOperator", entry.Key)
+		}
+	}
+	return nil
+}
+
+// write logs the batch, waits for the WAL to be synced if the write has to be, then applies the batch to the memTables
+// and publishes it.  Readers never see a write before it is as durable as it asked to be: should a sync fail, the
+// write is reported as failed without having been applied, and so is every write after it, whether or not it asked
+// to be synced.
+func (db *db) write(batch *leveldb.WriteBatch, opts []leveldb.WriteOption, check func() error) error {
+	logged, err := db.logBatch(batch, opts, check)
+	if err != nil {
 		return err
 	}
-	err := db.memTable.Insert(key, value)
+	if err := db.applyInTurn(logged, logged.pending.Wait()); err != nil {
+		return err
+	}
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	return db.maybeCompactMemTable()
+}
+
+// loggedBatch is a batch appended to the WAL, along with the sequence numbers and column families it was assigned,
+// waiting to be applied.  Its writes are numbered from first on.
+type loggedBatch struct {
+	batch    *leveldb.WriteBatch
+	first    uint64
+	families []*columnFamily
+	pending  wal.Pending
+}
+
+// logBatch appends the batch to the WAL once it and check, if any, pass, and assigns it the sequence numbers following
+// those of the batches logged before it.  It runs under writeMu, so batches are logged in the order their sequence
+// numbers are assigned, but returns without waiting for the WAL to be synced, so that the writers queued behind it can
+// append in the meantime and share the sync.
+func (db *db) logBatch(
+	batch *leveldb.WriteBatch,
+	opts []leveldb.WriteOption,
+	check func() error,
+) (*loggedBatch, error) {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	if err := db.checkBatch(batch); err != nil {
+		return nil, err
+	}
+	if check != nil {
+		if err := check(); err != nil {
+			return nil, err
+		}
+	}
+	pending, err := db.wal.Append(batch, opts...)
 	if err != nil {
-		return fmt.Errorf("db.Put: error inserting into memtable: %v", err)
+		return nil, err
+	}
+	var logged = &loggedBatch{batch: batch, pending: pending, families: make([]*columnFamily, 0, batch.Len())}
+	for _, entry := range batch.Entries() {
+		logged.families = append(logged.families, db.families[entry.ColumnFamily])
+	}
+	db.mu.Lock()
+	logged.first = db.lastSequence + db.unpublished + 1
+	db.unpublished += uint64(batch.Len())
+	db.mu.Unlock()
+	return logged, nil
+}
+
+// applyInTurn waits for the batches logged before logged to be published, then applies it to the memTables unless
+// syncing it failed, and publishes it in turn.  Batches are applied one at a time, in the order they were logged.  By
+// its turn, any failed sync of a batch before it has failed the WAL, so a batch that did not wait on a sync of its own
+// is not applied over the gap either.
+func (db *db) applyInTurn(logged *loggedBatch, syncErr error) error {
+	db.mu.Lock()
+	for db.lastSequence+1 != logged.first {
+		db.published.Wait()
+	}
+	db.mu.Unlock()
+	var err = syncErr
+	if err == nil {
+		err = logged.pending.Err()
 	}
-	if err := db.tombstones.Delete(key); err != nil {
-		return fmt.Errorf("db.Put: error removing from memtable: %v", err)
+	if err == nil {
+		err = logged.apply()
+	}
+	// a batch failing to apply part way cannot be taken back out of the memTables, and is in the WAL anyway, so its
+	// sequence numbers are published rather than handed out again
+	db.publish(uint64(logged.batch.Len()))
+	return err
+}
+
+// apply inserts the batch's writes into the memTables of their families.
+func (logged *loggedBatch) apply() error {
+	for j, entry := range logged.batch.Entries() {
+		var (
+			seq = logged.first + uint64(j)
+			cf  = logged.families[j]
+		)
+		if entry.Deleted && entry.Limit != nil {
+			cf.memTable.deleteRange(rangeTombstone{start: entry.Key, limit: entry.Limit, seq: seq})
+			continue
+		}
+		var kind, value = kindValue, entry.Value
+		switch {
+		case entry.Deleted:
+			kind = kindDelete
+		case entry.Merge:
+			kind = kindMerge
+		case !entry.ExpiresAt.IsZero():
+			kind, value = kindValueWithExpiry, makeExpiringValue(entry.Value, entry.ExpiresAt.UnixNano())
+		}
+		if err := cf.apply(seq, entry.Key, value, kind); err != nil {
+			return fmt.Errorf("db.Write: error applying %q to memtable: %v", entry.Key, err)
+		}
 	}
 	return nil
 }
 
-func (db *db) Delete(key leveldb.Key) error {
-	if err := db.wal.Delete(key); err != nil {
+// publish makes the next count writes logged visible to readers.
+func (db *db) publish(count uint64) {
+	db.mu.Lock()
+	db.lastSequence += count
+	db.unpublished -= count
+	db.published.Broadcast()
+	db.mu.Unlock()
+}
+
+// awaitPublished waits for every batch logged to be published, so that the memTables hold every write in the WAL.
+// The caller holds writeMu, so that no more are logged in the meantime.
+func (db *db) awaitPublished() {
+	db.mu.Lock()
+	for db.unpublished > 0 {
+		db.published.Wait()
+	}
+	db.mu.Unlock()
+}
+
+// Close releases the files held open by the database, once any write in progress is done.  Tables read by iterators
+// not yet closed are left open until they are.
+func (db *db) Close() error {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	db.awaitPublished()
+	var errs = []error{db.wal.Close()}
+	if db.logFile != nil {
+		errs = append(errs, db.logFile.Close())
+	}
+	if db.manifest != nil {
+		errs = append(errs, db.manifest.Close())
+	}
+	for _, cf := range db.families {
+		errs = append(errs, cf.current.unref())
+	}
+	return errors.Join(errs...)
+}
+
+// RangeScan merges the memTables and every SSTable overlapping the range, then picks out the entries visible at the
+// read's sequence number that no range tombstone it sees deletes.  Writes made after the scan starts are never seen,
+// even those that reach the memTable it is reading.  The iterator keeps the tables it reads open, even once
+// compaction replaces them or the table cache evicts them, until it is closed.
+func (db *db) RangeScan(start leveldb.Key, limit leveldb.Key, opts ...leveldb.ReadOption) (leveldb.Iterator, error) {
+	state, err := db.loadReadState(opts)
+	if err != nil {
+		return nil, fmt.Errorf("db.RangeScan: %v", err)
+	}
+	iterator, err := state.scan(start, limit, state.release)
+	if err != nil {
+		return nil, fmt.Errorf("db.RangeScan: %v", err)
+	}
+	return iterator, nil
+}
+
+// scan returns an iterator over the user keys in [start, limit] visible to the read.  The tables it reads are released
+// once it is closed, as is anything else release, if set, lets go of.
+func (state *readState) scan(start leveldb.Key, limit leveldb.Key, release func()) (leveldb.Iterator, error) {
+	var (
+		tables                       = state.current.tablesForRange(start, limit)
+		sources                      = make([]leveldb.Iterator, 0, len(state.memTables)+len(tables))
+		handles                      = make([]*tableHandle, 0, len(tables))
+		tombstones                   rangeTombstones
+		internalStart, internalLimit = internalRange(start, limit)
+		comparator                   = state.current.comparator
+	)
+	var releaseTables = func() {
+		// the iterator is done with the tables, whether or not they close cleanly
+		_ = releaseAll(handles)
+		if release != nil {
+			release()
+		}
+	}
+	for _, mem := range state.memTables {
+		iterator, err := mem.scan(internalStart, internalLimit)
+		if err != nil {
+			releaseTables()
+			return nil, err
+		}
+		sources = append(sources, iterator)
+		tombstones = append(tombstones, mem.rangeTombstones().overlapping(start, limit, comparator)...)
+	}
+	for _, meta := range tables {
+		handle, err := state.current.tables.acquire(meta.number)
+		if err != nil {
+			releaseTables()
+			return nil, err
+		}
+		handles = append(handles, handle)
+		iterator, err := handle.scan(start, limit, leveldb.WithBypassCache(state.bypassCache))
+		if err != nil {
+			releaseTables()
+			return nil, fmt.Errorf("error scanning SSTable: %v", err)
+		}
+		sources = append(sources, iterator)
+		tombstones = append(tombstones, handle.tombstones.overlapping(start, limit, comparator)...)
+	}
+	var merged = newInternalMergingIterator(internalComparator{user: comparator}, sources...)
+	return newSnapshotIterator(merged, state.seq, state.now, tombstones, comparator, state.merger, releaseTables), nil
+}
+
+// replay applies operations read back from a WAL to the memTables of their column families, without logging them again.
+// Sequence numbers are not logged, so replayed writes are numbered afresh following the last sequence number recorded
+// in the manifest.  Writes to families since dropped are skipped.
+func (db *db) replay(entries []*encoding.DbOperation) error {
+	for _, entry := range entries {
+		var key = leveldb.Key(entry.Key)
+		cf, ok := db.families[entry.ColumnFamily]
+		if !ok {
+			continue
+		}
+		switch entry.Operation {
+		case encoding.OpPut:
+			if err := cf.apply(db.lastSequence+1, key, leveldb.Value(entry.Value), kindValue); err != nil {
+				return err
+			}
+			db.lastSequence++
+		case encoding.OpPutWithExpiry:
+			var value = makeExpiringValue(leveldb.Value(entry.Value), entry.ExpiresAt)
+			if err := cf.apply(db.lastSequence+1, key, value, kindValueWithExpiry); err != nil {
+				return err
+			}
+			db.lastSequence++
+		case encoding.OpDelete:
+			if err := cf.apply(db.lastSequence+1, key, nil, kindDelete); err != nil {
+				return err
+			}
+			db.lastSequence++
+		case encoding.OpMerge:
+			if err := cf.apply(db.lastSequence+1, key, leveldb.Value(entry.Value), kindMerge); err != nil {
+				return err
+			}
+			db.lastSequence++
+		case encoding.OpDeleteRange:
+			db.lastSequence++
+			cf.memTable.deleteRange(rangeTombstone{start: key, limit: leveldb.Key(entry.Value), seq: db.lastSequence})
+		case encoding.OpBatch:
+			if err := db.replay(entry.Batch); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unrecognized opcode %s", entry.Operation)
+		}
+	}
+	return nil
+}
+
+// apply inserts a write with the given sequence number into the family's memTable.  Readers do not see it until
+// lastSequence reaches seq.
+func (cf *columnFamily) apply(seq uint64, key leveldb.Key, value leveldb.Value, kind keyKind) error {
+	if len(key) == 0 {
+		return errors.New("cannot insert blank key")
+	}
+	return cf.memTable.insert(makeInternalKey(key, seq, kind), value)
+}
+
+func (db *db) replayLogFile(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
 		return err
 	}
-	if err := db.memTable.Delete(key); err != nil {
-		return fmt.Errorf("db.Delete: error removing from memtable: %v", err)
+	defer func() { _ = f.Close() }()
+	recovered, err := wal.ReadLog(f, db.options.StrictRecovery)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", name, err)
+	}
+	// segments are never appended to once replayed, so the bytes after the last intact record can be left in place
+	db.stats.walBytesDiscarded += uint64(recovered.Discarded + recovered.Skipped)
+	return db.replay(recovered.Operations)
+}
+
+// recover rebuilds the set of live SSTables from the manifest CURRENT names, then replays the WAL segments holding
+// entries those tables do not cover.  The replayed entries are flushed and a fresh manifest and WAL segment started.
+// Every step is made durable before the files it obsoletes are removed, so a crash at any point (recover included)
+// leaves a directory that recovers to the same state.
+func (db *db) recover() error {
+	dirEntries, err := os.ReadDir(db.dir)
+	if err != nil {
+		return fmt.Errorf("error reading directory: %v", err)
+	}
+	var (
+		logNumbers []uint64
+		hasTables  bool
+	)
+	for _, dirEntry := range dirEntries {
+		number, fType, ok := parseFileName(dirEntry.Name())
+		if !ok {
+			continue
+		}
+		db.nextFileNumber = max(db.nextFileNumber, number+1)
+		switch fType {
+		case logFile:
+			logNumbers = append(logNumbers, number)
+		case tableFile:
+			hasTables = true
+		}
+	}
+
+	manifestNumber, err := readCurrentFile(db.dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if hasTables || len(logNumbers) > 0 {
+			// without a manifest we cannot tell which files are live, and would otherwise delete them all
+			return fmt.Errorf("%s holds database files but no %s", db.dir, currentFileName)
+		}
+	case err != nil:
+		return fmt.Errorf("error reading %s: %v", currentFileName, err)
+	default:
+		edits, err := readManifest(manifestFileName(db.dir, manifestNumber))
+		if err != nil {
+			return fmt.Errorf("error reading manifest: %v", err)
+		}
+		if err := db.checkComparator(edits); err != nil {
+			return err
+		}
+		for _, edit := range edits {
+			if err := db.applyEdit(edit); err != nil {
+				return err
+			}
+		}
+	}
+
+	// tables are opened as they are read, through the table cache
+	slices.Sort(logNumbers)
+	for _, number := range logNumbers {
+		if number < db.logNumber {
+			continue // already flushed, but not yet removed when we last stopped
+		}
+		if err := db.replayLogFile(logFileName(db.dir, number)); err != nil {
+			return fmt.Errorf("error replaying WAL segment: %v", err)
+		}
+	}
+
+	if err := db.rotateLog(); err != nil {
+		return fmt.Errorf("error starting WAL segment: %v", err)
+	}
+	for _, cf := range db.sortedFamilies() {
+		if cf.memTableSize() == 0 {
+			continue
+		}
+		meta, err := cf.writeLevel0Table()
+		if err != nil {
+			return fmt.Errorf("error flushing replayed entries: %v", err)
+		}
+		cf.installLevel0Table(meta)
+	}
+	if err := db.newManifest(); err != nil {
+		return fmt.Errorf("error writing manifest: %v", err)
+	}
+	db.removeObsoleteFiles()
+	return db.maybeCompact()
+}
+
+// maybeCompact runs the compactions every family has due.
+func (db *db) maybeCompact() error {
+	for _, cf := range db.sortedFamilies() {
+		if err := cf.maybeCompact(); err != nil {
+			return err
+		}
 	}
-	if err := db.tombstones.Insert(key, nil); err != nil {
-		return fmt.Errorf("db.Delete: error adding to tombstones: %v", err)
+	return nil
+}
+
+// checkComparator verifies that the manifest's edits were recorded by a database ordering its keys by the configured
+// Comparator.  Manifests predating the comparator's name being recorded are taken to be ordered bytewise, as every
+// database then was.  Other families' comparators are checked as they are added.
+func (db *db) checkComparator(edits []*versionEdit) error {
+	var name = leveldb.BytewiseComparator.Name()
+	for _, edit := range edits {
+		if edit.family == 0 && edit.hasComparator {
+			name = edit.comparator
+		}
+	}
+	if name != db.options.Comparator.Name() {
+		return fmt.Errorf("database ordered by comparator %q cannot be opened with %q", name, db.options.Comparator.Name())
 	}
 	return nil
 }
 
-func (db *db) RangeScan(start leveldb.Key, limit leveldb.Key) (leveldb.Iterator, error) {
-	precedingNode, err := db.memTable.TraverseUntil(start, nil)
+// applyEdit applies a manifest record to the set of live column families and SSTables, and the WAL bookkeeping.  A
+// family added by the edit takes its options from Options.ColumnFamilies, which must order its keys by the comparator
+// it was created with.  The edits nested in it for other families are applied after it.
+func (db *db) applyEdit(edit *versionEdit) error {
+	if edit.hasNextFamily {
+		db.nextFamily = max(db.nextFamily, edit.nextFamily)
+	}
+	if edit.hasAddedFamily {
+		var opts = db.familyOptions(db.options.ColumnFamilies[edit.addedFamily])
+		if edit.hasComparator && edit.comparator != opts.Comparator.Name() {
+			return fmt.Errorf(
+				"column family %q ordered by comparator %q cannot be opened with %q",
+				edit.addedFamily, edit.comparator, opts.Comparator.Name(),
+			)
+		}
+		db.families[edit.family] = newColumnFamily(db, edit.family, edit.addedFamily, opts)
+	}
+	cf, err := db.family(edit.family)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("error applying manifest edit: %v", err)
+	}
+	if edit.droppedFamily {
+		delete(db.families, edit.family)
+		// the family's tables are left for removeObsoleteFiles
+		_ = cf.current.unref()
+		return nil
+	}
+	if edit.hasLogNumber {
+		db.logNumber = edit.logNumber
+	}
+	if edit.hasNextFileNumber {
+		db.nextFileNumber = max(db.nextFileNumber, edit.nextFileNumber)
+	}
+	if edit.hasLastSequence {
+		db.lastSequence = max(db.lastSequence, edit.lastSequence)
+	}
+	cf.installVersion(cf.current.apply(edit))
+	for _, familyEdit := range edit.familyEdits {
+		if err := db.applyEdit(familyEdit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newManifest writes a manifest describing the current state in full and points CURRENT at it, so that edits logged
+// to earlier manifests no longer need to be replayed.  It holds an edit for each live column family, the first of
+// which, for the default family, also holds the bookkeeping of the database as a whole.
+func (db *db) newManifest() error {
+	var (
+		manifestNumber = db.newFileNumber()
+		tempNumber     = db.newFileNumber()
+	)
+	manifest, err := createManifest(db.dir, manifestNumber)
+	if err != nil {
+		return err
+	}
+	for _, cf := range db.sortedFamilies() {
+		var snapshot = new(versionEdit)
+		snapshot.setFamily(cf.id)
+		if cf.id == 0 {
+			snapshot.setLogNumber(db.logFileNumber)
+			snapshot.setNextFileNumber(db.nextFileNumber)
+			snapshot.setLastSequence(db.lastSequence)
+			snapshot.setNextFamily(db.nextFamily)
+		} else {
+			snapshot.addFamily(cf.name)
+		}
+		snapshot.setComparator(cf.options.Comparator.Name())
+		for level, files := range cf.current.levels {
+			for _, meta := range files {
+				snapshot.addFile(level, meta)
+			}
+		}
+		if err := manifest.append(snapshot); err != nil {
+			_ = manifest.Close()
+			return err
+		}
+	}
+	if err := setCurrentFile(db.dir, manifestNumber, tempNumber); err != nil {
+		_ = manifest.Close()
+		return err
+	}
+
+	if db.manifest != nil {
+		_ = db.manifest.Close()
 	}
-	return NewSkipListIterator(precedingNode, limit), nil
+	db.manifest = manifest
+	db.logNumber = db.logFileNumber
+	return nil
 }
 
-func (db *db) flushSSTable(f *os.File) (*sst.SSTableDB, error) {
-	sstDb, err := sst.BuildSSTable(f, db.memTable, db.tombstones)
+// removeObsoleteFiles deletes files no longer referenced by the manifest: flushed WAL segments, SSTables left behind by
+// an interrupted flush, and superseded manifests.  It is best-effort, since a file we fail to delete now is simply
+// deleted on a later attempt.
+func (db *db) removeObsoleteFiles() {
+	dirEntries, err := os.ReadDir(db.dir)
 	if err != nil {
-		return nil, fmt.Errorf("db.flushSSTable: error building the SSTable: %v", err)
+		return
+	}
+	for _, dirEntry := range dirEntries {
+		number, fType, ok := parseFileName(dirEntry.Name())
+		if !ok {
+			continue
+		}
+		var keep bool
+		switch fType {
+		case logFile:
+			keep = number >= db.logNumber
+		case tableFile:
+			// tables replaced by compaction are kept for as long as a reader's version holds them, since the table cache
+			// may need to open them again
+			keep = db.tables.live(number)
+		case manifestFile:
+			keep = number == db.manifest.number
+		case currentFile:
+			keep = true
+		}
+		if !keep {
+			_ = os.Remove(filepath.Join(db.dir, dirEntry.Name()))
+		}
 	}
+}
+
+func (cf *columnFamily) memTableSize() uint64 {
+	return cf.memTable.size()
+}
 
-	if err := db.memTable.Reset(); err != nil {
-		return nil, fmt.Errorf("db.flushSSTable: error resetting memTable: %v", err)
+// maybeCompactMemTable flushes the memTables once any of them crosses its family's write buffer size.  Databases not
+// created by Open have nowhere to put SSTables, so they grow without bound.
+func (db *db) maybeCompactMemTable() error {
+	if db.dir == "" {
+		return nil
 	}
-	if err := db.tombstones.Reset(); err != nil {
-		return nil, fmt.Errorf("db.flushSSTable: error resetting tombstones: %v", err)
+	for _, cf := range db.families {
+		if cf.memTableSize() < uint64(cf.options.WriteBufferSize) {
+			continue
+		}
+		if err := db.compactMemTable(); err != nil {
+			return fmt.Errorf("db.maybeCompactMemTable: %v", err)
+		}
+		return nil
 	}
+	return nil
+}
 
-	return sstDb, nil
+// compactMemTable flushes every memTable holding writes to a level-0 SSTable, leaving the families without any be.
+// Writes switch to a new WAL segment first, so that the previous segments cover exactly the frozen entries.  Since the
+// families share those segments, they are all flushed together, and recorded in the manifest as a single edit along
+// with the segments becoming obsolete, so that a crash cannot leave some families' writes only in deleted segments.
+func (db *db) compactMemTable() error {
+	db.awaitPublished()
+	if err := db.rotateLog(); err != nil {
+		return fmt.Errorf("error rotating WAL: %v", err)
+	}
+	var (
+		edit    = new(versionEdit)
+		flushed []*columnFamily
+		metas   []*fileMetadata
+	)
+	// abandon puts the families flushed so far back as they were, so that the whole flush is retried; their tables are
+	// left for removeObsoleteFiles
+	var abandon = func() {
+		for j, cf := range flushed {
+			cf.abandonLevel0Table(metas[j])
+		}
+	}
+	for _, cf := range db.sortedFamilies() {
+		// a family without writes since its last flush has nothing to add, and its tables cover no WAL segment
+		if cf.memTableSize() == 0 {
+			continue
+		}
+		meta, err := cf.writeLevel0Table()
+		if err != nil {
+			abandon()
+			return fmt.Errorf("error flushing memtable: %v", err)
+		}
+		flushed, metas = append(flushed, cf), append(metas, meta)
+		var familyEdit = new(versionEdit)
+		familyEdit.setFamily(cf.id)
+		familyEdit.addFile(0, meta)
+		edit.addFamilyEdit(familyEdit)
+	}
+	edit.setLogNumber(db.logFileNumber)
+	edit.setNextFileNumber(db.nextFileNumber)
+	edit.setLastSequence(db.lastSequence)
+	if err := db.manifest.append(edit); err != nil {
+		abandon()
+		return fmt.Errorf("error recording flush in manifest: %v", err)
+	}
+	for j, cf := range flushed {
+		cf.installLevel0Table(metas[j])
+	}
+	db.logNumber = db.logFileNumber
+	db.removeObsoleteFiles()
+	return db.maybeCompact()
 }
 
-// consider converting to memory instead of this
-type skipListIterator struct {
-	limit   leveldb.Key
-	current skiplist.Node
-	err     error
+// writeLevel0Table flushes the family's memTable to a newly numbered SSTable file, synced before returning, as
+// flushSSTable does.
+func (cf *columnFamily) writeLevel0Table() (*fileMetadata, error) {
+	var number = cf.db.newFileNumber()
+	f, err := os.Create(tableFileName(cf.db.dir, number))
+	if err != nil {
+		return nil, err
+	}
+	meta, err := cf.flushSSTable(f, number)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		cf.abandonLevel0Table(meta)
+		return nil, err
+	}
+	return meta, nil
 }
 
-func NewSkipListIterator(precedingNode skiplist.Node, limit leveldb.Key) leveldb.Iterator {
-	return &skipListIterator{
-		limit:   limit,
-		current: precedingNode,
+// rotateLog starts writing to a new WAL segment.  The previous segment is closed, but left for removeObsoleteFiles.
+func (db *db) rotateLog() error {
+	var number = db.newFileNumber()
+	f, err := os.OpenFile(logFileName(db.dir, number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
 	}
+	var previous, previousLog = db.logFile, db.wal
+	db.logFile, db.logFileNumber, db.wal = f, number, wal.NewLog(f, db.options.walOptions()...)
+	if previous != nil {
+		return errors.Join(previousLog.Close(), previous.Close())
+	}
+	return nil
 }
 
-func (s *skipListIterator) Next() bool {
-	if nextNode := s.current.Next(); nextNode.CompareKey(s.limit) <= 0 {
-		s.current = nextNode
-		return true
+func (db *db) newFileNumber() uint64 {
+	var number = db.nextFileNumber
+	db.nextFileNumber++
+	return number
+}
+
+// flushSSTable freezes the memTable, swapping in an empty one for subsequent writes, and writes the frozen entries to
+// f, the table numbered number.  Readers keep consulting the frozen entries until installLevel0Table adds the table as
+// the newest SSTable, or abandonLevel0Table makes them current again.
+func (cf *columnFamily) flushSSTable(f *os.File, number uint64) (*fileMetadata, error) {
+	cf.db.mu.Lock()
+	var frozenMemTable = cf.memTable
+	cf.memTable, cf.immutable = newMemTable(cf.options), frozenMemTable
+	cf.db.mu.Unlock()
+
+	sstDb, smallest, largest, err := cf.writeMemTable(f, number, frozenMemTable)
+	if err != nil {
+		// nothing was lost, so keep serving the frozen entries from memory
+		cf.thaw(frozenMemTable)
+		return nil, fmt.Errorf("db.flushSSTable: error building the SSTable: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		cf.thaw(frozenMemTable)
+		return nil, fmt.Errorf("db.flushSSTable: error reading SSTable size: %v", err)
 	}
-	return false
+	cf.tables.add(number, sstDb)
+	var meta = &fileMetadata{
+		number:   number,
+		size:     uint64(info.Size()),
+		smallest: smallest,
+		largest:  largest,
+	}
+	return meta, nil
+}
+
+// installLevel0Table adds the table flushSSTable wrote from the frozen memTable as the family's newest SSTable.
+func (cf *columnFamily) installLevel0Table(meta *fileMetadata) {
+	var edit = new(versionEdit)
+	edit.addFile(0, meta)
+	// readers loading their state in between see the entries twice over, which is harmless, rather than not at all
+	cf.installVersion(cf.current.apply(edit))
+	cf.db.mu.Lock()
+	cf.immutable = nil
+	cf.db.mu.Unlock()
+	cf.db.stats.bytesFlushed += meta.size
+}
+
+// abandonLevel0Table makes the memTable flushSSTable froze current again, and closes the table it wrote, which is
+// left for removeObsoleteFiles.
+func (cf *columnFamily) abandonLevel0Table(meta *fileMetadata) {
+	cf.db.mu.Lock()
+	var frozenMemTable = cf.immutable
+	cf.db.mu.Unlock()
+	cf.thaw(frozenMemTable)
+	// no reader has seen the table, so nothing is lost if it fails to close
+	_ = cf.tables.evict(meta.number)
 }
 
-func (s *skipListIterator) Error() error {
-	return s.err
+// thaw makes a memTable that failed to flush current again.  Writers wait on the flush, so the memTable it replaced
+// is still empty.
+func (cf *columnFamily) thaw(frozenMemTable *memTable) {
+	cf.db.mu.Lock()
+	cf.memTable, cf.immutable = frozenMemTable, nil
+	cf.db.mu.Unlock()
+}
+
+// writeMemTable writes every entry in memTable, range tombstones included, to the table numbered number in f, and
+// returns it along with the smallest and largest user keys it holds or deletes.  The memTable must be frozen, as it
+// is read without its lock.
+func (cf *columnFamily) writeMemTable(
+	f *os.File,
+	number uint64,
+	memTable *memTable,
+) (table *sst.SSTableDB, smallest leveldb.Key, largest leveldb.Key, err error) {
+	writer, err := cf.newTableWriter(f, number)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	header, err := memTable.list.TraverseUntil(nil, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for node := header.Next(); node != skiplist.NilNode; node = node.Next() {
+		if err := writer.Add(node.Key(), node.Value()); err != nil {
+			return nil, nil, nil, err
+		}
+		if smallest == nil {
+			smallest = internalKey(node.Key()).userKey()
+		}
+		largest = internalKey(node.Key()).userKey()
+	}
+	// the table's key range takes in the ranges its tombstones delete, so that lookups of keys within them find it
+	var comparator = cf.options.Comparator
+	for _, tombstone := range memTable.rangeTombstones().sorted(cf.options.internalComparator()) {
+		if err := writer.AddRangeTombstone(leveldb.Key(tombstone.key()), leveldb.Value(tombstone.limit)); err != nil {
+			return nil, nil, nil, err
+		}
+		if smallest == nil || comparator.Compare(tombstone.start, smallest) < 0 {
+			smallest = tombstone.start
+		}
+		if largest == nil || comparator.Compare(tombstone.limit, largest) > 0 {
+			largest = tombstone.limit
+		}
+	}
+	table, err = writer.Finish()
+	return table, smallest, largest, err
 }
 
-func (s *skipListIterator) Key() leveldb.Key {
-	return s.current.Key()
+// newTableWriter starts the SSTable numbered number in f, keyed by internal key, with the configured filter and
+// compression.
+func (cf *columnFamily) newTableWriter(f *os.File, number uint64) (*sst.Writer, error) {
+	return sst.NewWriter(
+		f,
+		sst.WithKeyComparison(cf.options.internalComparator().Compare),
+		sst.WithFilterKey(filterKey),
+		sst.WithBloomFilter(cf.options.BloomBitsPerKey),
+		sst.WithCompression(cf.options.Compression),
+		sst.WithBlockCache(cf.db.blockCache, number),
+	)
 }
 
-func (s *skipListIterator) Value() leveldb.Value {
-	return s.current.Value()
+// openTable opens the SSTable numbered number, written by newTableWriter.
+func (cf *columnFamily) openTable(f *os.File, number uint64) (*sst.SSTableDB, error) {
+	return sst.NewSSTableDBFromFile(
+		f,
+		sst.WithKeyComparison(cf.options.internalComparator().Compare),
+		sst.WithFilterKey(filterKey),
+		sst.WithBlockCache(cf.db.blockCache, number),
+	)
 }
@@ -20,6 +20,15 @@ type testImpl struct {
 	db   leveldb.DB
 }
 
+// memTableImpls are the memTables benchmarked against one another.
+var memTableImpls = []struct {
+	name string
+	kind MemTableKind
+}{
+	{name: "SkipListImpl", kind: LockedMemTable},
+	{name: "ConcurrentSkipListImpl", kind: ConcurrentMemTable},
+}
+
 var (
 	impls  []testImpl
 	keyBuf = make(leveldb.Key, keySize)
@@ -41,15 +50,15 @@ func init() {
 			Value: valBuf,
 		}
 	}
-	skipListDb := NewDb(nil)
-	for _, datum := range data {
-		if err := skipListDb.Put(datum.Key, datum.Value); err != nil {
-			panic(fmt.Sprintf("failure in setup: %v", err))
+	impls = []testImpl{{name: "SliceImpl", db: NewInMemoryDb(data)}}
+	for _, memTable := range memTableImpls {
+		skipListDb := newDb(nil, (&Options{MemTable: memTable.kind}).withDefaults())
+		for _, datum := range data {
+			if err := skipListDb.Put(datum.Key, datum.Value); err != nil {
+				panic(fmt.Sprintf("failure in setup: %v", err))
+			}
 		}
-	}
-	impls = []testImpl{
-		{name: "SliceImpl", db: NewInMemoryDb(data)},
-		{name: "SkipListImpl", db: skipListDb},
+		impls = append(impls, testImpl{name: memTable.name, db: skipListDb})
 	}
 }
 
@@ -161,8 +170,50 @@ func BenchmarkDb_RangeScan(b *testing.B) {
 		b.Run(impl.name, func(b *testing.B) {
 			for j := range b.N {
 				rng := ranges[j%benchSize]
-				_, _ = impl.db.RangeScan(rng.start, rng.limit)
+				if results, err := impl.db.RangeScan(rng.start, rng.limit); err == nil {
+					_ = results.Close()
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDb_GetWhileWriting measures reads running in parallel with a writer inserting into the memTable, which is
+// where the memTables differ: readers of a locked skiplist wait out each insert.
+func BenchmarkDb_GetWhileWriting(b *testing.B) {
+	keys := make([]leveldb.Key, dataSize)
+	for j := range keys {
+		keys[j] = leveldb.Key(fmt.Sprintf("key%06d", j))
+	}
+	for _, memTable := range memTableImpls {
+		b.Run(memTable.name, func(b *testing.B) {
+			database := newDb(nil, (&Options{MemTable: memTable.kind}).withDefaults())
+			for _, key := range keys {
+				if err := database.Put(key, valBuf); err != nil {
+					b.Fatal("failure in setup:", err)
+				}
 			}
+			var done, stopped = make(chan struct{}), make(chan struct{})
+			go func() {
+				defer close(stopped)
+				for j := 0; ; j++ {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					_ = database.Put(keys[j%dataSize], valBuf)
+				}
+			}()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for j := 0; pb.Next(); j++ {
+					_, _ = database.Get(keys[j%dataSize])
+				}
+			})
+			b.StopTimer()
+			close(done)
+			<-stopped
 		})
 	}
 }
@@ -2,8 +2,14 @@ package db
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"leveldb"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 var testImpls = []TestSetup{
@@ -161,6 +167,104 @@ func TestDb_Delete_Success(t *testing.T) {
 	}
 }
 
+func TestDb_Write(t *testing.T) {
+	for _, impl := range testImpls {
+		t.Run(impl.Name, func(t *testing.T) {
+			db := impl.NewDb(data)
+			var batch leveldb.WriteBatch
+			batch.Put(leveldb.Key("foo"), leveldb.Value("bar"))
+			batch.Delete(leveldb.Key("eggs"))
+			batch.Put(leveldb.Key("spam"), leveldb.Value("spam"))
+			batch.Put(leveldb.Key("foo"), leveldb.Value("baz"))
+			if err := db.Write(&batch); err != nil {
+				t.Fatal("unexpected error writing batch", err)
+			}
+			for key, expected := range map[string]string{"foo": "baz", "spam": "spam"} {
+				val, err := db.Get(leveldb.Key(key))
+				if err != nil {
+					t.Fatalf("unexpected error getting %q: %v", key, err)
+				}
+				if string(val) != expected {
+					t.Errorf("expected %q to be %q, got %q", key, expected, val)
+				}
+			}
+			if _, err := db.Get(leveldb.Key("eggs")); err == nil {
+				t.Error("expected an error getting key deleted in batch, but did not get one")
+			}
+		})
+	}
+}
+
+func TestDb_Write_Validation(t *testing.T) {
+	var cases = []struct {
+		name    string
+		write   func(batch *leveldb.WriteBatch)
+		wantErr bool
+	}{
+		{"DeleteMissingKey", func(batch *leveldb.WriteBatch) { batch.Delete(leveldb.Key("foo")) }, false},
+		{"BlankKey", func(batch *leveldb.WriteBatch) { batch.Put(nil, leveldb.Value("bar")) }, true},
+		{"BlankValue", func(batch *leveldb.WriteBatch) { batch.Put(leveldb.Key("foo"), nil) }, true},
+		{
+			"BackwardsRange",
+			func(batch *leveldb.WriteBatch) { batch.DeleteRange(leveldb.Key("spam"), leveldb.Key("eggs")) },
+			true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			for _, impl := range testImpls {
+				t.Run(impl.Name, func(t *testing.T) {
+					db := impl.NewDb(data)
+					var batch leveldb.WriteBatch
+					batch.Put(leveldb.Key("toast"), leveldb.Value("buttered"))
+					c.write(&batch)
+					if err := db.Write(&batch); (err != nil) != c.wantErr {
+						t.Fatalf("expected Write() to fail to be %t, got err %v", c.wantErr, err)
+					}
+					if found, err := db.Has(leveldb.Key("toast")); err != nil || found == c.wantErr {
+						t.Errorf("expected the rest of the batch to be written to be %t, got %t (err %v)", !c.wantErr, found, err)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestDb_DeleteRange(t *testing.T) {
+	for _, impl := range testImpls {
+		t.Run(impl.Name, func(t *testing.T) {
+			db := impl.EmptyDb()
+			writeKeys(t, db, "key", 0, 10)
+			if err := db.(RangeDeleter).DeleteRange(leveldb.Key("key003"), leveldb.Key("key006")); err != nil {
+				t.Fatal("unexpected error executing DeleteRange()", err)
+			}
+			if err := db.Put(leveldb.Key("key004"), leveldb.Value("rewritten")); err != nil {
+				t.Fatal("unexpected error executing Put()", err)
+			}
+			for key, expected := range map[string]bool{"key002": true, "key003": false, "key006": false, "key007": true} {
+				if found, err := db.Has(leveldb.Key(key)); err != nil || found != expected {
+					t.Errorf("expected Has(%q) to be %t, got %t (err %v)", key, expected, found, err)
+				}
+			}
+			if val, err := db.Get(leveldb.Key("key004")); err != nil || string(val) != "rewritten" {
+				t.Errorf("expected a write after the range delete to be kept, got %q (err %v)", val, err)
+			}
+			results, err := db.RangeScan(leveldb.Key("key000"), leveldb.Key("key999"))
+			if err != nil {
+				t.Fatal("unexpected error executing RangeScan()", err)
+			}
+			defer results.Close()
+			var keys []string
+			for results.Next() {
+				keys = append(keys, string(results.Key()))
+			}
+			if fmt.Sprint(keys) != "[key000 key001 key002 key004 key007 key008 key009]" {
+				t.Errorf("expected RangeScan() to skip the deleted range, got %v", keys)
+			}
+		})
+	}
+}
+
 func TestDb_RangeScan(t *testing.T) {
 	scanData := []leveldb.DataEntry{
 		{leveldb.Key("abc"), leveldb.Value("ABC")},
@@ -183,6 +287,7 @@ func TestDb_RangeScan(t *testing.T) {
 			if err != nil {
 				t.Fatal("unexpected error executing RangeScan()", err)
 			}
+			defer results.Close()
 			expectedResults := scanData[0:4] // `results` should include matches to `limit` parameter.
 			for j, datum := range expectedResults {
 				hasNext := results.Next()
@@ -205,3 +310,941 @@ func TestDb_RangeScan(t *testing.T) {
 		})
 	}
 }
+
+func TestDb_GetHas_FlushedSSTables(t *testing.T) {
+	var (
+		db  = NewDb(nil).(*db)
+		err error
+	)
+
+	for _, datum := range []leveldb.DataEntry{
+		{Key: leveldb.Key("alpha"), Value: leveldb.Value("old")},
+		{Key: leveldb.Key("bravo"), Value: leveldb.Value("old")},
+		{Key: leveldb.Key("charlie"), Value: leveldb.Value("old")},
+	} {
+		if err = db.Put(datum.Key, datum.Value); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+	flushToTempFile(t, db)
+	if err = db.Put(leveldb.Key("alpha"), leveldb.Value("new")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err = db.Delete(leveldb.Key("bravo")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	flushToTempFile(t, db)
+	if err = db.Delete(leveldb.Key("charlie")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+
+	for _, tc := range []struct {
+		key      string
+		expected leveldb.Value
+	}{
+		{key: "alpha", expected: leveldb.Value("new")}, // newer SSTable shadows older one
+		{key: "bravo"},   // tombstone in newer SSTable hides value in older one
+		{key: "charlie"}, // tombstone in memTable hides value in SSTable
+		{key: "delta"},   // never written
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			val, err := db.Get(leveldb.Key(tc.key))
+			if tc.expected == nil {
+				if !errors.Is(err, leveldb.ErrKeyNotFound) {
+					t.Errorf("expected ErrKeyNotFound, got %q (err %v)", val, err)
+				}
+			} else if err != nil {
+				t.Errorf("unexpected error executing Get(): %v", err)
+			} else if !bytes.Equal(val, tc.expected) {
+				t.Errorf("expected value %q, got %q", tc.expected, val)
+			}
+
+			exists, err := db.Has(leveldb.Key(tc.key))
+			if err != nil {
+				t.Errorf("unexpected error executing Has(): %v", err)
+			}
+			if exists != (tc.expected != nil) {
+				t.Errorf("expected Has() to return %t, got %t", tc.expected != nil, exists)
+			}
+		})
+	}
+}
+
+func TestDb_RangeScan_FlushedSSTables(t *testing.T) {
+	var (
+		db  = NewDb(nil).(*db)
+		err error
+	)
+
+	for _, key := range []string{"abc", "abd", "abe", "abf", "abg"} {
+		if err = db.Put(leveldb.Key(key), leveldb.Value("old")); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+	flushToTempFile(t, db)
+	if err = db.Put(leveldb.Key("abd"), leveldb.Value("new")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err = db.Delete(leveldb.Key("abe")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	flushToTempFile(t, db)
+	if err = db.Delete(leveldb.Key("abc")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	if err = db.Put(leveldb.Key("abf"), leveldb.Value("newest")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+
+	results, err := db.RangeScan(leveldb.Key("abb"), leveldb.Key("abf"))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	defer results.Close()
+	expectedResults := []leveldb.DataEntry{
+		{Key: leveldb.Key("abd"), Value: leveldb.Value("new")},
+		{Key: leveldb.Key("abf"), Value: leveldb.Value("newest")},
+	}
+	for j, datum := range expectedResults {
+		if !results.Next() {
+			t.Fatalf("expected more results, got %d", j)
+		}
+		if !bytes.Equal(datum.Key, results.Key()) || !bytes.Equal(datum.Value, results.Value()) {
+			t.Fatalf("expected %q=%q, got %q=%q", datum.Key, datum.Value, results.Key(), results.Value())
+		}
+	}
+	if results.Next() {
+		t.Fatalf("got more results than expected: %q=%q", results.Key(), results.Value())
+	}
+	if err := results.Error(); err != nil {
+		t.Fatal("iterator generated unexpected error", err)
+	}
+}
+
+// TestDb_RangeScan_Bidirectional pages through a range in both directions, over keys written to several layers and
+// overwritten and deleted after a snapshot the scan reads at.
+func TestDb_RangeScan_Bidirectional(t *testing.T) {
+	var database = NewDb(nil).(*db)
+	for j := 0; j < 10; j++ {
+		var key = fmt.Sprintf("key%d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value("old")); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+	flushToTempFile(t, database)
+	for _, key := range []string{"key1", "key4", "key8"} {
+		if err := database.Delete(leveldb.Key(key)); err != nil {
+			t.Fatal("unexpected error executing Delete()", err)
+		}
+	}
+	if err := database.Put(leveldb.Key("key5"), leveldb.Value("new")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	var snapshot = database.GetSnapshot()
+	defer snapshot.Release()
+	if err := database.Put(leveldb.Key("key1"), leveldb.Value("after snapshot")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err := database.Delete(leveldb.Key("key5")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+
+	results, err := database.RangeScan(leveldb.Key("key0"), leveldb.Key("key9"), leveldb.WithSnapshot(snapshot))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	defer results.Close()
+	// page collects up to n keys, moving with move after the first, and leaves the iterator at the last
+	var page = func(first bool, move func() bool, n int) []string {
+		var keys []string
+		for ok := first; ok; ok = move() {
+			keys = append(keys, string(results.Key())+"="+string(results.Value()))
+			if len(keys) == n {
+				break
+			}
+		}
+		return keys
+	}
+
+	t.Run("Backward", func(t *testing.T) {
+		var keys = page(results.SeekToLast(), results.Prev, 10)
+		if fmt.Sprint(keys) != "[key9=old key7=old key6=old key5=new key3=old key2=old key0=old]" {
+			t.Errorf("expected the keys visible at the snapshot last to first, got %v", keys)
+		}
+	})
+	t.Run("PagesForward", func(t *testing.T) {
+		var firstPage = page(results.SeekToFirst(), results.Next, 3)
+		if fmt.Sprint(firstPage) != "[key0=old key2=old key3=old]" {
+			t.Errorf("expected the first page of keys, got %v", firstPage)
+		}
+		// the next page starts after the last key of this one
+		var secondPage = page(results.Seek(leveldb.Key("key3\x00")), results.Next, 3)
+		if fmt.Sprint(secondPage) != "[key5=new key6=old key7=old]" {
+			t.Errorf("expected the second page of keys, got %v", secondPage)
+		}
+	})
+	t.Run("LatestBefore", func(t *testing.T) {
+		// the latest two keys before key7
+		results.Seek(leveldb.Key("key7"))
+		var keys = page(results.Prev(), results.Prev, 2)
+		if fmt.Sprint(keys) != "[key6=old key5=new]" {
+			t.Errorf("expected the two keys before %q, got %v", "key7", keys)
+		}
+		if !results.Next() || string(results.Key()) != "key6" {
+			t.Errorf("expected Next() to turn back to %q, got %q", "key6", results.Key())
+		}
+	})
+	if err := results.Error(); err != nil {
+		t.Fatal("iterator generated unexpected error", err)
+	}
+}
+
+func flushToTempFile(t *testing.T, db *db) {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "sst")
+	if err != nil {
+		t.Fatal("failed to create SST file:", err)
+	}
+	meta, err := db.flushSSTable(file, db.newFileNumber())
+	if err != nil {
+		t.Fatal("unexpected error flushing SSTable:", err)
+	}
+	db.installLevel0Table(meta)
+}
+
+func TestOpen_FlushesAtWriteBufferSize(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		opts     = &Options{WriteBufferSize: 64}
+		expected = make(map[string]string)
+	)
+	database, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	for j := range 50 {
+		key, value := fmt.Sprintf("key%03d", j%20), fmt.Sprintf("value%03d", j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value(value)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+		expected[key] = value
+	}
+	for j := 0; j < 20; j += 3 {
+		key := fmt.Sprintf("key%03d", j)
+		if err := database.Delete(leveldb.Key(key)); err != nil {
+			t.Fatal("unexpected error executing Delete()", err)
+		}
+		delete(expected, key)
+	}
+
+	logs, tables := countFiles(t, dir)
+	if logs != 1 {
+		t.Errorf("expected flushed WAL segments to be retired leaving 1, found %d", logs)
+	}
+	if tables == 0 {
+		t.Error("expected memtable to have been flushed to SSTables, found none")
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+
+	reopened, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if logs, _ := countFiles(t, dir); logs != 1 {
+		t.Errorf("expected replayed WAL segments to be retired leaving 1, found %d", logs)
+	}
+	for j := range 20 {
+		key := fmt.Sprintf("key%03d", j)
+		val, err := reopened.Get(leveldb.Key(key))
+		if expectedVal, ok := expected[key]; !ok {
+			if !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected %q to be deleted, got %q (err %v)", key, val, err)
+			}
+		} else if err != nil {
+			t.Errorf("unexpected error getting %q: %v", key, err)
+		} else if string(val) != expectedVal {
+			t.Errorf("expected %q=%q, got %q", key, expectedVal, val)
+		}
+	}
+}
+
+func countFiles(t *testing.T, dir string) (logs int, tables int) {
+	t.Helper()
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal("unexpected error reading directory:", err)
+	}
+	for _, dirEntry := range dirEntries {
+		switch _, fType, _ := parseFileName(dirEntry.Name()); fType {
+		case logFile:
+			logs++
+		case tableFile:
+			tables++
+		}
+	}
+	return logs, tables
+}
+
+func TestOpen_BlockCache(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		blockCacheSize int
+		expectHit      bool
+	}{
+		{name: "Default", expectHit: true},
+		{name: "Disabled", blockCacheSize: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			database, err := Open(t.TempDir(), &Options{WriteBufferSize: 1 << 10, BlockCacheSize: tc.blockCacheSize})
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			defer func() { _ = database.Close() }()
+			writeKeys(t, database, "key", 0, 100)
+			var reporter = database.(StatsReporter)
+
+			// a bypassing scan reads from disk, and leaves the blocks for the lookups to miss
+			results, err := database.RangeScan(leveldb.Key("key"), leveldb.Key("key999"), leveldb.WithBypassCache(true))
+			if err != nil {
+				t.Fatal("unexpected error executing RangeScan()", err)
+			}
+			defer results.Close()
+			for results.Next() {
+			}
+			var before = reporter.Stats()
+			for range 2 {
+				if _, err := database.Get(leveldb.Key("key000")); err != nil {
+					t.Fatal("unexpected error executing Get()", err)
+				}
+			}
+			var after = reporter.Stats()
+			if tc.expectHit && (after.BlockCacheMisses != before.BlockCacheMisses+1 ||
+				after.BlockCacheHits != before.BlockCacheHits+1) {
+				t.Errorf("expected the first lookup to miss and the second to hit, went from %+v to %+v", before, after)
+			}
+			if !tc.expectHit && after.BlockCacheHits+after.BlockCacheMisses != 0 {
+				t.Errorf("expected no block cache lookups without a cache, got %+v", after)
+			}
+		})
+	}
+}
+
+func TestOpen_TableCache(t *testing.T) {
+	// the cache holds two tables, and compaction is put off so that the flushes pile up in level 0.  Without a block
+	// cache, every read goes to the table's file.
+	var options = &Options{
+		WriteBufferSize:     1 << 10,
+		L0CompactionTrigger: 100,
+		BlockCacheSize:      -1,
+		MaxOpenFiles:        numNonTableFiles + 2,
+	}
+	var dir = t.TempDir()
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	writeKeys(t, database, "key", 0, 300)
+	var reporter = database.(StatsReporter)
+	if stats := reporter.Stats(); stats.Tables[0] <= 2 {
+		t.Fatalf("expected more level 0 tables than the cache holds, got %d", stats.Tables[0])
+	}
+
+	t.Run("StaysWithinLimit", func(t *testing.T) {
+		for j := range 300 {
+			var key = fmt.Sprintf("key%03d", j)
+			if value, err := database.Get(leveldb.Key(key)); err != nil || string(value) != "value of "+key {
+				t.Fatalf("expected Get(%q) to find %q, got %q (err %v)", key, "value of "+key, value, err)
+			}
+			if stats := reporter.Stats(); stats.OpenTables > 2 {
+				t.Fatalf("expected at most 2 tables open, got %d", stats.OpenTables)
+			}
+		}
+	})
+	t.Run("KeepsTablesOpenForIterators", func(t *testing.T) {
+		results, err := database.RangeScan(leveldb.Key("key"), leveldb.Key("key999"))
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		var count int
+		for results.Next() {
+			// lookups across every table evict the ones the iterator is reading
+			if _, err := database.Get(leveldb.Key(fmt.Sprintf("key%03d", (count*37)%300))); err != nil {
+				t.Fatal("unexpected error executing Get()", err)
+			}
+			if expected := fmt.Sprintf("key%03d", count); string(results.Key()) != expected {
+				t.Fatalf("expected key %q, got %q", expected, results.Key())
+			}
+			count++
+		}
+		if err := results.Error(); err != nil || count != 300 {
+			t.Errorf("expected the scan to see all 300 keys, got %d (err %v)", count, err)
+		}
+		// the tables evicted while the iterator read them are closed along with it
+		if err := results.Close(); err != nil {
+			t.Fatal("unexpected error closing iterator:", err)
+		}
+		if open := openTableFiles(t, dir); open > 2 {
+			t.Errorf("expected at most 2 tables open once the iterator is closed, got %d", open)
+		}
+	})
+}
+
+// openTableFiles counts the SSTables in dir the process holds open, skipping the test where open files cannot be
+// listed.
+func openTableFiles(t *testing.T, dir string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot list open files:", err)
+	}
+	var open int
+	for _, fd := range fds {
+		// descriptors closed since being listed fail to resolve, and are not counted
+		target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name()))
+		if err == nil && filepath.Dir(target) == dir && strings.HasSuffix(target, tableFileSuffix) {
+			open++
+		}
+	}
+	return open
+}
+
+// reverseComparator orders keys bytewise, backward.
+type reverseComparator struct{}
+
+func (reverseComparator) Compare(a, b leveldb.Key) int { return bytes.Compare(b, a) }
+
+func (reverseComparator) Name() string { return "test.ReverseComparator" }
+
+func TestOpen_Comparator(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		options = &Options{WriteBufferSize: 1 << 10, Comparator: reverseComparator{}}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	writeKeys(t, database, "key", 0, 300)
+	if stats := database.(StatsReporter).Stats(); stats.Tables[1] == 0 {
+		t.Fatalf("expected writes to be compacted into level 1, got %v tables", stats.Tables)
+	}
+	// checkOrder scans every key, expecting them from key299 back to key000
+	var checkOrder = func(t *testing.T, database leveldb.DB) {
+		t.Helper()
+		results, err := database.RangeScan(leveldb.Key("key299"), leveldb.Key("key000"))
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var count int
+		for ; results.Next(); count++ {
+			if expected := fmt.Sprintf("key%03d", 299-count); string(results.Key()) != expected {
+				t.Fatalf("expected key %q, got %q", expected, results.Key())
+			}
+		}
+		if err := results.Error(); err != nil || count != 300 {
+			t.Errorf("expected the scan to see all 300 keys, got %d (err %v)", count, err)
+		}
+		if value, err := database.Get(leveldb.Key("key150")); err != nil || string(value) != "value of key150" {
+			t.Errorf("expected Get() to find %q, got %q (err %v)", "value of key150", value, err)
+		}
+	}
+
+	t.Run("OrdersKeys", func(t *testing.T) {
+		checkOrder(t, database)
+	})
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	t.Run("ReopenedWithSameComparator", func(t *testing.T) {
+		reopened, err := Open(dir, options)
+		if err != nil {
+			t.Fatal("unexpected error reopening database:", err)
+		}
+		defer func() { _ = reopened.Close() }()
+		checkOrder(t, reopened)
+	})
+	t.Run("ReopenedWithOtherComparator", func(t *testing.T) {
+		if reopened, err := Open(dir, nil); err == nil {
+			_ = reopened.Close()
+			t.Error("expected reopening with the bytewise comparator to fail")
+		}
+	})
+}
+
+// testClock is a clock that only moves when a test advances it.
+type testClock struct {
+	time time.Time
+}
+
+func (c *testClock) now() time.Time { return c.time }
+
+func TestOpen_PutWithTTL(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		clock   = &testClock{time: time.Unix(1_700_000_000, 0)}
+		options = &Options{now: clock.now}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	var writer = database.(TTLWriter)
+	if err := database.Put(leveldb.Key("session"), leveldb.Value("old")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err := writer.PutWithTTL(leveldb.Key("session"), leveldb.Value("new"), time.Minute); err != nil {
+		t.Fatal("unexpected error executing PutWithTTL()", err)
+	}
+	if err := writer.PutWithTTL(leveldb.Key("token"), leveldb.Value("abc"), time.Hour); err != nil {
+		t.Fatal("unexpected error executing PutWithTTL()", err)
+	}
+	if err := database.Put(leveldb.Key("user"), leveldb.Value("alice")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	// checkExpiry checks that Get and RangeScan see the values that have not expired, and no others
+	var checkExpiry = func(t *testing.T, database leveldb.DB, expected map[string]string) {
+		t.Helper()
+		for _, key := range []string{"session", "token", "user"} {
+			value, err := database.Get(leveldb.Key(key))
+			if want, ok := expected[key]; ok && (err != nil || string(value) != want) {
+				t.Errorf("expected Get(%q) to find %q, got %q (err %v)", key, want, value, err)
+			} else if !ok && !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected Get(%q) to find nothing once expired, got %q (err %v)", key, value, err)
+			}
+		}
+		results, err := database.RangeScan(leveldb.Key("a"), leveldb.Key("z"))
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var scanned = make(map[string]string)
+		for results.Next() {
+			scanned[string(results.Key())] = string(results.Value())
+		}
+		if fmt.Sprint(scanned) != fmt.Sprint(expected) {
+			t.Errorf("expected RangeScan() to see %v, got %v", expected, scanned)
+		}
+	}
+
+	t.Run("BeforeExpiry", func(t *testing.T) {
+		checkExpiry(t, database, map[string]string{"session": "new", "token": "abc", "user": "alice"})
+	})
+	t.Run("AfterExpiry", func(t *testing.T) {
+		clock.time = clock.time.Add(2 * time.Minute)
+		// the expired value hides the one it replaced
+		checkExpiry(t, database, map[string]string{"token": "abc", "user": "alice"})
+		if err := database.Delete(leveldb.Key("session")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected deleting an expired key to report it not found, got %v", err)
+		}
+	})
+	t.Run("RejectsNonPositiveTTL", func(t *testing.T) {
+		if err := writer.PutWithTTL(leveldb.Key("token"), leveldb.Value("abc"), 0); err == nil {
+			t.Error("expected a zero ttl to be rejected")
+		}
+	})
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	t.Run("ReplaysExpiry", func(t *testing.T) {
+		reopened, err := Open(dir, options)
+		if err != nil {
+			t.Fatal("unexpected error reopening database:", err)
+		}
+		defer func() { _ = reopened.Close() }()
+		checkExpiry(t, reopened, map[string]string{"token": "abc", "user": "alice"})
+		clock.time = clock.time.Add(time.Hour)
+		checkExpiry(t, reopened, map[string]string{"user": "alice"})
+	})
+}
+
+func TestOpen_DeleteRange(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		options = &Options{WriteBufferSize: 1 << 10, L0CompactionTrigger: 100}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	writeKeys(t, database, "key", 0, 100)
+	var snapshot = database.GetSnapshot()
+	defer snapshot.Release()
+	if err := database.(RangeDeleter).DeleteRange(leveldb.Key("key020"), leveldb.Key("key079")); err != nil {
+		t.Fatal("unexpected error executing DeleteRange()", err)
+	}
+	// checkDeleted checks that Get and RangeScan see the keys outside the deleted range, and no others
+	var checkDeleted = func(t *testing.T, database leveldb.DB, opts ...leveldb.ReadOption) {
+		t.Helper()
+		for _, key := range []string{"key000", "key019", "key020", "key050", "key079", "key080", "key099"} {
+			var deleted = key >= "key020" && key <= "key079"
+			if _, err := database.Get(leveldb.Key(key), opts...); deleted && !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected Get(%q) to find nothing once deleted, got err %v", key, err)
+			} else if !deleted && err != nil {
+				t.Errorf("unexpected error getting %q: %v", key, err)
+			}
+		}
+		results, err := database.RangeScan(leveldb.Key("key000"), leveldb.Key("key099"), opts...)
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var count int
+		for ; results.Next(); count++ {
+		}
+		if err := results.Error(); err != nil || count != 40 {
+			t.Errorf("expected the scan to see the 40 keys outside the deleted range, got %d (err %v)", count, err)
+		}
+	}
+
+	t.Run("InMemTable", func(t *testing.T) {
+		checkDeleted(t, database)
+	})
+	t.Run("SnapshotBefore", func(t *testing.T) {
+		value, err := database.Get(leveldb.Key("key050"), leveldb.WithSnapshot(snapshot))
+		if err != nil || string(value) != "value of key050" {
+			t.Errorf("expected a snapshot taken before the range delete to find %q, got %q (err %v)",
+				"value of key050", value, err)
+		}
+	})
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	// the range tombstone is replayed from the WAL, then flushed to a table
+	t.Run("Reopened", func(t *testing.T) {
+		reopened, err := Open(dir, options)
+		if err != nil {
+			t.Fatal("unexpected error reopening database:", err)
+		}
+		defer func() { _ = reopened.Close() }()
+		checkDeleted(t, reopened)
+		if size := reopened.(*db).memTableSize(); size != 0 {
+			t.Errorf("expected the replayed range tombstone to be flushed, got a memTable of %d bytes", size)
+		}
+	})
+}
+
+// appendOperator merges operands by appending them to the value, comma-separated.
+type appendOperator struct{}
+
+func (appendOperator) Merge(_ leveldb.Key, existing leveldb.Value, operands []leveldb.Value) (leveldb.Value, error) {
+	var parts []string
+	if existing != nil {
+		parts = append(parts, string(existing))
+	}
+	for _, operand := range operands {
+		parts = append(parts, string(operand))
+	}
+	return leveldb.Value(strings.Join(parts, ",")), nil
+}
+
+func (appendOperator) Name() string { return "test.AppendOperator" }
+
+func TestOpen_Merge(t *testing.T) {
+	var (
+		dir     = t.TempDir()
+		options = &Options{MergeOperator: appendOperator{}}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	var merger = database.(Merger)
+	var merge = func(key string, operand string) {
+		t.Helper()
+		if err := merger.Merge(leveldb.Key(key), leveldb.Value(operand)); err != nil {
+			t.Fatal("unexpected error executing Merge()", err)
+		}
+	}
+	if err := database.Put(leveldb.Key("a"), leveldb.Value("x")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	merge("a", "1")
+	var snapshot = database.GetSnapshot()
+	defer snapshot.Release()
+	merge("a", "2")
+	merge("b", "1")
+	merge("b", "2")
+	if err := database.Put(leveldb.Key("c"), leveldb.Value("y")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err := database.Delete(leveldb.Key("c")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	merge("c", "3")
+	// checkMerged checks that Get and RangeScan, in both directions, see the operands merged into the values
+	var checkMerged = func(t *testing.T, database leveldb.DB) {
+		t.Helper()
+		var expected = map[string]string{"a": "x,1,2", "b": "1,2", "c": "3"}
+		for key, want := range expected {
+			if value, err := database.Get(leveldb.Key(key)); err != nil || string(value) != want {
+				t.Errorf("expected Get(%q) to find %q, got %q (err %v)", key, want, value, err)
+			}
+		}
+		results, err := database.RangeScan(leveldb.Key("a"), leveldb.Key("z"))
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var forward, backward []string
+		for results.Next() {
+			forward = append(forward, fmt.Sprintf("%s=%s", results.Key(), results.Value()))
+		}
+		for ok := results.SeekToLast(); ok; ok = results.Prev() {
+			backward = append([]string{fmt.Sprintf("%s=%s", results.Key(), results.Value())}, backward...)
+		}
+		if err := results.Error(); err != nil {
+			t.Fatal("unexpected error scanning:", err)
+		}
+		for _, scanned := range [][]string{forward, backward} {
+			if fmt.Sprint(scanned) != "[a=x,1,2 b=1,2 c=3]" {
+				t.Errorf("expected RangeScan() to see the merged values, got %v", scanned)
+			}
+		}
+	}
+
+	t.Run("InMemTable", func(t *testing.T) {
+		checkMerged(t, database)
+	})
+	t.Run("SnapshotBefore", func(t *testing.T) {
+		value, err := database.Get(leveldb.Key("a"), leveldb.WithSnapshot(snapshot))
+		if err != nil || string(value) != "x,1" {
+			t.Errorf("expected a snapshot to see the operands merged before it, got %q (err %v)", value, err)
+		}
+		value, err = database.Get(leveldb.Key("b"), leveldb.WithSnapshot(snapshot))
+		if !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected a snapshot to see nothing merged after it, got %q (err %v)", value, err)
+		}
+	})
+	t.Run("WithoutMergeOperator", func(t *testing.T) {
+		other, err := Open(t.TempDir(), nil)
+		if err != nil {
+			t.Fatal("unexpected error opening database:", err)
+		}
+		defer func() { _ = other.Close() }()
+		if err := other.(Merger).Merge(leveldb.Key("a"), leveldb.Value("1")); err == nil {
+			t.Error("expected Merge() to be rejected without a MergeOperator")
+		}
+	})
+	t.Run("ReleasesTables", func(t *testing.T) {
+		// the cache holds one table, so that reading the operands from three evicts the ones read before
+		var dir = t.TempDir()
+		other, err := Open(dir, &Options{
+			MergeOperator:       appendOperator{},
+			L0CompactionTrigger: 100,
+			BlockCacheSize:      -1,
+			MaxOpenFiles:        numNonTableFiles + 1,
+		})
+		if err != nil {
+			t.Fatal("unexpected error opening database:", err)
+		}
+		defer func() { _ = other.Close() }()
+		for _, operand := range []string{"1", "2", "3"} {
+			if err := other.(Merger).Merge(leveldb.Key("a"), leveldb.Value(operand)); err != nil {
+				t.Fatal("unexpected error executing Merge()", err)
+			}
+			if err := other.(*db).compactMemTable(); err != nil {
+				t.Fatal("unexpected error flushing memtable:", err)
+			}
+		}
+		if value, err := other.Get(leveldb.Key("a")); err != nil || string(value) != "1,2,3" {
+			t.Errorf("expected Get() to merge the operands into %q, got %q (err %v)", "1,2,3", value, err)
+		}
+		if open := openTableFiles(t, dir); open > 1 {
+			t.Errorf("expected at most 1 table open once Get() returns, got %d", open)
+		}
+	})
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	// the operands are replayed from the WAL, then flushed to a table as they are
+	t.Run("Reopened", func(t *testing.T) {
+		reopened, err := Open(dir, options)
+		if err != nil {
+			t.Fatal("unexpected error reopening database:", err)
+		}
+		checkMerged(t, reopened)
+		if err := reopened.Close(); err != nil {
+			t.Fatal("unexpected error closing database:", err)
+		}
+
+		reopened, err = Open(dir, nil)
+		if err != nil {
+			t.Fatal("unexpected error reopening database:", err)
+		}
+		defer func() { _ = reopened.Close() }()
+		if _, err := reopened.Get(leveldb.Key("a")); err == nil || errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected reading operands without a MergeOperator to fail, got err %v", err)
+		}
+	})
+}
+
+func TestOpen_ColumnFamilies(t *testing.T) {
+	var (
+		dir      = t.TempDir()
+		reversed = &Options{Comparator: reverseComparator{}, WriteBufferSize: 1 << 10}
+		options  = &Options{ColumnFamilies: map[string]*Options{"reversed": reversed}}
+	)
+	database, err := Open(dir, options)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	var families = database.(ColumnFamilies)
+	reversedFamily, err := families.CreateColumnFamily("reversed", reversed)
+	if err != nil {
+		t.Fatal("unexpected error creating column family:", err)
+	}
+	otherFamily, err := families.CreateColumnFamily("other", nil)
+	if err != nil {
+		t.Fatal("unexpected error creating column family:", err)
+	}
+	// a single batch writes to every family at once
+	var batch leveldb.WriteBatch
+	batch.Put(leveldb.Key("a"), leveldb.Value("default"))
+	batch.SetColumnFamily(reversedFamily.ID)
+	batch.Put(leveldb.Key("a"), leveldb.Value("reversed"))
+	batch.Put(leveldb.Key("b"), leveldb.Value("reversed"))
+	batch.SetColumnFamily(otherFamily.ID)
+	batch.Put(leveldb.Key("a"), leveldb.Value("other"))
+	if err := database.Write(&batch); err != nil {
+		t.Fatal("unexpected error executing Write()", err)
+	}
+	// enough keys to flush the reversed family's memTable several times over
+	for j := range 100 {
+		var key = leveldb.Key(fmt.Sprintf("key%03d", j))
+		if err := database.Put(key, leveldb.Value("reversed"), leveldb.ToColumnFamily(reversedFamily.ID)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+	// checkFamilies checks that each family sees only its own keys, ordered by its own comparator
+	var checkFamilies = func(t *testing.T, database leveldb.DB) {
+		t.Helper()
+		for id, want := range map[uint32]string{0: "default", reversedFamily.ID: "reversed"} {
+			value, err := database.Get(leveldb.Key("a"), leveldb.FromColumnFamily(id))
+			if err != nil || string(value) != want {
+				t.Errorf("expected Get() from column family %d to find %q, got %q (err %v)", id, want, value, err)
+			}
+		}
+		if _, err := database.Get(leveldb.Key("b")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected the default column family not to hold another family's key, got err %v", err)
+		}
+		var fromReversed = leveldb.FromColumnFamily(reversedFamily.ID)
+		results, err := database.RangeScan(leveldb.Key("key099"), leveldb.Key("a"), fromReversed)
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var keys []string
+		for results.Next() {
+			keys = append(keys, string(results.Key()))
+		}
+		if err := results.Error(); err != nil {
+			t.Fatal("unexpected error scanning:", err)
+		}
+		if len(keys) != 102 || keys[0] != "key099" || keys[100] != "b" || keys[101] != "a" {
+			t.Errorf("expected RangeScan() to see the family's 102 keys in reverse order, got %v", keys)
+		}
+	}
+
+	t.Run("Isolated", func(t *testing.T) {
+		checkFamilies(t, database)
+		value, err := database.Get(leveldb.Key("a"), leveldb.FromColumnFamily(otherFamily.ID))
+		if err != nil || string(value) != "other" {
+			t.Errorf("expected Get() from column family %d to find %q, got %q (err %v)", otherFamily.ID, "other", value, err)
+		}
+	})
+	t.Run("Unknown", func(t *testing.T) {
+		if _, err := database.Get(leveldb.Key("a"), leveldb.FromColumnFamily(99)); err == nil {
+			t.Error("expected Get() from an unknown column family to fail")
+		}
+		if err := database.Put(leveldb.Key("a"), leveldb.Value("x"), leveldb.ToColumnFamily(99)); err == nil {
+			t.Error("expected Put() to an unknown column family to fail")
+		}
+		if _, err := families.CreateColumnFamily("other", nil); err == nil {
+			t.Error("expected creating a column family twice to fail")
+		}
+		if err := families.DropColumnFamily(DefaultColumnFamily); err == nil {
+			t.Error("expected dropping the default column family to fail")
+		}
+		if _, err := NewDb(nil).(ColumnFamilies).CreateColumnFamily("other", nil); err == nil {
+			t.Error("expected creating a column family without a directory to fail")
+		}
+	})
+	t.Run("Dropped", func(t *testing.T) {
+		if err := families.DropColumnFamily("other"); err != nil {
+			t.Fatal("unexpected error dropping column family:", err)
+		}
+		if _, err := database.Get(leveldb.Key("a"), leveldb.FromColumnFamily(otherFamily.ID)); err == nil {
+			t.Error("expected Get() from a dropped column family to fail")
+		}
+	})
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	// the first reopening replays the families' writes from the WAL, the second reads them from the tables it flushed
+	t.Run("Reopened", func(t *testing.T) {
+		for range 2 {
+			reopened, err := Open(dir, options)
+			if err != nil {
+				t.Fatal("unexpected error reopening database:", err)
+			}
+			checkFamilies(t, reopened)
+			var listed = reopened.(ColumnFamilies).ListColumnFamilies()
+			if fmt.Sprint(listed) != "[{0 default} {1 reversed}]" {
+				t.Errorf("expected the dropped column family to stay dropped, got %v", listed)
+			}
+			if err := reopened.Close(); err != nil {
+				t.Fatal("unexpected error closing database:", err)
+			}
+		}
+		if reopened, err := Open(dir, nil); err == nil {
+			_ = reopened.Close()
+			t.Error("expected reopening with another comparator for a column family to fail")
+		}
+	})
+}
+
+// TestOpen_ColumnFamilyFlush checks that flushing leaves alone the families that have no writes to flush, the default
+// one included, rather than adding empty tables to them.
+func TestOpen_ColumnFamilyFlush(t *testing.T) {
+	database, err := Open(t.TempDir(), &Options{L0CompactionTrigger: 100})
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	var families = database.(ColumnFamilies)
+	written, err := families.CreateColumnFamily("written", &Options{WriteBufferSize: 256, L0CompactionTrigger: 100})
+	if err != nil {
+		t.Fatal("unexpected error creating column family:", err)
+	}
+	unwritten, err := families.CreateColumnFamily("unwritten", nil)
+	if err != nil {
+		t.Fatal("unexpected error creating column family:", err)
+	}
+	for j := range 200 {
+		var key = leveldb.Key(fmt.Sprintf("key%03d", j))
+		if err := database.Put(key, leveldb.Value("value"), leveldb.ToColumnFamily(written.ID)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+
+	var impl = database.(*db)
+	impl.mu.Lock()
+	defer impl.mu.Unlock()
+	if tables := impl.families[written.ID].current.numFiles(); tables < 2 {
+		t.Fatalf("expected the written column family to be flushed several times, got %d tables", tables)
+	}
+	for _, id := range []uint32{0, unwritten.ID} {
+		if tables := impl.families[id].current.numFiles(); tables != 0 {
+			t.Errorf("expected column family %d, never written, to hold no tables, got %d", id, tables)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type fileType uint8
+
+const (
+	_ fileType = iota
+	logFile
+	tableFile
+	manifestFile
+	currentFile
+	tempFile
+)
+
+const (
+	logFileSuffix      = ".log"
+	tableFileSuffix    = ".sst"
+	tempFileSuffix     = ".dbtmp"
+	manifestFilePrefix = "MANIFEST-"
+	currentFileName    = "CURRENT"
+)
+
+// logFileName, tableFileName, manifestFileName and tempFileName share a single sequence of file numbers, so the number
+// alone identifies a file.
+func logFileName(dir string, number uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d%s", number, logFileSuffix))
+}
+
+func tableFileName(dir string, number uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d%s", number, tableFileSuffix))
+}
+
+func manifestFileName(dir string, number uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%06d", manifestFilePrefix, number))
+}
+
+func tempFileName(dir string, number uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%06d%s", number, tempFileSuffix))
+}
+
+// parseFileName recognizes the base names of the files a database directory holds.  The number is zero for CURRENT.
+func parseFileName(name string) (uint64, fileType, bool) {
+	if name == currentFileName {
+		return 0, currentFile, true
+	}
+	if numberStr, ok := strings.CutPrefix(name, manifestFilePrefix); ok {
+		number, err := strconv.ParseUint(numberStr, 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return number, manifestFile, true
+	}
+	var suffixes = map[string]fileType{
+		logFileSuffix:   logFile,
+		tableFileSuffix: tableFile,
+		tempFileSuffix:  tempFile,
+	}
+	for suffix, fType := range suffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		number, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return number, fType, true
+	}
+	return 0, 0, false
+}
+
+// setCurrentFile points CURRENT at the given manifest.  The new contents are written to a temporary file and renamed
+// into place, so that a crash leaves CURRENT pointing at either the old manifest or the new one.
+func setCurrentFile(dir string, manifestNumber uint64, tempNumber uint64) error {
+	var (
+		contents = filepath.Base(manifestFileName(dir, manifestNumber)) + "\n"
+		tempName = tempFileName(dir, tempNumber)
+	)
+	f, err := os.Create(tempName)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(contents); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tempName, filepath.Join(dir, currentFileName)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// readCurrentFile returns the number of the manifest CURRENT points at.  os.ErrNotExist is returned as-is for a
+// directory that has no database yet.
+func readCurrentFile(dir string) (uint64, error) {
+	contents, err := os.ReadFile(filepath.Join(dir, currentFileName))
+	if err != nil {
+		return 0, err
+	}
+	var name = strings.TrimSuffix(string(contents), "\n")
+	number, fType, ok := parseFileName(name)
+	if !ok || fType != manifestFile {
+		return 0, fmt.Errorf("CURRENT has malformed contents %q", contents)
+	}
+	return number, nil
+}
+
+// syncDir makes renames, creations and removals within dir durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+	return f.Sync()
+}
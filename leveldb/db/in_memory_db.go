@@ -1,34 +1,54 @@
 package db
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"leveldb"
 	"slices"
+	"sync"
 )
 
+// inMemoryDb is safe for concurrent use: writes take turns under mu, while reads share it.
 type inMemoryDb struct {
+	mu   sync.RWMutex
 	data []leveldb.DataEntry
+	// comparator orders data
+	comparator leveldb.Comparator
 }
 
 // NewInMemoryDb copies its input slice into a new instance to prevent the database from deleting entries in a reference
 // shared by other properties.
 func NewInMemoryDb(data []leveldb.DataEntry) leveldb.DB {
+	return NewInMemoryDbWithComparator(data, leveldb.BytewiseComparator)
+}
+
+// NewInMemoryDbWithComparator is like NewInMemoryDb, but orders keys by comparator rather than bytewise.  data must
+// already be sorted by it.
+func NewInMemoryDbWithComparator(data []leveldb.DataEntry, comparator leveldb.Comparator) leveldb.DB {
 	var copied = make([]leveldb.DataEntry, len(data))
 	copy(copied, data)
-	return &inMemoryDb{data: copied}
+	return &inMemoryDb{data: copied, comparator: comparator}
 }
 
+// inMemoryIterator iterates over entries sorted by key.  curr is -1 before the first entry and len(data) after the
+// last.
 type inMemoryIterator struct {
-	data []leveldb.DataEntry
-	curr int
-	err  error
+	data    []leveldb.DataEntry
+	curr    int
+	err     error
+	compare func(a, b leveldb.Key) int
 }
 
+// NewInMemoryIterator iterates over data, which must be sorted bytewise by key.
 func NewInMemoryIterator(data []leveldb.DataEntry) leveldb.Iterator {
+	return newInMemoryIterator(data, leveldb.Key.Compare)
+}
+
+func newInMemoryIterator(data []leveldb.DataEntry, compare func(a, b leveldb.Key) int) *inMemoryIterator {
 	return &inMemoryIterator{
-		data: data,
-		curr: -1,
+		data:    data,
+		curr:    -1,
+		compare: compare,
 	}
 }
 
@@ -39,42 +59,135 @@ func (i *inMemoryIterator) Next() bool {
 	return i.curr < len(i.data)
 }
 
+func (i *inMemoryIterator) Prev() bool {
+	if i.curr >= 0 {
+		i.curr--
+	}
+	return i.curr >= 0
+}
+
+func (i *inMemoryIterator) Seek(key leveldb.Key) bool {
+	i.curr, _ = slices.BinarySearchFunc(
+		i.data,
+		key,
+		func(datum leveldb.DataEntry, targetKey leveldb.Key) int { return i.compare(datum.Key, targetKey) },
+	)
+	return i.curr < len(i.data)
+}
+
+func (i *inMemoryIterator) SeekToFirst() bool {
+	i.curr = 0
+	return i.curr < len(i.data)
+}
+
+func (i *inMemoryIterator) SeekToLast() bool {
+	i.curr = len(i.data) - 1
+	return i.curr >= 0
+}
+
 func (i *inMemoryIterator) Error() error {
 	return i.err
 }
 
+func (i *inMemoryIterator) valid() bool {
+	return i.curr >= 0 && i.curr < len(i.data)
+}
+
 func (i *inMemoryIterator) Key() leveldb.Key {
-	if i.curr >= len(i.data) {
+	if !i.valid() {
 		return nil
 	}
 	return i.data[i.curr].Key
 }
 
 func (i *inMemoryIterator) Value() leveldb.Value {
-	if i.curr >= len(i.data) {
+	if !i.valid() {
 		return nil
 	}
 	return i.data[i.curr].Value
 }
 
-func (db *inMemoryDb) Get(key leveldb.Key) (leveldb.Value, error) {
-	var idx, found = slices.BinarySearchFunc(
-		db.data,
-		key,
-		func(datum leveldb.DataEntry, target leveldb.Key) int { return bytes.Compare(datum.Key, target) },
-	)
+// Close does nothing, since the iterator holds no more than its entries.
+func (i *inMemoryIterator) Close() error {
+	return nil
+}
+
+// inMemorySnapshot is a copy of the data as it was when the snapshot was taken.
+type inMemorySnapshot struct {
+	db   *inMemoryDb
+	data []leveldb.DataEntry
+}
+
+func (s *inMemorySnapshot) Release() {}
+
+func (db *inMemoryDb) GetSnapshot() leveldb.Snapshot {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return &inMemorySnapshot{db: db, data: slices.Clone(db.data)}
+}
+
+// at returns the database a read with the given options sees: db itself, or a snapshot's copy of it.  The caller holds
+// db.mu for reading.
+func (db *inMemoryDb) at(opts []leveldb.ReadOption) (*inMemoryDb, error) {
+	var readOptions = leveldb.NewReadOptions(opts...)
+	if err := checkDefaultFamily(readOptions.ColumnFamily); err != nil {
+		return nil, err
+	}
+	var snapshot = readOptions.Snapshot
+	if snapshot == nil {
+		return db, nil
+	}
+	s, ok := snapshot.(*inMemorySnapshot)
+	if !ok || s.db != db {
+		return nil, fmt.Errorf("snapshot does not belong to this database")
+	}
+	return &inMemoryDb{data: s.data, comparator: db.comparator}, nil
+}
+
+func (db *inMemoryDb) Get(key leveldb.Key, opts ...leveldb.ReadOption) (leveldb.Value, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db, err := db.at(opts)
+	if err != nil {
+		return nil, err
+	}
+	var idx, found = db.findEntryByKey(key)
 	if !found {
 		return nil, leveldb.NewNotFoundError(key)
 	}
 	return db.data[idx].Value, nil
 }
 
-func (db *inMemoryDb) Has(key leveldb.Key) (bool, error) {
+func (db *inMemoryDb) Has(key leveldb.Key, opts ...leveldb.ReadOption) (bool, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db, err := db.at(opts)
+	if err != nil {
+		return false, err
+	}
 	var _, found = db.findEntryByKey(key)
 	return found, nil
 }
 
-func (db *inMemoryDb) Put(key leveldb.Key, value leveldb.Value) error {
+// checkDefaultFamily rejects reads and writes naming a column family other than the default one, the only one an
+// inMemoryDb holds.
+func checkDefaultFamily(id uint32) error {
+	if id != 0 {
+		return fmt.Errorf("no column family with ID %d", id)
+	}
+	return nil
+}
+
+func (db *inMemoryDb) Put(key leveldb.Key, value leveldb.Value, opts ...leveldb.WriteOption) error {
+	if err := checkDefaultFamily(leveldb.NewWriteOptions(opts...).ColumnFamily); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.put(key, value)
+}
+
+func (db *inMemoryDb) put(key leveldb.Key, value leveldb.Value) error {
 	var idx, keyExists = db.findEntryByKey(key)
 
 	if keyExists {
@@ -90,37 +203,123 @@ func (db *inMemoryDb) Put(key leveldb.Key, value leveldb.Value) error {
 	return nil
 }
 
-func (db *inMemoryDb) Delete(key leveldb.Key) error {
-	var idx, keyExists = db.findEntryByKey(key)
-	if !keyExists {
+func (db *inMemoryDb) Delete(key leveldb.Key, opts ...leveldb.WriteOption) error {
+	if err := checkDefaultFamily(leveldb.NewWriteOptions(opts...).ColumnFamily); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.delete(key)
+}
+
+func (db *inMemoryDb) delete(key leveldb.Key) error {
+	if !db.remove(key) {
 		return fmt.Errorf("key %q not found\n", key)
 	}
+	return nil
+}
+
+// remove deletes the entry for key, if there is one, and reports whether there was.
+func (db *inMemoryDb) remove(key leveldb.Key) bool {
+	var idx, keyExists = db.findEntryByKey(key)
+	if keyExists {
+		db.data = slices.Delete(db.data, idx, idx+1)
+	}
+	return keyExists
+}
+
+func (db *inMemoryDb) DeleteRange(start leveldb.Key, limit leveldb.Key, opts ...leveldb.WriteOption) error {
+	var batch = newSingleWriteBatch(opts)
+	batch.DeleteRange(start, limit)
+	return db.Write(&batch)
+}
 
-	db.data = slices.Delete(db.data, idx, idx+1)
-	db.sortData()
+// deleteRange deletes every entry in [start, limit], leaving the rest sorted.
+func (db *inMemoryDb) deleteRange(start leveldb.Key, limit leveldb.Key) {
+	db.data = slices.DeleteFunc(db.data, func(datum leveldb.DataEntry) bool {
+		return db.comparator.Compare(datum.Key, start) >= 0 && db.comparator.Compare(datum.Key, limit) <= 0
+	})
+}
+
+// Write applies the batch to a copy of the data, which replaces it only once every write has succeeded.  As with the
+// database, blank keys and values and backwards ranges fail the batch, while deleting a key that is not there does
+// not.  Values that expire, merges and column families other than the default one are not supported.
+func (db *inMemoryDb) Write(batch *leveldb.WriteBatch, _ ...leveldb.WriteOption) error {
+	for _, entry := range batch.Entries() {
+		if len(entry.Key) == 0 {
+			return errors.New("inMemoryDb.Write: cannot write blank key")
+		}
+		if !entry.Deleted && len(entry.Value) == 0 {
+			return fmt.Errorf("inMemoryDb.Write: cannot insert blank value for %q", entry.Key)
+		}
+		if entry.Deleted && entry.Limit != nil {
+			if len(entry.Limit) == 0 || db.comparator.Compare(entry.Key, entry.Limit) > 0 {
+				return fmt.Errorf("inMemoryDb.Write: cannot delete range from %q to %q", entry.Key, entry.Limit)
+			}
+		}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var staged = &inMemoryDb{data: slices.Clone(db.data), comparator: db.comparator}
+	for _, entry := range batch.Entries() {
+		var err = checkDefaultFamily(entry.ColumnFamily)
+		switch {
+		case err != nil:
+		case entry.Deleted && entry.Limit != nil:
+			staged.deleteRange(entry.Key, entry.Limit)
+		case entry.Deleted:
+			staged.remove(entry.Key)
+		case !entry.ExpiresAt.IsZero():
+			err = fmt.Errorf("cannot write %q: values that expire are not supported", entry.Key)
+		case entry.Merge:
+			err = fmt.Errorf("cannot write %q: merges are not supported", entry.Key)
+		default:
+			err = staged.put(entry.Key, entry.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	db.data = staged.data
+	return nil
+}
 
+func (db *inMemoryDb) Close() error {
 	return nil
 }
 
-func (db *inMemoryDb) RangeScan(start leveldb.Key, limit leveldb.Key) (leveldb.Iterator, error) {
+// RangeScan iterates over a copy of the entries in range, so that later writes do not disturb it.
+func (db *inMemoryDb) RangeScan(
+	start leveldb.Key,
+	limit leveldb.Key,
+	opts ...leveldb.ReadOption,
+) (leveldb.Iterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	db, err := db.at(opts)
+	if err != nil {
+		return nil, err
+	}
 	firstIdx, _ := db.findEntryByKey(start)
 	lastIdx, lastIsInDataset := db.findEntryByKey(limit)
 	if lastIsInDataset {
 		lastIdx++ // we want to include matching entry in the return Iterator
 	}
-	return NewInMemoryIterator(db.data[firstIdx:lastIdx]), nil
+	return newInMemoryIterator(slices.Clone(db.data[firstIdx:lastIdx]), db.comparator.Compare), nil
 }
 
 func (db *inMemoryDb) findEntryByKey(key leveldb.Key) (int, bool) {
 	return slices.BinarySearchFunc(
 		db.data,
 		key,
-		func(datum leveldb.DataEntry, targetKey leveldb.Key) int { return bytes.Compare(datum.Key, targetKey) },
+		func(datum leveldb.DataEntry, targetKey leveldb.Key) int {
+			return db.comparator.Compare(datum.Key, targetKey)
+		},
 	)
 }
 
 func (db *inMemoryDb) sortData() {
 	slices.SortFunc(db.data, func(d1, d2 leveldb.DataEntry) int {
-		return bytes.Compare(d1.Key, d2.Key)
+		return db.comparator.Compare(d1.Key, d2.Key)
 	})
 }
@@ -0,0 +1,132 @@
+package db
+
+import (
+	"cmp"
+	"leveldb"
+	"leveldb/encoding"
+)
+
+// keyKind says whether an internal key records a put, a put of a value that expires, a delete, the deletion of a range
+// of keys, or a merge.
+type keyKind uint8
+
+const (
+	kindDelete keyKind = iota
+	kindValue
+	// kindValueWithExpiry is a put whose value starts with when it expires; see makeExpiringValue.
+	kindValueWithExpiry
+	// kindRangeDelete keys a range tombstone by the start of its range; see rangeTombstone.
+	kindRangeDelete
+	// kindMerge holds an operand for the MergeOperator to combine with the key's older entries; see mergeOperands.
+	kindMerge
+
+	// kindForSeek is the largest kind, whose keys sort first among those with the same user key and sequence number.
+	kindForSeek = kindMerge
+)
+
+const (
+	internalKeyTrailerSize = 8
+	// expirySize is the length of the expiry an expiring value starts with.
+	expirySize = 8
+	// maxSequence is the largest sequence number that fits alongside a kind in an internal key's trailer.
+	maxSequence = 1<<56 - 1
+)
+
+// internalKey is how the memTable and SSTables key their entries: every write to a user key is recorded under its own
+// internal key, stamped with the write's sequence number and kind.
+//
+// | arbitrarily long | 8 bytes, little-endian           |
+// | [user key]       | [sequence number << 8 | kind]    |
+//
+// Internal keys sort by user key ascending, then by sequence number descending, so that the newest write to a key comes
+// first; see internalComparator.
+type internalKey []byte
+
+func makeInternalKey(userKey leveldb.Key, seq uint64, kind keyKind) internalKey {
+	var key = make(internalKey, len(userKey)+internalKeyTrailerSize)
+	copy(key, userKey)
+	encoding.ByteOrder.PutUint64(key[len(userKey):], seq<<8|uint64(kind))
+	return key
+}
+
+// userKey returns the user key the internal key was made from.  A key too short to have a trailer is taken as a user
+// key with nothing after it, so that malformed keys sort somewhere rather than panicking.
+func (key internalKey) userKey() leveldb.Key {
+	if len(key) < internalKeyTrailerSize {
+		return leveldb.Key(key)
+	}
+	return leveldb.Key(key[:len(key)-internalKeyTrailerSize])
+}
+
+func (key internalKey) trailer() uint64 {
+	if len(key) < internalKeyTrailerSize {
+		return 0
+	}
+	return encoding.ByteOrder.Uint64(key[len(key)-internalKeyTrailerSize:])
+}
+
+func (key internalKey) sequence() uint64 {
+	return key.trailer() >> 8
+}
+
+func (key internalKey) kind() keyKind {
+	return keyKind(key.trailer())
+}
+
+// internalComparator orders internal keys by user key, as the user's Comparator orders them, then newest first.
+type internalComparator struct {
+	user leveldb.Comparator
+}
+
+func (c internalComparator) Compare(a, b leveldb.Key) int {
+	if result := c.user.Compare(internalKey(a).userKey(), internalKey(b).userKey()); result != 0 {
+		return result
+	}
+	return cmp.Compare(internalKey(b).trailer(), internalKey(a).trailer())
+}
+
+// lookupKey returns the smallest internal key for userKey visible at seq, which is where a search for the newest such
+// write starts.
+func lookupKey(userKey leveldb.Key, seq uint64) internalKey {
+	return makeInternalKey(userKey, seq, kindForSeek)
+}
+
+// internalRange returns the internal keys bounding every write to a user key in [start, limit].
+func internalRange(start leveldb.Key, limit leveldb.Key) (internalKey, internalKey) {
+	return makeInternalKey(start, maxSequence, kindForSeek), makeInternalKey(limit, 0, kindDelete)
+}
+
+// makeExpiringValue returns value as an entry of kind kindValueWithExpiry holds it, after the time it expires, in unix
+// nanoseconds:
+//
+// | 8 bytes, little-endian | arbitrarily long |
+// | [expiry]               | [value]          |
+func makeExpiringValue(value leveldb.Value, expiresAt int64) leveldb.Value {
+	var expiring = make(leveldb.Value, expirySize+len(value))
+	encoding.ByteOrder.PutUint64(expiring, uint64(expiresAt))
+	copy(expiring[expirySize:], value)
+	return expiring
+}
+
+// liveValue returns the value an entry holds for readers at now, in unix nanoseconds, and whether it holds one at all:
+// deletes do not, nor do values expired by then, nor merge operands, which only hold part of one.  A value too short
+// to hold its expiry is taken as expired.
+func liveValue(key internalKey, value leveldb.Value, now int64) (leveldb.Value, bool) {
+	switch key.kind() {
+	case kindValue:
+		return value, true
+	case kindValueWithExpiry:
+		if len(value) < expirySize || int64(encoding.ByteOrder.Uint64(value)) <= now {
+			return nil, false
+		}
+		return value[expirySize:], true
+	default:
+		return nil, false
+	}
+}
+
+// filterKey has SSTable bloom filters hold user keys, so that they can rule out a table for a lookup at any sequence
+// number.
+func filterKey(key leveldb.Key) leveldb.Key {
+	return internalKey(key).userKey()
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"leveldb"
+	"slices"
+)
+
+// leveledStrategy is LevelDB's compaction strategy.  Each level below 0 holds a single sorted run split across tables,
+// and is allowed ten times the size of the level above it.  Data is rewritten once per level it moves down, which keeps
+// the number of tables a read has to search small at the cost of writing each entry many times.
+type leveledStrategy struct {
+	options *Options
+	// compactPointers holds, for each level, the largest key of the last compaction out of it.  The next compaction
+	// starts after it.
+	compactPointers [numLevels]leveldb.Key
+}
+
+// pickCompaction scores each level against its limit, the number of tables for level 0 and the total size for deeper
+// levels, and returns a compaction for the level most over its limit into the level below.
+func (s *leveledStrategy) pickCompaction(v *version) *compaction {
+	var (
+		bestLevel int
+		bestScore float64
+	)
+	for level := 0; level < numLevels-1; level++ { // the last level has nowhere to compact to
+		var score float64
+		if level == 0 {
+			score = float64(len(v.levels[0])) / float64(s.options.L0CompactionTrigger)
+		} else {
+			score = float64(v.levelSize(level)) / float64(s.options.maxBytesForLevel(level))
+		}
+		if score > bestScore {
+			bestLevel, bestScore = level, score
+		}
+	}
+	if bestScore < 1 {
+		return nil
+	}
+
+	var c = &compaction{
+		level:             bestLevel,
+		outputLevel:       bestLevel + 1,
+		maxOutputFileSize: s.options.MaxFileSize,
+	}
+	if bestLevel == 0 {
+		// level 0 tables overlap, so leaving any behind could leave a newer entry for a key above an older one
+		c.inputs[0] = slices.Clone(v.levels[0])
+	} else {
+		// take turns through the key space, so that every table is eventually compacted
+		var files = v.levels[bestLevel]
+		c.inputs[0] = files[:1]
+		for j, meta := range files {
+			if v.comparator.Compare(meta.largest, s.compactPointers[bestLevel]) > 0 {
+				c.inputs[0] = files[j : j+1]
+				break
+			}
+		}
+	}
+	smallest, largest := keyRangeOf(v.comparator, c.inputs[0])
+	c.inputs[1] = v.overlapping(c.outputLevel, smallest, largest)
+	_, s.compactPointers[bestLevel] = keyRangeOf(v.comparator, c.inputs[0])
+
+	// only levels below the output hold data older than the inputs
+	smallest, largest = keyRangeOf(v.comparator, c.inputs[:]...)
+	for level := c.outputLevel + 1; level < numLevels; level++ {
+		c.olderTables = append(c.olderTables, v.overlapping(level, smallest, largest)...)
+	}
+	return c
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"leveldb/encoding"
+	"os"
+)
+
+/**
+ * The manifest is an append-only log of version edits.  Each record is framed as
+ * | 4 bytes                             | 8 bytes          | arbitrarily long |
+ * | [crc32c of length and payload]      | [payload length] | [payload]        |
+ */
+const manifestHeaderSize = 4 + 8
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errManifestCorrupted = errors.New("manifest corrupted")
+
+type manifestWriter struct {
+	f      *os.File
+	number uint64
+	// err is the first error writing or syncing.  A record may have been torn, and one appended after it would be read
+	// back as corruption rather than as the torn tail of the manifest, so every later append fails until the database
+	// writes a new manifest.
+	err error
+}
+
+func createManifest(dir string, number uint64) (*manifestWriter, error) {
+	f, err := os.OpenFile(manifestFileName(dir, number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestWriter{f: f, number: number}, nil
+}
+
+// append durably records the edit.  Once append returns without error the edit survives a crash.
+func (m *manifestWriter) append(edit *versionEdit) error {
+	if m.err != nil {
+		return m.err
+	}
+	payload, err := edit.Encode()
+	if err != nil {
+		return err
+	}
+	var record = make([]byte, manifestHeaderSize, manifestHeaderSize+len(payload))
+	encoding.ByteOrder.PutUint64(record[4:manifestHeaderSize], uint64(len(payload)))
+	record = append(record, payload...)
+	encoding.ByteOrder.PutUint32(record[:4], crc32.Checksum(record[4:], crc32cTable))
+
+	if _, err := m.f.Write(record); err != nil {
+		m.err = fmt.Errorf("db.manifestWriter.append: error writing: %v", err)
+		return m.err
+	}
+	if err := m.f.Sync(); err != nil {
+		m.err = fmt.Errorf("db.manifestWriter.append: error syncing: %v", err)
+		return m.err
+	}
+	return nil
+}
+
+func (m *manifestWriter) Close() error {
+	return m.f.Close()
+}
+
+// readManifest decodes every edit in the named manifest.  A record cut short by the end of the file is the remains of
+// an append interrupted by a crash; it was never acknowledged, so it is dropped.  A damaged record anywhere else is
+// reported as corruption.
+func readManifest(name string) ([]*versionEdit, error) {
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var edits []*versionEdit
+	for len(contents) >= manifestHeaderSize {
+		var payloadLen = encoding.ByteOrder.Uint64(contents[4:manifestHeaderSize])
+		if payloadLen > uint64(len(contents)-manifestHeaderSize) {
+			break // torn write
+		}
+		var (
+			recordLen = manifestHeaderSize + int(payloadLen)
+			checksum  = encoding.ByteOrder.Uint32(contents[:4])
+		)
+		if crc32.Checksum(contents[4:recordLen], crc32cTable) != checksum {
+			if recordLen == len(contents) {
+				break // torn write of the final record
+			}
+			return nil, fmt.Errorf("%s: %w: checksum mismatch", name, errManifestCorrupted)
+		}
+		var edit = new(versionEdit)
+		if err := edit.Decode(contents[manifestHeaderSize:recordLen]); err != nil {
+			return nil, fmt.Errorf("%s: %w: %v", name, errManifestCorrupted, err)
+		}
+		edits = append(edits, edit)
+		contents = contents[recordLen:]
+	}
+	return edits, nil
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"leveldb"
+	"os"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	var (
+		dir   = t.TempDir()
+		edits = []*versionEdit{new(versionEdit), new(versionEdit)}
+	)
+	edits[0].setLogNumber(3)
+	edits[0].setNextFileNumber(5)
+	edits[0].addFile(0, &fileMetadata{number: 4, size: 100, smallest: leveldb.Key("a"), largest: leveldb.Key("m")})
+	var familyEdit = new(versionEdit)
+	familyEdit.setFamily(2)
+	familyEdit.addFile(0, &fileMetadata{number: 7, size: 300, smallest: leveldb.Key("c"), largest: leveldb.Key("d")})
+	edits[0].addFamilyEdit(familyEdit)
+	edits[1].setFamily(2)
+	edits[1].addFamily("family")
+	edits[1].setNextFamily(3)
+	edits[1].setComparator(leveldb.BytewiseComparator.Name())
+	edits[1].setLastSequence(42)
+	edits[1].deleteFile(0, 4)
+	edits[1].addFile(0, &fileMetadata{number: 6, size: 200, smallest: leveldb.Key("b"), largest: leveldb.Key("z")})
+
+	manifest, err := createManifest(dir, 1)
+	if err != nil {
+		t.Fatal("unexpected error creating manifest:", err)
+	}
+	for _, edit := range edits {
+		if err := manifest.append(edit); err != nil {
+			t.Fatal("unexpected error appending to manifest:", err)
+		}
+	}
+	if err := manifest.Close(); err != nil {
+		t.Fatal("unexpected error closing manifest:", err)
+	}
+	var name = manifestFileName(dir, 1)
+	contents, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal("unexpected error reading manifest:", err)
+	}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		decoded, err := readManifest(name)
+		if err != nil {
+			t.Fatal("unexpected error reading manifest:", err)
+		}
+		if len(decoded) != len(edits) {
+			t.Fatalf("expected %d edits, got %d", len(edits), len(decoded))
+		}
+		if !decoded[0].hasLogNumber || decoded[0].logNumber != 3 || decoded[0].nextFileNumber != 5 {
+			t.Errorf("unexpected bookkeeping in first edit: %+v", decoded[0])
+		}
+		if nested := decoded[0].familyEdits; len(nested) != 1 ||
+			nested[0].family != 2 ||
+			len(nested[0].newFiles) != 1 ||
+			nested[0].newFiles[0].meta.number != 7 ||
+			!bytes.Equal(nested[0].newFiles[0].meta.largest, leveldb.Key("d")) {
+			t.Errorf("unexpected column family edits nested in first edit: %+v", nested)
+		}
+		if decoded[0].family != 0 || decoded[1].family != 2 {
+			t.Errorf("unexpected column families in edits: %d and %d", decoded[0].family, decoded[1].family)
+		}
+		if !decoded[1].hasAddedFamily || decoded[1].addedFamily != "family" || decoded[1].droppedFamily {
+			t.Errorf("unexpected column family added in second edit: %+v", decoded[1])
+		}
+		if !decoded[1].hasNextFamily || decoded[1].nextFamily != 3 {
+			t.Errorf("unexpected next column family in second edit: %+v", decoded[1])
+		}
+		if !decoded[1].hasComparator || decoded[1].comparator != leveldb.BytewiseComparator.Name() {
+			t.Errorf("unexpected comparator in second edit: %+v", decoded[1])
+		}
+		if !decoded[1].hasLastSequence || decoded[1].lastSequence != 42 {
+			t.Errorf("unexpected last sequence in second edit: %+v", decoded[1])
+		}
+		if len(decoded[1].deletedFiles) != 1 || decoded[1].deletedFiles[0].number != 4 {
+			t.Errorf("unexpected deleted files in second edit: %+v", decoded[1].deletedFiles)
+		}
+		if added := decoded[1].newFiles; len(added) != 1 ||
+			added[0].meta.number != 6 ||
+			added[0].meta.size != 200 ||
+			!bytes.Equal(added[0].meta.smallest, leveldb.Key("b")) ||
+			!bytes.Equal(added[0].meta.largest, leveldb.Key("z")) {
+			t.Errorf("unexpected new files in second edit: %+v", added)
+		}
+	})
+	t.Run("TornFinalRecord", func(t *testing.T) {
+		for _, truncateBy := range []int{1, 10, len(contents) / 2} {
+			if err := os.WriteFile(name, contents[:len(contents)-truncateBy], 0o644); err != nil {
+				t.Fatal("unexpected error truncating manifest:", err)
+			}
+			decoded, err := readManifest(name)
+			if err != nil {
+				t.Fatalf("unexpected error reading manifest truncated by %d bytes: %v", truncateBy, err)
+			}
+			if len(decoded) != 1 {
+				t.Errorf("expected only the intact edit from manifest truncated by %d bytes, got %d", truncateBy, len(decoded))
+			}
+		}
+	})
+	t.Run("CorruptedRecord", func(t *testing.T) {
+		var corrupted = bytes.Clone(contents)
+		corrupted[manifestHeaderSize] ^= 0xff // first byte of the first payload
+		if err := os.WriteFile(name, corrupted, 0o644); err != nil {
+			t.Fatal("unexpected error corrupting manifest:", err)
+		}
+		if _, err := readManifest(name); !errors.Is(err, errManifestCorrupted) {
+			t.Errorf("expected errManifestCorrupted, got %v", err)
+		}
+	})
+}
+
+func TestManifest_FailedAppendIsSticky(t *testing.T) {
+	var dir = t.TempDir()
+	manifest, err := createManifest(dir, 1)
+	if err != nil {
+		t.Fatal("unexpected error creating manifest:", err)
+	}
+	var edit = new(versionEdit)
+	edit.setLogNumber(3)
+	if err := manifest.append(edit); err != nil {
+		t.Fatal("unexpected error appending to manifest:", err)
+	}
+	// the file is closed underneath the writer, so the next write fails
+	if err := manifest.f.Close(); err != nil {
+		t.Fatal("unexpected error closing manifest:", err)
+	}
+	if err := manifest.append(edit); err == nil {
+		t.Fatal("expected error appending to a closed manifest, did not get one")
+	}
+	manifest.f, err = os.OpenFile(manifestFileName(dir, 1), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal("unexpected error reopening manifest:", err)
+	}
+	defer func() { _ = manifest.Close() }()
+	if err := manifest.append(edit); err == nil {
+		t.Error("expected appends after a failed one to keep failing, did not get an error")
+	}
+	decoded, err := readManifest(manifestFileName(dir, 1))
+	if err != nil || len(decoded) != 1 {
+		t.Errorf("expected only the edit appended before the failure, got %d (err %v)", len(decoded), err)
+	}
+}
@@ -0,0 +1,157 @@
+package db
+
+import (
+	"leveldb"
+	"leveldb/skiplist"
+	"sync"
+)
+
+// memTable holds the writes not yet flushed to an SSTable, keyed by internal key; see internalKey.  Internal keys are
+// never overwritten, so an entry a reader has found stays valid however many inserts follow.  Range tombstones are
+// held apart from the list.
+type memTable struct {
+	// mu lets any number of readers share a list not safe for concurrent use, while keeping out the one writer
+	// inserting.  It is not taken for lists that are.
+	mu       sync.RWMutex
+	list     skiplist.List
+	lockFree bool
+
+	// tombstonesMu guards tombstones, and tombstoneBytes, their share of the memTable's size
+	tombstonesMu   sync.RWMutex
+	tombstones     rangeTombstones
+	tombstoneBytes uint64
+}
+
+// newMemTable returns an empty memTable of the configured kind, ordered by the configured Comparator.
+func newMemTable(opts *Options) *memTable {
+	var compare = opts.internalComparator().Compare
+	if opts.MemTable == LockedMemTable {
+		return &memTable{list: skiplist.NewSkipListWithKeyComparison(compare)}
+	}
+	return &memTable{list: skiplist.NewConcurrentSkipListWithKeyComparison(compare), lockFree: true}
+}
+
+func (m *memTable) rlock() {
+	if !m.lockFree {
+		m.mu.RLock()
+	}
+}
+
+func (m *memTable) runlock() {
+	if !m.lockFree {
+		m.mu.RUnlock()
+	}
+}
+
+// insert adds an entry.  Only one goroutine may insert at a time.
+func (m *memTable) insert(key internalKey, value leveldb.Value) error {
+	if !m.lockFree {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+	}
+	return m.list.Insert(leveldb.Key(key), value)
+}
+
+// deleteRange adds a range tombstone.  Only one goroutine may add to the memTable at a time.
+func (m *memTable) deleteRange(tombstone rangeTombstone) {
+	m.tombstonesMu.Lock()
+	defer m.tombstonesMu.Unlock()
+	m.tombstones = append(m.tombstones, tombstone)
+	m.tombstoneBytes += uint64(len(tombstone.start) + len(tombstone.limit))
+}
+
+// rangeTombstones returns the range tombstones added so far.  The caller must not modify them.
+func (m *memTable) rangeTombstones() rangeTombstones {
+	m.tombstonesMu.RLock()
+	defer m.tombstonesMu.RUnlock()
+	// tombstones are only ever appended, so the slice taken now is not changed by those added later
+	return m.tombstones[:len(m.tombstones):len(m.tombstones)]
+}
+
+// find returns the first entry at or after key, if there is one.
+func (m *memTable) find(key internalKey) (internalKey, leveldb.Value, bool, error) {
+	m.rlock()
+	defer m.runlock()
+	precedingNode, err := m.list.TraverseUntil(leveldb.Key(key), nil)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	var node = precedingNode.Next()
+	if node == skiplist.NilNode {
+		return nil, nil, false, nil
+	}
+	return internalKey(node.Key()), node.Value(), true, nil
+}
+
+// scan iterates over the entries in [start, limit], including those inserted while it runs.
+func (m *memTable) scan(start internalKey, limit internalKey) (leveldb.Iterator, error) {
+	m.rlock()
+	defer m.runlock()
+	source, err := skiplist.NewIterator(m.list, leveldb.Key(start), leveldb.Key(limit))
+	if err != nil {
+		return nil, err
+	}
+	return &memTableIterator{memTable: m, source: source}, nil
+}
+
+func (m *memTable) size() uint64 {
+	m.rlock()
+	var size = m.list.Size()
+	m.runlock()
+	m.tombstonesMu.RLock()
+	defer m.tombstonesMu.RUnlock()
+	return size + m.tombstoneBytes
+}
+
+// memTableIterator holds the memTable's read lock, if it has to, while following forward pointers, which inserts
+// update.
+type memTableIterator struct {
+	memTable *memTable
+	source   leveldb.Iterator
+}
+
+func (i *memTableIterator) Next() bool {
+	i.memTable.rlock()
+	defer i.memTable.runlock()
+	return i.source.Next()
+}
+
+func (i *memTableIterator) Prev() bool {
+	i.memTable.rlock()
+	defer i.memTable.runlock()
+	return i.source.Prev()
+}
+
+func (i *memTableIterator) Seek(key leveldb.Key) bool {
+	i.memTable.rlock()
+	defer i.memTable.runlock()
+	return i.source.Seek(key)
+}
+
+func (i *memTableIterator) SeekToFirst() bool {
+	i.memTable.rlock()
+	defer i.memTable.runlock()
+	return i.source.SeekToFirst()
+}
+
+func (i *memTableIterator) SeekToLast() bool {
+	i.memTable.rlock()
+	defer i.memTable.runlock()
+	return i.source.SeekToLast()
+}
+
+func (i *memTableIterator) Error() error {
+	return i.source.Error()
+}
+
+func (i *memTableIterator) Key() leveldb.Key {
+	return i.source.Key()
+}
+
+func (i *memTableIterator) Value() leveldb.Value {
+	return i.source.Value()
+}
+
+func (i *memTableIterator) Close() error {
+	return i.source.Close()
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"leveldb"
+	"slices"
+)
+
+// errMergeOperand is returned by resolve for a lookup that finds a merge operand, whose value takes the key's older
+// entries to work out.
+var errMergeOperand = errors.New("found merge operand")
+
+// mergeOperands has operator apply a key's operands, newest first as they are found, to existing, the value they were
+// written over, or nil if there is none.
+func mergeOperands(
+	operator leveldb.MergeOperator,
+	key leveldb.Key,
+	existing leveldb.Value,
+	operands []leveldb.Value,
+) (leveldb.Value, error) {
+	if operator == nil {
+		return nil, fmt.Errorf("no MergeOperator to merge the operands of %q", key)
+	}
+	var oldestFirst = slices.Clone(operands)
+	slices.Reverse(oldestFirst)
+	value, err := operator.Merge(key, existing, oldestFirst)
+	if err != nil {
+		return nil, fmt.Errorf("error merging the operands of %q: %v", key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,173 @@
+package db
+
+import (
+	"errors"
+	"leveldb"
+)
+
+// mergingIterator performs a k-way merge over several sorted sources.  Sources are ordered newest to oldest: when more
+// than one of them holds the same key, the first one wins and the others are skipped past.
+//
+// Moving forward, the iterator yields the smallest key its sources are at, and moving backward the largest.  Either
+// way every source is kept on the near side of the key last yielded, so turning around means repositioning them all.
+type mergingIterator struct {
+	sources []leveldb.Iterator
+	// valid tracks which sources are at an entry.  The others have run past their last entry if the last move was
+	// forward, or before their first if it was backward.
+	valid    []bool
+	forward  bool
+	position iteratorPosition
+	key      leveldb.Key
+	value    leveldb.Value
+	err      error
+	compare  func(a, b leveldb.Key) int
+}
+
+// iteratorPosition is where an iterator stands relative to its entries.
+type iteratorPosition int
+
+const (
+	beforeFirst iteratorPosition = iota
+	atEntry
+	afterLast
+)
+
+func (m *mergingIterator) Next() bool {
+	switch {
+	case m.err != nil || m.position == afterLast:
+		return false
+	case m.position == beforeFirst:
+		return m.SeekToFirst()
+	}
+	for j, source := range m.sources {
+		switch {
+		case !m.forward:
+			// the source is before the key; move it to its first entry after the key
+			if m.move(j, source.Seek(m.key)) && m.compare(source.Key(), m.key) == 0 {
+				m.move(j, source.Next())
+			}
+		case m.valid[j] && m.compare(source.Key(), m.key) == 0:
+			// skip past the yielded key in every source holding it, shadowed (older) entries included
+			m.move(j, source.Next())
+		}
+	}
+	return m.pickForward()
+}
+
+func (m *mergingIterator) Prev() bool {
+	switch {
+	case m.err != nil || m.position == beforeFirst:
+		return false
+	case m.position == afterLast:
+		return m.SeekToLast()
+	}
+	for j, source := range m.sources {
+		switch {
+		case m.forward:
+			// the source is at or after the key; move it to its last entry before the key
+			source.Seek(m.key)
+			m.move(j, source.Prev())
+		case m.valid[j] && m.compare(source.Key(), m.key) == 0:
+			m.move(j, source.Prev())
+		}
+	}
+	return m.pickBackward()
+}
+
+func (m *mergingIterator) Seek(key leveldb.Key) bool {
+	for j, source := range m.sources {
+		m.move(j, source.Seek(key))
+	}
+	return m.pickForward()
+}
+
+func (m *mergingIterator) SeekToFirst() bool {
+	for j, source := range m.sources {
+		m.move(j, source.SeekToFirst())
+	}
+	return m.pickForward()
+}
+
+func (m *mergingIterator) SeekToLast() bool {
+	for j, source := range m.sources {
+		m.move(j, source.SeekToLast())
+	}
+	return m.pickBackward()
+}
+
+// pickForward yields the smallest key the sources are at, from the newest source holding it.
+func (m *mergingIterator) pickForward() bool {
+	m.forward = true
+	var winner = -1
+	for j, source := range m.sources {
+		// strict comparison so that the newest source holding the smallest key wins ties
+		if m.valid[j] && (winner < 0 || m.compare(source.Key(), m.sources[winner].Key()) < 0) {
+			winner = j
+		}
+	}
+	if m.err != nil || winner < 0 {
+		m.key, m.value, m.position = nil, nil, afterLast
+		return false
+	}
+	m.key, m.value, m.position = m.sources[winner].Key(), m.sources[winner].Value(), atEntry
+	return true
+}
+
+// pickBackward yields the largest key the sources are at, from the newest source holding it.
+func (m *mergingIterator) pickBackward() bool {
+	m.forward = false
+	var winner = -1
+	for j, source := range m.sources {
+		// strict comparison so that the newest source holding the largest key wins ties
+		if m.valid[j] && (winner < 0 || m.compare(source.Key(), m.sources[winner].Key()) > 0) {
+			winner = j
+		}
+	}
+	if m.err != nil || winner < 0 {
+		m.key, m.value, m.position = nil, nil, beforeFirst
+		return false
+	}
+	m.key, m.value, m.position = m.sources[winner].Key(), m.sources[winner].Value(), atEntry
+	return true
+}
+
+// newInternalMergingIterator merges sources keyed by internal key, ordered by comparator.  Every write has its own
+// internal key, so nothing is shadowed at this stage and every entry, deletes included, is yielded; see
+// snapshotIterator for picking out the entries visible to a reader.
+func newInternalMergingIterator(comparator internalComparator, sources ...leveldb.Iterator) leveldb.Iterator {
+	return &mergingIterator{
+		sources: sources,
+		valid:   make([]bool, len(sources)),
+		compare: comparator.Compare,
+	}
+}
+
+// move records whether a source is at an entry after moving it, and any error it reports, returning the former.
+func (m *mergingIterator) move(j int, ok bool) bool {
+	m.valid[j] = ok
+	if err := m.sources[j].Error(); !ok && err != nil {
+		m.err = err
+	}
+	return ok
+}
+
+func (m *mergingIterator) Error() error {
+	return m.err
+}
+
+func (m *mergingIterator) Key() leveldb.Key {
+	return m.key
+}
+
+func (m *mergingIterator) Value() leveldb.Value {
+	return m.value
+}
+
+// Close closes every source.
+func (m *mergingIterator) Close() error {
+	var errs []error
+	for _, source := range m.sources {
+		errs = append(errs, source.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"fmt"
+	"leveldb"
+	"testing"
+)
+
+// internalEntry returns an entry keyed by the internal key for a write of the given kind to key.
+func internalEntry(key string, seq uint64, kind keyKind, value string) leveldb.DataEntry {
+	return leveldb.DataEntry{Key: leveldb.Key(makeInternalKey(leveldb.Key(key), seq, kind)), Value: leveldb.Value(value)}
+}
+
+func TestMergingIterator(t *testing.T) {
+	var (
+		comparator = internalComparator{user: leveldb.BytewiseComparator}
+		source     = func(entries ...leveldb.DataEntry) leveldb.Iterator {
+			return newInMemoryIterator(entries, comparator.Compare)
+		}
+		newest = source(
+			internalEntry("bravo", 9, kindValue, "new"),
+			internalEntry("delta", 8, kindDelete, ""),
+		)
+		middle = source(
+			internalEntry("alpha", 6, kindValue, "middle"),
+			internalEntry("charlie", 5, kindDelete, ""),
+			internalEntry("echo", 4, kindValue, "middle"),
+		)
+		oldest = source(
+			internalEntry("alpha", 1, kindValue, "old"),
+			internalEntry("bravo", 1, kindValue, "old"),
+			internalEntry("charlie", 1, kindValue, "old"),
+			internalEntry("delta", 1, kindValue, "old"),
+			internalEntry("foxtrot", 1, kindValue, "old"),
+		)
+		// every write is yielded, deletes included, newest first for each key
+		expected = []string{
+			"alpha@6=middle",
+			"alpha@1=old",
+			"bravo@9=new",
+			"bravo@1=old",
+			"charlie@5=",
+			"charlie@1=old",
+			"delta@8=",
+			"delta@1=old",
+			"echo@4=middle",
+			"foxtrot@1=old",
+		}
+	)
+
+	results := newInternalMergingIterator(comparator, newest, middle, oldest)
+	var current = func() string {
+		var key = internalKey(results.Key())
+		return fmt.Sprintf("%s@%d=%s", key.userKey(), key.sequence(), results.Value())
+	}
+	for j, want := range expected {
+		if !results.Next() {
+			t.Fatalf("expected more results, got %d", j)
+		}
+		if got := current(); got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+	if results.Next() {
+		t.Errorf("got more results than expected: %s", current())
+	}
+	if err := results.Error(); err != nil {
+		t.Error("iterator generated unexpected error", err)
+	}
+
+	t.Run("Backward", func(t *testing.T) {
+		for j := len(expected) - 1; j >= 0; j-- {
+			if !results.Prev() {
+				t.Fatalf("expected more results, got %d", len(expected)-1-j)
+			}
+			if got := current(); got != expected[j] {
+				t.Errorf("expected %s, got %s", expected[j], got)
+			}
+		}
+		if results.Prev() {
+			t.Errorf("got more results than expected: %s", current())
+		}
+	})
+	t.Run("TurningAround", func(t *testing.T) {
+		// each move turns around from the last, so every source is repositioned each time
+		for _, move := range []struct {
+			name     string
+			move     func() bool
+			expected string
+		}{
+			{
+				name:     "Seek",
+				move:     func() bool { return results.Seek(leveldb.Key(lookupKey(leveldb.Key("charlie"), 3))) },
+				expected: "charlie@1=old",
+			},
+			{name: "Prev", move: results.Prev, expected: "charlie@5="},
+			{name: "Next", move: results.Next, expected: "charlie@1=old"},
+			{name: "Prev", move: results.Prev, expected: "charlie@5="},
+			{name: "Prev", move: results.Prev, expected: "bravo@1=old"},
+			{name: "Next", move: results.Next, expected: "charlie@5="},
+			{name: "SeekToLast", move: results.SeekToLast, expected: "foxtrot@1=old"},
+			{name: "Prev", move: results.Prev, expected: "echo@4=middle"},
+		} {
+			if !move.move() || current() != move.expected {
+				t.Errorf("expected %s() to find %s, got %s", move.name, move.expected, current())
+			}
+		}
+	})
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"leveldb"
+	"leveldb/sst"
+	"leveldb/wal"
+	"time"
+)
+
+// Defaults follow LevelDB's.
+const (
+	defaultWriteBufferSize      = 4 << 20
+	defaultMaxFileSize          = 2 << 20
+	defaultL0CompactionTrigger  = 4
+	defaultMaxBytesForLevelBase = 10 << 20
+	levelSizeMultiplier         = 10
+	defaultBloomBitsPerKey      = 10
+	defaultBlockCacheSize       = 8 << 20
+	defaultMaxOpenFiles         = 1000
+	// numNonTableFiles is the number of files MaxOpenFiles sets aside for the WAL segment, the manifest and the like
+	numNonTableFiles = 10
+
+	defaultSizeTieredMinThreshold = 4
+	defaultWALSyncInterval        = 100 * time.Millisecond
+)
+
+// CompactionStyle selects the strategy used to compact SSTables.
+type CompactionStyle int
+
+const (
+	// LeveledCompaction keeps each level below 0 as a single sorted run, ten times larger than the level above it.
+	// Reads search few tables, at the cost of rewriting data once per level.
+	LeveledCompaction CompactionStyle = iota
+	// SizeTieredCompaction keeps every table in level 0 and merges tables of similar size once there are enough of
+	// them.  Data is rewritten less often, at the cost of reads searching more tables.
+	SizeTieredCompaction
+)
+
+// MemTableKind selects the skiplist a memTable holds its entries in.
+type MemTableKind int
+
+const (
+	// ConcurrentMemTable uses a skiplist.ConcurrentSkipList, which readers traverse without locks while a writer inserts
+	// into it.
+	ConcurrentMemTable MemTableKind = iota
+	// LockedMemTable guards a skiplist.SkipList with a read-write lock, so that readers wait out each insert and the
+	// writer waits for readers to let go of it.
+	LockedMemTable
+)
+
+// Options configures a database opened with Open.  The zero value is usable; unset fields take their defaults.
+type Options struct {
+	// WriteBufferSize is the number of key and value bytes the memTable (deletes included) may hold before it is
+	// frozen and flushed to an SSTable.
+	WriteBufferSize int
+
+	// MaxFileSize is the size at which compaction stops writing to one SSTable and starts another.
+	MaxFileSize int
+
+	// L0CompactionTrigger is the number of level-0 tables that triggers a compaction into level 1.
+	L0CompactionTrigger int
+
+	// MaxBytesForLevelBase is the size level 1 may reach before it is compacted into level 2.  Each deeper level may
+	// hold ten times as much as the one above it.
+	MaxBytesForLevelBase int
+
+	// BloomBitsPerKey is the number of bits per key in each SSTable's bloom filter, which lets lookups skip tables
+	// that do not hold their key without reading them.  Ten bits give about 1% false positives.  A negative value
+	// writes tables without filters.
+	BloomBitsPerKey int
+
+	// Compression selects the codec for SSTable data blocks.  It defaults to sst.LZCompression, which is cheap enough
+	// to leave on; blocks that compression does not shrink are stored as they are.
+	Compression sst.Compression
+
+	// StrictRecovery fails recovery if a WAL record other than the last of its segment is damaged.  Otherwise the
+	// segment is replayed up to the damaged record, and the rest of it dropped.  Either way a damaged final record is
+	// taken for the remains of an interrupted write, and dropped; Stats reports how many bytes were.
+	StrictRecovery bool
+
+	// WALSyncPolicy selects when WAL segments are synced to stable storage.  It defaults to wal.SyncNever, leaving it to
+	// the operating system; a write can still ask to be synced with leveldb.WithSync.
+	WALSyncPolicy wal.SyncPolicy
+
+	// WALSyncInterval is how often wal.SyncPeriodically syncs.
+	WALSyncInterval time.Duration
+
+	// CompactionStyle selects the compaction strategy.  It defaults to LeveledCompaction.
+	CompactionStyle CompactionStyle
+
+	// SizeTieredMinThreshold is the number of similarly sized tables that triggers a size-tiered compaction.
+	SizeTieredMinThreshold int
+
+	// SizeTieredMinTableSize is the size below which size-tiered compaction treats every table as similarly sized, so
+	// that small flushes are merged together promptly.  It defaults to twice WriteBufferSize.
+	SizeTieredMinTableSize int
+
+	// MemTable selects the skiplist the memTable holds its entries in.  It defaults to ConcurrentMemTable.
+	MemTable MemTableKind
+
+	// BlockCacheSize is the number of bytes of SSTable data blocks kept in memory once read, shared by every table.  A
+	// negative value disables the cache.
+	BlockCacheSize int
+
+	// MaxOpenFiles is the number of files the database may keep open.  All but a few are left to the table cache,
+	// which closes the least recently read SSTables to stay within it, and opens them again when next read.  A table
+	// an iterator is reading cannot be closed under it, so it stays open past the limit until the iterator is closed;
+	// iterators left open keep the database from staying within it.
+	MaxOpenFiles int
+
+	// Comparator orders keys.  It defaults to leveldb.BytewiseComparator.  Its name is recorded in the manifest, and a
+	// database cannot be reopened with a comparator of another name.
+	Comparator leveldb.Comparator
+
+	// MergeOperator combines the operands written with Merge into values.  A database without one rejects merges, and
+	// fails to read or compact keys holding operands written by one that had it.
+	MergeOperator leveldb.MergeOperator
+
+	// ColumnFamilies configures the column families created by CreateColumnFamily, by name, when the database is
+	// reopened.  Families left out take the default options, so one ordered by another Comparator must be listed.
+	ColumnFamilies map[string]*Options
+
+	// now tells the time values written with PutWithTTL expire by.  Tests set it to control expiry.
+	now func() time.Time
+}
+
+// withDefaults returns a copy of opts with unset fields filled in.  opts may be nil.
+func (opts *Options) withDefaults() *Options {
+	var withDefaults Options
+	if opts != nil {
+		withDefaults = *opts
+	}
+	if withDefaults.WriteBufferSize <= 0 {
+		withDefaults.WriteBufferSize = defaultWriteBufferSize
+	}
+	if withDefaults.MaxFileSize <= 0 {
+		withDefaults.MaxFileSize = defaultMaxFileSize
+	}
+	if withDefaults.L0CompactionTrigger <= 0 {
+		withDefaults.L0CompactionTrigger = defaultL0CompactionTrigger
+	}
+	if withDefaults.MaxBytesForLevelBase <= 0 {
+		withDefaults.MaxBytesForLevelBase = defaultMaxBytesForLevelBase
+	}
+	if withDefaults.BlockCacheSize == 0 {
+		withDefaults.BlockCacheSize = defaultBlockCacheSize
+	}
+	if withDefaults.MaxOpenFiles <= 0 {
+		withDefaults.MaxOpenFiles = defaultMaxOpenFiles
+	}
+	if withDefaults.Comparator == nil {
+		withDefaults.Comparator = leveldb.BytewiseComparator
+	}
+	if withDefaults.now == nil {
+		withDefaults.now = time.Now
+	}
+	if withDefaults.BloomBitsPerKey == 0 {
+		withDefaults.BloomBitsPerKey = defaultBloomBitsPerKey
+	}
+	if withDefaults.WALSyncInterval <= 0 {
+		withDefaults.WALSyncInterval = defaultWALSyncInterval
+	}
+	if withDefaults.SizeTieredMinThreshold <= 1 {
+		withDefaults.SizeTieredMinThreshold = defaultSizeTieredMinThreshold
+	}
+	if withDefaults.SizeTieredMinTableSize <= 0 {
+		withDefaults.SizeTieredMinTableSize = 2 * withDefaults.WriteBufferSize
+	}
+	return &withDefaults
+}
+
+// newBlockCache returns a block cache of the configured size, or nil if it is disabled.
+func (opts *Options) newBlockCache() *sst.BlockCache {
+	if opts.BlockCacheSize < 0 {
+		return nil
+	}
+	return sst.NewBlockCache(int64(opts.BlockCacheSize))
+}
+
+// internalComparator returns the ordering of internal keys, by the configured Comparator.
+func (opts *Options) internalComparator() internalComparator {
+	return internalComparator{user: opts.Comparator}
+}
+
+// tableCacheSize returns the number of SSTables the table cache keeps open, which is always at least one.
+func (opts *Options) tableCacheSize() int {
+	return max(opts.MaxOpenFiles-numNonTableFiles, 1)
+}
+
+// walOptions returns the options for new WAL segments.
+func (opts *Options) walOptions() []wal.LogOption {
+	return []wal.LogOption{wal.WithSyncPolicy(opts.WALSyncPolicy, opts.WALSyncInterval)}
+}
+
+// maxBytesForLevel returns the size at which a level (1 or deeper) is due for compaction.
+func (opts *Options) maxBytesForLevel(level int) uint64 {
+	var maxBytes = uint64(opts.MaxBytesForLevelBase)
+	for ; level > 1; level-- {
+		maxBytes *= levelSizeMultiplier
+	}
+	return maxBytes
+}
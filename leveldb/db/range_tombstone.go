@@ -0,0 +1,79 @@
+package db
+
+import (
+	"leveldb"
+	"slices"
+)
+
+// rangeTombstone deletes every write to a user key in [start, limit] made before it, that is with a smaller sequence
+// number.  It is keyed by start, as an internal key of kind kindRangeDelete, and holds limit as its value.  Range
+// tombstones are kept apart from point entries: alongside the skiplist in the memTable, and in a block of their own
+// in SSTables, so that a lookup finds those covering its key without searching back for where they start.
+type rangeTombstone struct {
+	start leveldb.Key
+	limit leveldb.Key
+	seq   uint64
+}
+
+// key returns the internal key the tombstone is recorded under.
+func (t rangeTombstone) key() internalKey {
+	return makeInternalKey(t.start, t.seq, kindRangeDelete)
+}
+
+// decodeRangeTombstone returns the tombstone recorded as key and value.
+func decodeRangeTombstone(key internalKey, value leveldb.Value) rangeTombstone {
+	return rangeTombstone{start: key.userKey(), limit: leveldb.Key(value), seq: key.sequence()}
+}
+
+func (t rangeTombstone) covers(key leveldb.Key, comparator leveldb.Comparator) bool {
+	return comparator.Compare(t.start, key) <= 0 && comparator.Compare(key, t.limit) <= 0
+}
+
+// rangeTombstones is a set of range tombstones, in no particular order.  Sets are expected to be small, and are
+// searched in full.
+type rangeTombstones []rangeTombstone
+
+// decodeRangeTombstones returns the tombstones an SSTable was written with.
+func decodeRangeTombstones(entries []leveldb.DataEntry) rangeTombstones {
+	var tombstones = make(rangeTombstones, 0, len(entries))
+	for _, entry := range entries {
+		tombstones = append(tombstones, decodeRangeTombstone(internalKey(entry.Key), entry.Value))
+	}
+	return tombstones
+}
+
+// deletedBefore returns the sequence number of the newest tombstone covering key that a read at seq sees, or 0 if there
+// is none.  Writes to key numbered below it are deleted for the read.
+func (ts rangeTombstones) deletedBefore(key leveldb.Key, seq uint64, comparator leveldb.Comparator) uint64 {
+	var newest uint64
+	for _, t := range ts {
+		if t.seq <= seq && t.seq > newest && t.covers(key, comparator) {
+			newest = t.seq
+		}
+	}
+	return newest
+}
+
+// overlapping returns the tombstones overlapping [start, limit].
+func (ts rangeTombstones) overlapping(
+	start leveldb.Key,
+	limit leveldb.Key,
+	comparator leveldb.Comparator,
+) rangeTombstones {
+	var overlapping rangeTombstones
+	for _, t := range ts {
+		if comparator.Compare(t.start, limit) <= 0 && comparator.Compare(start, t.limit) <= 0 {
+			overlapping = append(overlapping, t)
+		}
+	}
+	return overlapping
+}
+
+// sorted returns the tombstones in the order of their internal keys, which is the order SSTables take them in.
+func (ts rangeTombstones) sorted(comparator internalComparator) rangeTombstones {
+	var sorted = slices.Clone(ts)
+	slices.SortFunc(sorted, func(a, b rangeTombstone) int {
+		return comparator.Compare(leveldb.Key(a.key()), leveldb.Key(b.key()))
+	})
+	return sorted
+}
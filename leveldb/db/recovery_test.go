@@ -0,0 +1,556 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"leveldb"
+	"leveldb/wal"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestOpen_CrashDuringFlush abandons a database part-way through compactMemTable, as if the process had been killed,
+// and checks that reopening the directory recovers every write.
+func TestOpen_CrashDuringFlush(t *testing.T) {
+	steps := []struct {
+		name  string
+		crash func(db *db) error
+	}{
+		{
+			name:  "AfterRotatingLog",
+			crash: func(db *db) error { return db.rotateLog() },
+		},
+		{
+			name: "AfterWritingTable",
+			crash: func(db *db) error {
+				if err := db.rotateLog(); err != nil {
+					return err
+				}
+				_, err := db.writeLevel0Table()
+				return err
+			},
+		},
+		{
+			name: "AfterRecordingInManifest",
+			crash: func(db *db) error {
+				if err := db.rotateLog(); err != nil {
+					return err
+				}
+				meta, err := db.writeLevel0Table()
+				if err != nil {
+					return err
+				}
+				var edit = new(versionEdit)
+				edit.setLogNumber(db.logFileNumber)
+				edit.setNextFileNumber(db.nextFileNumber)
+				edit.setLastSequence(db.lastSequence)
+				edit.addFile(0, meta)
+				return db.manifest.append(edit)
+			},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			var dir = t.TempDir()
+			database, err := Open(dir, nil)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			writeKeys(t, database, "before", 0, 10)
+			if err := database.Delete(leveldb.Key("before003")); err != nil {
+				t.Fatal("unexpected error executing Delete()", err)
+			}
+			if err := step.crash(database.(*db)); err != nil {
+				t.Fatal("unexpected error setting up crash:", err)
+			}
+
+			reopened, err := Open(dir, nil)
+			if err != nil {
+				t.Fatal("unexpected error reopening database:", err)
+			}
+			defer func() { _ = reopened.Close() }()
+			for j := range 10 {
+				var key = leveldb.Key(fmt.Sprintf("before%03d", j))
+				val, err := reopened.Get(key)
+				if j == 3 {
+					if !errors.Is(err, leveldb.ErrKeyNotFound) {
+						t.Errorf("expected %q to be deleted, got %q (err %v)", key, val, err)
+					}
+				} else if err != nil {
+					t.Errorf("unexpected error getting %q: %v", key, err)
+				}
+			}
+			if logs, tables := countFiles(t, dir); logs != 1 || tables != 1 {
+				t.Errorf("expected obsolete files to be removed leaving 1 log and 1 table, found %d and %d", logs, tables)
+			}
+		})
+	}
+}
+
+// TestOpen_CrashDuringColumnFamilyFlush flushes two column families, then puts the directory back as a crash part-way
+// through recording the flush in the manifest would have left it, and checks that reopening it recovers every write to
+// both.  The flush is a single manifest record, so it is replayed for both families or for neither.
+func TestOpen_CrashDuringColumnFamilyFlush(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// kept is how much of the flush's record, of recordLen bytes, reached the manifest before the crash
+		kept func(recordLen int64) int64
+	}{
+		{name: "BeforeRecording", kept: func(int64) int64 { return 0 }},
+		{name: "WhileRecording", kept: func(recordLen int64) int64 { return recordLen / 2 }},
+		{name: "AfterRecording", kept: func(recordLen int64) int64 { return recordLen }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var dir = t.TempDir()
+			database, err := Open(dir, nil)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			family, err := database.(ColumnFamilies).CreateColumnFamily("family", nil)
+			if err != nil {
+				t.Fatal("unexpected error creating column family:", err)
+			}
+			writeKeys(t, database, "default", 0, 10)
+			for j := range 10 {
+				var key = leveldb.Key(fmt.Sprintf("family%03d", j))
+				if err := database.Put(key, leveldb.Value("value"), leveldb.ToColumnFamily(family.ID)); err != nil {
+					t.Fatal("unexpected error executing Put()", err)
+				}
+			}
+
+			// the WAL segments are only removed once the flush is recorded, so they outlive a crash before then
+			var impl = database.(*db)
+			var logName = logFileName(dir, impl.logFileNumber)
+			log, err := os.ReadFile(logName)
+			if err != nil {
+				t.Fatal("unexpected error reading WAL segment:", err)
+			}
+			var manifestName = manifestFileName(dir, impl.manifest.number)
+			before, err := readManifest(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest:", err)
+			}
+			sizeBefore, err := os.Stat(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest size:", err)
+			}
+			if err := impl.compactMemTable(); err != nil {
+				t.Fatal("unexpected error flushing memtables:", err)
+			}
+			after, err := readManifest(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest:", err)
+			}
+			if len(after) != len(before)+1 || len(after[len(before)].familyEdits) != 2 {
+				t.Fatalf("expected the flush of both column families to be recorded as one edit, got %d", len(after)-len(before))
+			}
+			sizeAfter, err := os.Stat(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest size:", err)
+			}
+			var kept = sizeBefore.Size() + tc.kept(sizeAfter.Size()-sizeBefore.Size())
+			if err := os.Truncate(manifestName, kept); err != nil {
+				t.Fatal("unexpected error truncating manifest:", err)
+			}
+			if err := os.WriteFile(logName, log, 0o644); err != nil {
+				t.Fatal("unexpected error restoring WAL segment:", err)
+			}
+
+			reopened, err := Open(dir, nil)
+			if err != nil {
+				t.Fatal("unexpected error reopening database:", err)
+			}
+			defer func() { _ = reopened.Close() }()
+			for j := range 10 {
+				var key = leveldb.Key(fmt.Sprintf("default%03d", j))
+				if val, err := reopened.Get(key); err != nil || string(val) != "value of "+string(key) {
+					t.Errorf("expected %q=%q, got %q (err %v)", key, "value of "+string(key), val, err)
+				}
+				key = leveldb.Key(fmt.Sprintf("family%03d", j))
+				if val, err := reopened.Get(key, leveldb.FromColumnFamily(family.ID)); err != nil || string(val) != "value" {
+					t.Errorf("expected %q=%q in the column family, got %q (err %v)", key, "value", val, err)
+				}
+			}
+		})
+	}
+}
+
+// TestOpen_FailedColumnFamilyFlush fails the flush of the second of two column families, and checks that nothing of it
+// is recorded, so that retrying it, or reopening the directory, applies each write once, merge operands included.
+func TestOpen_FailedColumnFamilyFlush(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		retry bool
+	}{
+		{name: "Retried", retry: true},
+		{name: "Reopened"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				dir     = t.TempDir()
+				options = &Options{MergeOperator: appendOperator{}}
+			)
+			database, err := Open(dir, options)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			family, err := database.(ColumnFamilies).CreateColumnFamily("family", nil)
+			if err != nil {
+				t.Fatal("unexpected error creating column family:", err)
+			}
+			if err := database.Put(leveldb.Key("a"), leveldb.Value("x")); err != nil {
+				t.Fatal("unexpected error executing Put()", err)
+			}
+			if err := database.(Merger).Merge(leveldb.Key("a"), leveldb.Value("1")); err != nil {
+				t.Fatal("unexpected error executing Merge()", err)
+			}
+			var toFamily = leveldb.ToColumnFamily(family.ID)
+			if err := database.Put(leveldb.Key("b"), leveldb.Value("value"), toFamily); err != nil {
+				t.Fatal("unexpected error executing Put()", err)
+			}
+			// checkValues checks that each write is seen once
+			var checkValues = func(t *testing.T, database leveldb.DB) {
+				t.Helper()
+				if value, err := database.Get(leveldb.Key("a")); err != nil || string(value) != "x,1" {
+					t.Errorf("expected Get(%q) to find %q, got %q (err %v)", "a", "x,1", value, err)
+				}
+				value, err := database.Get(leveldb.Key("b"), leveldb.FromColumnFamily(family.ID))
+				if err != nil || string(value) != "value" {
+					t.Errorf("expected Get(%q) to find %q, got %q (err %v)", "b", "value", value, err)
+				}
+			}
+
+			// the family's table, numbered after the new WAL segment and the default family's table, cannot be created
+			var impl = database.(*db)
+			var blocked = tableFileName(dir, impl.nextFileNumber+2)
+			if err := os.Mkdir(blocked, 0o755); err != nil {
+				t.Fatal("unexpected error blocking table file:", err)
+			}
+			var manifestName = manifestFileName(dir, impl.manifest.number)
+			before, err := readManifest(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest:", err)
+			}
+			if err := impl.compactMemTable(); err == nil {
+				t.Fatal("expected flushing memtables to fail")
+			}
+			after, err := readManifest(manifestName)
+			if err != nil {
+				t.Fatal("unexpected error reading manifest:", err)
+			}
+			if len(after) != len(before) {
+				t.Errorf("expected nothing of the failed flush to be recorded, got %d edits", len(after)-len(before))
+			}
+			checkValues(t, database)
+			if err := os.Remove(blocked); err != nil {
+				t.Fatal("unexpected error unblocking table file:", err)
+			}
+
+			if tc.retry {
+				if err := impl.compactMemTable(); err != nil {
+					t.Fatal("unexpected error flushing memtables:", err)
+				}
+				checkValues(t, database)
+				if err := database.Close(); err != nil {
+					t.Fatal("unexpected error closing database:", err)
+				}
+			}
+			reopened, err := Open(dir, options)
+			if err != nil {
+				t.Fatal("unexpected error reopening database:", err)
+			}
+			defer func() { _ = reopened.Close() }()
+			checkValues(t, reopened)
+		})
+	}
+}
+
+// TestOpen_IgnoresStaleLog restores a WAL segment whose entries were flushed, as happens when a crash strikes before
+// the segment is removed, and checks that it is not replayed over newer writes.
+func TestOpen_IgnoresStaleLog(t *testing.T) {
+	var dir = t.TempDir()
+	database, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	if err := database.Put(leveldb.Key("artist"), leveldb.Value("Eno")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	var staleLogName = logFileName(dir, database.(*db).logFileNumber)
+	staleLog, err := os.ReadFile(staleLogName)
+	if err != nil {
+		t.Fatal("unexpected error reading WAL segment:", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+
+	database, err = Open(dir, nil) // flushes the put to a table
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	if err := database.Delete(leveldb.Key("artist")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	if err := os.WriteFile(staleLogName, staleLog, 0o644); err != nil {
+		t.Fatal("unexpected error restoring WAL segment:", err)
+	}
+
+	reopened, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	if val, err := reopened.Get(leveldb.Key("artist")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+		t.Errorf("expected deleted key to stay deleted, got %q (err %v)", val, err)
+	}
+	if _, err := os.Stat(staleLogName); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected stale WAL segment to be removed, got %v", err)
+	}
+}
+
+// TestOpen_DamagedLog damages a record in the middle of a WAL segment, and checks that strict recovery refuses to open
+// the database while lenient recovery skips the damaged record and replays the others.
+func TestOpen_DamagedLog(t *testing.T) {
+	var dir = t.TempDir()
+	database, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	writeKeys(t, database, "key", 0, 10)
+	var logName = logFileName(dir, database.(*db).logFileNumber)
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	contents, err := os.ReadFile(logName)
+	if err != nil {
+		t.Fatal("unexpected error reading WAL segment:", err)
+	}
+	var recordSize = len(contents) / 10 // every key and value is the same length
+	contents[5*recordSize+10] ^= 1
+	if err := os.WriteFile(logName, contents, 0o644); err != nil {
+		t.Fatal("unexpected error damaging WAL segment:", err)
+	}
+
+	if _, err := Open(dir, &Options{StrictRecovery: true}); err == nil {
+		t.Fatal("expected error opening database with a damaged WAL in strict mode, did not get one")
+	}
+	reopened, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	for j := range 10 {
+		var key = leveldb.Key(fmt.Sprintf("key%03d", j))
+		val, err := reopened.Get(key)
+		if j != 5 && err != nil {
+			t.Errorf("unexpected error getting %q: %v", key, err)
+		} else if j == 5 && !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected %q to be lost with the damaged record, got %q (err %v)", key, val, err)
+		}
+	}
+	if discarded := reopened.(StatsReporter).Stats().WALBytesDiscarded; discarded != uint64(recordSize) {
+		t.Errorf("expected the %d bytes of the damaged record to be discarded, got %d", recordSize, discarded)
+	}
+}
+
+// TestOpen_TornBatch cuts a batch's WAL record short, as a crash part way through appending it would, and checks that
+// recovery drops every write in the batch rather than some of them.
+func TestOpen_TornBatch(t *testing.T) {
+	var dir = t.TempDir()
+	database, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	writeKeys(t, database, "key", 0, 3)
+	var logName = logFileName(dir, database.(*db).logFileNumber)
+	before, err := os.Stat(logName)
+	if err != nil {
+		t.Fatal("unexpected error reading WAL segment:", err)
+	}
+	var batch leveldb.WriteBatch
+	batch.Delete(leveldb.Key("key000"))
+	batch.Put(leveldb.Key("key001"), leveldb.Value("updated"))
+	batch.Put(leveldb.Key("key003"), leveldb.Value("added"))
+	if err := database.Write(&batch); err != nil {
+		t.Fatal("unexpected error writing batch:", err)
+	}
+	if err := database.Close(); err != nil {
+		t.Fatal("unexpected error closing database:", err)
+	}
+	after, err := os.Stat(logName)
+	if err != nil {
+		t.Fatal("unexpected error reading WAL segment:", err)
+	}
+	if err := os.Truncate(logName, (before.Size()+after.Size())/2); err != nil {
+		t.Fatal("unexpected error tearing WAL segment:", err)
+	}
+
+	reopened, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal("unexpected error reopening database:", err)
+	}
+	defer func() { _ = reopened.Close() }()
+	for j := range 3 {
+		var key = leveldb.Key(fmt.Sprintf("key%03d", j))
+		if val, err := reopened.Get(key); err != nil || string(val) != "value of "+string(key) {
+			t.Errorf("expected %q to be left as it was before the batch, got %q (err %v)", key, val, err)
+		}
+	}
+	if val, err := reopened.Get(leveldb.Key("key003")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+		t.Errorf("expected key added by the torn batch not to be found, got %q (err %v)", val, err)
+	}
+}
+
+func TestOpen_WALSyncPolicies(t *testing.T) {
+	policies := []struct {
+		name   string
+		policy wal.SyncPolicy
+	}{
+		{name: "Never", policy: wal.SyncNever},
+		{name: "EveryWrite", policy: wal.SyncEveryWrite},
+		{name: "Periodically", policy: wal.SyncPeriodically},
+	}
+	for _, policy := range policies {
+		t.Run(policy.name, func(t *testing.T) {
+			var (
+				dir  = t.TempDir()
+				opts = &Options{WALSyncPolicy: policy.policy, WALSyncInterval: time.Millisecond, WriteBufferSize: 256}
+			)
+			database, err := Open(dir, opts)
+			if err != nil {
+				t.Fatal("unexpected error opening database:", err)
+			}
+			writeKeys(t, database, "key", 0, 20) // enough to rotate the log
+			if err := database.Put(leveldb.Key("synced"), leveldb.Value("value"), leveldb.WithSync(true)); err != nil {
+				t.Fatal("unexpected error executing Put()", err)
+			}
+			if err := database.Delete(leveldb.Key("key003"), leveldb.WithSync(true)); err != nil {
+				t.Fatal("unexpected error executing Delete()", err)
+			}
+			if err := database.Close(); err != nil {
+				t.Fatal("unexpected error closing database:", err)
+			}
+
+			reopened, err := Open(dir, opts)
+			if err != nil {
+				t.Fatal("unexpected error reopening database:", err)
+			}
+			defer func() { _ = reopened.Close() }()
+			if val, err := reopened.Get(leveldb.Key("synced")); err != nil || string(val) != "value" {
+				t.Errorf("expected synced write to be recovered, got %q (err %v)", val, err)
+			}
+			if val, err := reopened.Get(leveldb.Key("key003")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+				t.Errorf("expected synced delete to be recovered, got %q (err %v)", val, err)
+			}
+		})
+	}
+}
+
+// failingSyncBuffer is a WAL whose syncs fail.
+type failingSyncBuffer struct {
+	bytes.Buffer
+}
+
+func (*failingSyncBuffer) Sync() error {
+	return errors.New("sync failed")
+}
+
+func TestDb_FailedSyncNotPublished(t *testing.T) {
+	database, err := NewDbFromWalWithOptions(new(failingSyncBuffer), nil)
+	if err != nil {
+		t.Fatal("unexpected error creating database:", err)
+	}
+	if err := database.Put(leveldb.Key("key"), leveldb.Value("value"), leveldb.WithSync(true)); err == nil {
+		t.Fatal("expected Put() to report the failed sync")
+	}
+	if val, err := database.Get(leveldb.Key("key")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+		t.Errorf("expected a write whose sync failed never to be seen, got %q (err %v)", val, err)
+	}
+	if err := database.Put(leveldb.Key("other"), leveldb.Value("value")); err == nil {
+		t.Error("expected writes after a failed sync to fail")
+	}
+}
+
+// stallingSyncBuffer is a WAL that reports each write on written, and whose syncs fail once released.
+type stallingSyncBuffer struct {
+	bytes.Buffer
+	written chan struct{}
+	syncing chan struct{}
+	release chan struct{}
+}
+
+func (b *stallingSyncBuffer) Write(p []byte) (int, error) {
+	n, err := b.Buffer.Write(p)
+	b.written <- struct{}{}
+	return n, err
+}
+
+func (b *stallingSyncBuffer) Sync() error {
+	b.syncing <- struct{}{}
+	<-b.release
+	return errors.New("sync failed")
+}
+
+func TestDb_FailedSyncFailsWritesLoggedDuringIt(t *testing.T) {
+	var wal = &stallingSyncBuffer{
+		written: make(chan struct{}, 16),
+		syncing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	database, err := NewDbFromWalWithOptions(wal, nil)
+	if err != nil {
+		t.Fatal("unexpected error creating database:", err)
+	}
+	var synced = make(chan error)
+	go func() {
+		synced <- database.Put(leveldb.Key("key"), leveldb.Value("value"), leveldb.WithSync(true))
+	}()
+	<-wal.syncing
+	<-wal.written
+	var unsynced = make(chan error)
+	go func() {
+		unsynced <- database.Put(leveldb.Key("other"), leveldb.Value("value"))
+	}()
+	<-wal.written
+	close(wal.release)
+	if err := <-synced; err == nil {
+		t.Error("expected Put() to report the failed sync")
+	}
+	if err := <-unsynced; err == nil {
+		t.Error("expected a write logged while the sync before it failed to fail too")
+	}
+	for _, key := range []string{"key", "other"} {
+		if val, err := database.Get(leveldb.Key(key)); !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected %s never to be seen, got %q (err %v)", key, val, err)
+		}
+	}
+}
+
+func TestOpen_RejectsFilesWithoutCurrent(t *testing.T) {
+	var dir = t.TempDir()
+	if err := os.WriteFile(tableFileName(dir, 7), nil, 0o644); err != nil {
+		t.Fatal("unexpected error creating table file:", err)
+	}
+	if _, err := Open(dir, nil); err == nil {
+		t.Error("expected error opening directory with tables but no CURRENT, did not get one")
+	}
+	if _, err := os.Stat(tableFileName(dir, 7)); err != nil {
+		t.Errorf("expected table file to be left alone, got %v", err)
+	}
+}
+
+func writeKeys(t *testing.T, database leveldb.DB, prefix string, from int, to int) {
+	t.Helper()
+	for j := from; j < to; j++ {
+		var key = fmt.Sprintf("%s%03d", prefix, j)
+		if err := database.Put(leveldb.Key(key), leveldb.Value("value of "+key)); err != nil {
+			t.Fatal("unexpected error executing Put()", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package db
+
+// Bounds on how far a table's size may stray from the average of its bucket, and on how many tables one compaction may
+// merge.  These follow Cassandra's defaults.
+const (
+	sizeTieredBucketLow    = 0.5
+	sizeTieredBucketHigh   = 1.5
+	sizeTieredMaxThreshold = 32
+)
+
+// sizeTieredStrategy keeps every table in level 0 as a sorted run of its own, ordered oldest to newest like any other
+// level 0 table.  Runs of similar size are grouped into buckets, and once a bucket holds SizeTieredMinThreshold runs
+// they are merged into a single larger run, which in time joins a bucket of runs its own size.  Each entry is rewritten
+// about once per tier rather than once per level, at the cost of reads searching every run that may hold their key.
+//
+// A lookup stops at the first run holding a write to its key, searching from the newest run, so runs must stay ordered
+// by the age of their entries.  Buckets are therefore made of adjacent runs, and the merged run takes their place: were
+// it to merge runs around another, that run would come either before or after entries of its own age, and a lookup
+// could take an older write for the newest.
+type sizeTieredStrategy struct {
+	options *Options
+}
+
+// pickCompaction returns a compaction of the bucket holding at least SizeTieredMinThreshold runs with the smallest
+// average size, since merging small runs is cheap and does the most to cut the number of runs.
+func (s *sizeTieredStrategy) pickCompaction(v *version) *compaction {
+	var (
+		runs      = v.levels[0]
+		bestStart = -1
+		bestEnd   int
+		bestAvg   float64
+	)
+	for start := 0; start < len(runs); {
+		var end, total = start + 1, float64(runs[start].size)
+		for end < len(runs) && end-start < sizeTieredMaxThreshold && s.isSimilar(runs[end].size, total/float64(end-start)) {
+			total += float64(runs[end].size)
+			end++
+		}
+		var avg = total / float64(end-start)
+		if end-start >= s.options.SizeTieredMinThreshold && (bestStart < 0 || avg < bestAvg) {
+			bestStart, bestEnd, bestAvg = start, end, avg
+		}
+		start = end
+	}
+	if bestStart < 0 {
+		return nil
+	}
+
+	var c = &compaction{level: 0, outputLevel: 0}
+	c.inputs[0] = runs[bestStart:bestEnd:bestEnd]
+	// only the runs before the bucket hold older data
+	smallest, largest := keyRangeOf(v.comparator, c.inputs[0])
+	for _, meta := range runs[:bestStart] {
+		if meta.overlaps(smallest, largest, v.comparator) {
+			c.olderTables = append(c.olderTables, meta)
+		}
+	}
+	return c
+}
+
+// isSimilar reports whether a run of the given size belongs in a bucket whose runs average avg bytes.
+func (s *sizeTieredStrategy) isSimilar(size uint64, avg float64) bool {
+	var minTableSize = float64(s.options.SizeTieredMinTableSize)
+	if float64(size) < minTableSize && avg < minTableSize {
+		return true
+	}
+	return float64(size) >= avg*sizeTieredBucketLow && float64(size) <= avg*sizeTieredBucketHigh
+}
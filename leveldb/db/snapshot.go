@@ -0,0 +1,314 @@
+package db
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"leveldb"
+	"runtime"
+	"slices"
+)
+
+// snapshot pins the sequence number of the last write it sees.  Live snapshots are kept in db.snapshots, oldest
+// first, so that compaction knows which overwritten and deleted entries must be kept for them.
+type snapshot struct {
+	db  *db
+	seq uint64
+	// element is the snapshot's entry in db.snapshots, or nil once released
+	element *list.Element
+}
+
+func (db *db) GetSnapshot() leveldb.Snapshot {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var s = &snapshot{db: db, seq: db.lastSequence}
+	s.element = db.snapshots.PushBack(s)
+	return s
+}
+
+// Release lets compaction drop the entries only the snapshot could see.  Releasing a snapshot twice does nothing.
+func (s *snapshot) Release() {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	if s.element != nil {
+		s.db.snapshots.Remove(s.element)
+		s.element = nil
+	}
+}
+
+// smallestSnapshot returns the sequence number of the oldest state any reader may still see.
+func (db *db) smallestSnapshot() uint64 {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if oldest := db.snapshots.Front(); oldest != nil {
+		return oldest.Value.(*snapshot).seq
+	}
+	return db.lastSequence
+}
+
+// readSequence returns the sequence number of the last write a read with the given options sees.  The caller holds
+// db.mu.
+func (db *db) readSequence(opts []leveldb.ReadOption) (uint64, error) {
+	var readOptions = leveldb.NewReadOptions(opts...)
+	if readOptions.Snapshot == nil {
+		return db.lastSequence, nil
+	}
+	s, ok := readOptions.Snapshot.(*snapshot)
+	if !ok || s.db != db {
+		return 0, errors.New("snapshot does not belong to this database")
+	}
+	if s.element == nil {
+		return 0, errors.New("snapshot has been released")
+	}
+	return s.seq, nil
+}
+
+// snapshotIterator turns entries keyed by internal key, in order, into the user keys and values visible at seq.  For
+// each user key the newest entry no newer than seq decides, and is skipped if it is a delete, a value expired by now,
+// or deleted by a newer range tombstone.  If it is a merge operand, it is merged with the operands below it into the
+// first value below those, if any.
+//
+// Moving forward, the entry deciding a key is the first visible one reached, and the source is left there.  Moving
+// backward it is the last one reached, so the source is left before the entries for the key, at the last entry of the
+// key before it.
+type snapshotIterator struct {
+	source leveldb.Iterator
+	seq    uint64
+	// now is the time, in unix nanoseconds, by which the iterator takes values to have expired
+	now int64
+	// tombstones holds the range tombstones that may delete the source's entries, ordered by comparator
+	tombstones rangeTombstones
+	comparator leveldb.Comparator
+	merger     leveldb.MergeOperator
+	// err records a failure to merge, which ends the iteration
+	err error
+	// sourceValid records whether the source is at an entry, and forward whether the last move was forward
+	sourceValid bool
+	forward     bool
+	position    iteratorPosition
+	key         leveldb.Key
+	value       leveldb.Value
+	// release lets go of what the source reads from, and is cleared once called
+	release func()
+}
+
+// newSnapshotIterator returns an iterator over the entries of source visible at seq, as of now, and not deleted by any
+// of tombstones, whose keys comparator orders.  Merge operands are combined by merger.  If release is set, it is called
+// once the iterator is closed, to let go of what the source reads from; an iterator can move back from its end, so it
+// may need those until then.  An iterator the caller forgets to close lets go of them once garbage collected.
+func newSnapshotIterator(
+	source leveldb.Iterator,
+	seq uint64,
+	now int64,
+	tombstones rangeTombstones,
+	comparator leveldb.Comparator,
+	merger leveldb.MergeOperator,
+	release func(),
+) leveldb.Iterator {
+	var iterator = &snapshotIterator{
+		source:     source,
+		seq:        seq,
+		now:        now,
+		tombstones: tombstones,
+		comparator: comparator,
+		merger:     merger,
+		release:    release,
+	}
+	if release != nil {
+		runtime.SetFinalizer(iterator, func(i *snapshotIterator) { _ = i.Close() })
+	}
+	return iterator
+}
+
+// live returns the value of the source's current entry, and whether it has one: deletes do not, nor do values that
+// have expired or that a range tombstone deletes, nor merge operands.
+func (i *snapshotIterator) live(key internalKey) (leveldb.Value, bool) {
+	if i.deleted(key) {
+		return nil, false
+	}
+	return liveValue(key, i.source.Value(), i.now)
+}
+
+// deleted reports whether a range tombstone deletes the source's current entry.
+func (i *snapshotIterator) deleted(key internalKey) bool {
+	return len(i.tombstones) > 0 && key.sequence() < i.tombstones.deletedBefore(key.userKey(), i.seq, i.comparator)
+}
+
+// isOperand reports whether the source's current entry is a merge operand that no range tombstone deletes.
+func (i *snapshotIterator) isOperand(key internalKey) bool {
+	return key.kind() == kindMerge && !i.deleted(key)
+}
+
+func (i *snapshotIterator) Next() bool {
+	switch {
+	case i.position == afterLast:
+		return false
+	case i.position == beforeFirst:
+		return i.SeekToFirst()
+	case i.forward:
+		return i.findNext(i.source.Next(), i.key)
+	}
+	// move the source past the current key's entries, to the last possible internal key for it
+	return i.findNext(i.source.Seek(leveldb.Key(makeInternalKey(i.key, 0, kindDelete))), i.key)
+}
+
+func (i *snapshotIterator) Prev() bool {
+	switch {
+	case i.position == beforeFirst:
+		return false
+	case i.position == afterLast:
+		return i.SeekToLast()
+	case !i.forward:
+		return i.findPrev(i.sourceValid)
+	}
+	// move the source before the current key's entries, from the first possible internal key for it
+	i.source.Seek(leveldb.Key(lookupKey(i.key, maxSequence)))
+	return i.findPrev(i.source.Prev())
+}
+
+func (i *snapshotIterator) Seek(key leveldb.Key) bool {
+	return i.findNext(i.source.Seek(leveldb.Key(lookupKey(key, maxSequence))), nil)
+}
+
+func (i *snapshotIterator) SeekToFirst() bool {
+	return i.findNext(i.source.SeekToFirst(), nil)
+}
+
+func (i *snapshotIterator) SeekToLast() bool {
+	return i.findPrev(i.source.SeekToLast())
+}
+
+// findNext moves forward from the source's current entry, if ok, to the first user key other than skip with a visible
+// value.
+func (i *snapshotIterator) findNext(ok bool, skip leveldb.Key) bool {
+	var hasSkip = skip != nil
+	for ; ok; ok = i.source.Next() {
+		var key = internalKey(i.source.Key())
+		if key.sequence() > i.seq {
+			continue // written after the snapshot
+		}
+		var userKey = key.userKey()
+		if hasSkip && bytes.Equal(userKey, skip) {
+			continue // shadowed by a newer entry
+		}
+		skip, hasSkip = slices.Clone(userKey), true
+		if i.isOperand(key) {
+			return i.mergeNext(skip)
+		}
+		value, live := i.live(key)
+		if !live {
+			continue
+		}
+		i.key, i.value, i.position = skip, value, atEntry
+		i.sourceValid, i.forward = true, true
+		return true
+	}
+	i.key, i.value, i.position = nil, nil, afterLast
+	i.sourceValid, i.forward = false, true
+	return false
+}
+
+// mergeNext stacks the operands for userKey from the source's current entry, the newest of them, down to the first of
+// the key's entries that is not one, and merges them into its value.  The source is left at the last of the entries
+// merged, as findNext leaves it at the entry deciding a key.
+func (i *snapshotIterator) mergeNext(userKey leveldb.Key) bool {
+	var (
+		operands = []leveldb.Value{slices.Clone(i.source.Value())}
+		existing leveldb.Value
+	)
+	for i.source.Next() {
+		var key = internalKey(i.source.Key())
+		if !bytes.Equal(key.userKey(), userKey) {
+			i.source.Prev()
+			break
+		}
+		if !i.isOperand(key) {
+			existing, _ = i.live(key)
+			break
+		}
+		operands = append(operands, slices.Clone(i.source.Value()))
+	}
+	value, err := mergeOperands(i.merger, userKey, existing, operands)
+	if err != nil {
+		i.err = err
+		i.key, i.value, i.position = nil, nil, afterLast
+		i.sourceValid, i.forward = false, true
+		return false
+	}
+	i.key, i.value, i.position = userKey, value, atEntry
+	i.sourceValid, i.forward = true, true
+	return true
+}
+
+// findPrev moves backward from the source's current entry, if ok, to the last user key with a visible value.  A key's
+// entries come oldest first this way, so each visible one replaces the last until the key changes, while merge operands
+// are stacked on it.
+func (i *snapshotIterator) findPrev(ok bool) bool {
+	var (
+		userKey leveldb.Key
+		value   leveldb.Value
+		// operands holds the operands stacked on value, newest first
+		operands []leveldb.Value
+	)
+	for ; ok; ok = i.source.Prev() {
+		var key = internalKey(i.source.Key())
+		if key.sequence() > i.seq {
+			continue // written after the snapshot
+		}
+		if userKey != nil && !bytes.Equal(key.userKey(), userKey) {
+			break // the key found is decided, and the source is at the key before it
+		}
+		if i.isOperand(key) {
+			userKey = slices.Clone(key.userKey())
+			operands = slices.Insert(operands, 0, slices.Clone(i.source.Value()))
+			continue
+		}
+		var live bool
+		if value, live = i.live(key); !live {
+			userKey, value, operands = nil, nil, nil
+			continue
+		}
+		userKey, operands = slices.Clone(key.userKey()), nil
+	}
+	i.sourceValid, i.forward = ok, false
+	if userKey == nil {
+		i.key, i.value, i.position = nil, nil, beforeFirst
+		return false
+	}
+	if len(operands) > 0 {
+		var err error
+		if value, err = mergeOperands(i.merger, userKey, value, operands); err != nil {
+			i.err = err
+			i.key, i.value, i.position = nil, nil, beforeFirst
+			return false
+		}
+	}
+	i.key, i.value, i.position = userKey, value, atEntry
+	return true
+}
+
+func (i *snapshotIterator) Error() error {
+	if i.err != nil {
+		return i.err
+	}
+	return i.source.Error()
+}
+
+func (i *snapshotIterator) Key() leveldb.Key {
+	return i.key
+}
+
+func (i *snapshotIterator) Value() leveldb.Value {
+	return i.value
+}
+
+// Close closes the source, then lets go of what it reads from.
+func (i *snapshotIterator) Close() error {
+	var err = i.source.Close()
+	if i.release != nil {
+		runtime.SetFinalizer(i, nil)
+		i.release()
+		i.release = nil
+	}
+	return err
+}
@@ -0,0 +1,169 @@
+package db
+
+import (
+	"errors"
+	"leveldb"
+	"slices"
+	"testing"
+)
+
+func TestInternalKey_Order(t *testing.T) {
+	var keys = []internalKey{
+		makeInternalKey(leveldb.Key("a"), 7, kindValue),
+		makeInternalKey(leveldb.Key("a"), 7, kindDelete),
+		makeInternalKey(leveldb.Key("a"), 3, kindValue),
+		makeInternalKey(leveldb.Key("ab"), 9, kindValue),
+		makeInternalKey(leveldb.Key("b"), 1, kindDelete),
+	}
+	var shuffled = []internalKey{keys[3], keys[1], keys[4], keys[0], keys[2]}
+	var comparator = internalComparator{user: leveldb.BytewiseComparator}
+	slices.SortFunc(shuffled, func(a, b internalKey) int { return comparator.Compare(leveldb.Key(a), leveldb.Key(b)) })
+	for j, key := range shuffled {
+		if !slices.Equal(key, keys[j]) {
+			t.Errorf(
+				"expected key %d to be %q@%d, got %q@%d",
+				j,
+				keys[j].userKey(),
+				keys[j].sequence(),
+				key.userKey(),
+				key.sequence(),
+			)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	database, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal("unexpected error opening database:", err)
+	}
+	defer func() { _ = database.Close() }()
+	var db = database.(*db)
+
+	writeKeys(t, db, "key", 0, 4)
+	var snapshot = db.GetSnapshot()
+	if err := db.Put(leveldb.Key("key000"), leveldb.Value("overwritten")); err != nil {
+		t.Fatal("unexpected error executing Put()", err)
+	}
+	if err := db.Delete(leveldb.Key("key001")); err != nil {
+		t.Fatal("unexpected error executing Delete()", err)
+	}
+	writeKeys(t, db, "key", 4, 6)
+
+	var atSnapshot = map[string]string{
+		"key000": "value of key000",
+		"key001": "value of key001",
+		"key002": "value of key002",
+		"key003": "value of key003",
+	}
+	checkSnapshot := func(t *testing.T) {
+		t.Helper()
+		for key, expected := range atSnapshot {
+			val, err := db.Get(leveldb.Key(key), leveldb.WithSnapshot(snapshot))
+			if err != nil || string(val) != expected {
+				t.Errorf("expected %q=%q at the snapshot, got %q (err %v)", key, expected, val, err)
+			}
+		}
+		if has, err := db.Has(leveldb.Key("key004"), leveldb.WithSnapshot(snapshot)); err != nil || has {
+			t.Errorf("expected key written after the snapshot not to be seen, got %v (err %v)", has, err)
+		}
+		results, err := db.RangeScan(leveldb.Key("key"), leveldb.Key("key999"), leveldb.WithSnapshot(snapshot))
+		if err != nil {
+			t.Fatal("unexpected error executing RangeScan()", err)
+		}
+		defer results.Close()
+		var count int
+		for ; results.Next(); count++ {
+			if expected := atSnapshot[string(results.Key())]; string(results.Value()) != expected {
+				t.Errorf("expected %q=%q at the snapshot, got %q", results.Key(), expected, results.Value())
+			}
+		}
+		if err := results.Error(); err != nil {
+			t.Fatal("iterator generated unexpected error", err)
+		}
+		if count != len(atSnapshot) {
+			t.Errorf("expected %d results at the snapshot, got %d", len(atSnapshot), count)
+		}
+	}
+
+	t.Run("MemTable", checkSnapshot)
+	t.Run("Latest", func(t *testing.T) {
+		if val, err := db.Get(leveldb.Key("key000")); err != nil || string(val) != "overwritten" {
+			t.Errorf("expected latest read to see the overwrite, got %q (err %v)", val, err)
+		}
+		if val, err := db.Get(leveldb.Key("key001")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected latest read to see the delete, got %q (err %v)", val, err)
+		}
+	})
+	t.Run("AfterCompaction", func(t *testing.T) {
+		flushAndMoveTo(t, db, 1)
+		var c = &compaction{level: 1, outputLevel: 2, inputs: [2][]*fileMetadata{db.current.levels[1], nil}}
+		// a table in the output level, so that the inputs are rewritten rather than moved
+		c.inputs[1] = append(c.inputs[1], writeTable(t, db, 2, "other", 0, 1))
+		if err := db.runCompaction(c); err != nil {
+			t.Fatal("unexpected error compacting:", err)
+		}
+		checkSnapshot(t)
+		if entries := countEntries(t, db, db.current.levels[2]); entries != 9 {
+			t.Errorf("expected the overwritten value and the deleted key to be kept for the snapshot, found %d entries", entries)
+		}
+	})
+	t.Run("ReleasedBeforeCompaction", func(t *testing.T) {
+		snapshot.Release()
+		if _, err := db.Get(leveldb.Key("key000"), leveldb.WithSnapshot(snapshot)); err == nil {
+			t.Error("expected error reading from a released snapshot, did not get one")
+		}
+		var c = &compaction{level: 2, outputLevel: 3, inputs: [2][]*fileMetadata{db.current.levels[2], nil}}
+		c.inputs[1] = append(c.inputs[1], writeTable(t, db, 3, "other", 1, 2))
+		if err := db.runCompaction(c); err != nil {
+			t.Fatal("unexpected error compacting:", err)
+		}
+		if entries := countEntries(t, db, db.current.levels[3]); entries != 7 {
+			t.Errorf("expected only the 7 live keys to be kept once the snapshot was released, found %d entries", entries)
+		}
+	})
+}
+
+// countEntries counts the entries in the given tables, every write to a key included.
+func countEntries(t *testing.T, db *db, tables []*fileMetadata) int {
+	t.Helper()
+	var entries int
+	for _, meta := range tables {
+		var iterator = scanTable(t, db, meta)
+		for iterator.Next() {
+			entries++
+		}
+		if err := iterator.Error(); err != nil {
+			t.Fatal("unexpected error scanning table:", err)
+		}
+	}
+	return entries
+}
+
+// TestRangeScan_ConsistentView checks that a scan sees the database as it was when it started, even as writes land in
+// the memTable it is reading.
+func TestRangeScan_ConsistentView(t *testing.T) {
+	var database = NewDb(nil)
+	writeKeys(t, database, "key", 0, 4)
+	results, err := database.RangeScan(leveldb.Key("key"), leveldb.Key("key999"))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	defer results.Close()
+	var batch leveldb.WriteBatch
+	batch.Put(leveldb.Key("key001"), leveldb.Value("updated"))
+	batch.Put(leveldb.Key("key002"), leveldb.Value("updated"))
+	batch.Put(leveldb.Key("key003a"), leveldb.Value("added"))
+	if err := database.Write(&batch); err != nil {
+		t.Fatal("unexpected error writing batch", err)
+	}
+	var count int
+	for ; results.Next(); count++ {
+		if expected := "value of " + string(results.Key()); string(results.Value()) != expected {
+			t.Errorf("expected %q=%q, got %q", results.Key(), expected, results.Value())
+		}
+	}
+	if count != 4 {
+		t.Errorf("expected the 4 keys present when the scan started, got %d", count)
+	}
+}
@@ -0,0 +1,77 @@
+package db
+
+// Stats reports how a database is laid out on disk, how much work compaction has cost it, and what recovery found.
+// Databases not created by Open have no SSTables, so only report on recovery.  The layout of SSTables is the default
+// column family's; the other counts cover every family.
+type Stats struct {
+	// Tables holds the number of SSTables in each level.
+	Tables [numLevels]int
+
+	// ReadAmplification is the number of sorted runs a lookup may have to search, not counting the memTable: one per
+	// level 0 table, plus one per deeper level holding any tables.
+	ReadAmplification int
+
+	// BytesFlushed is the number of bytes written to SSTables by memTable flushes since the database was opened.
+	BytesFlushed uint64
+
+	// BytesCompacted is the number of bytes written to SSTables by compactions since the database was opened.
+	BytesCompacted uint64
+
+	// WriteAmplification is the number of bytes written to SSTables for each byte flushed, or zero before the first
+	// flush.
+	WriteAmplification float64
+
+	// WALBytesDiscarded is the number of bytes recovery dropped from the WAL: those past the last intact record of each
+	// segment, usually the remains of a write interrupted by a crash, which was never acknowledged, and any damaged
+	// records skipped.
+	WALBytesDiscarded uint64
+
+	// BlockCacheHits and BlockCacheMisses count the SSTable data blocks found in the block cache and read from disk.
+	BlockCacheHits   uint64
+	BlockCacheMisses uint64
+
+	// OpenTables is the number of SSTables the table cache holds open.  Tables kept open only by iterators reading
+	// them are not counted.
+	OpenTables int
+}
+
+// StatsReporter is implemented by databases that report Stats.
+type StatsReporter interface {
+	Stats() Stats
+}
+
+// stats accumulates the counters behind Stats.
+type stats struct {
+	bytesFlushed      uint64
+	bytesCompacted    uint64
+	walBytesDiscarded uint64
+}
+
+// Stats waits for any write in progress, along with the flushes and compactions it runs, so as to report on the state
+// they leave behind.
+func (db *db) Stats() Stats {
+	db.writeMu.Lock()
+	defer db.writeMu.Unlock()
+	var s = Stats{
+		BytesFlushed:      db.stats.bytesFlushed,
+		BytesCompacted:    db.stats.bytesCompacted,
+		WALBytesDiscarded: db.stats.walBytesDiscarded,
+		OpenTables:        db.tables.numOpen(),
+	}
+	for level, files := range db.current.levels {
+		s.Tables[level] = len(files)
+		if level == 0 {
+			s.ReadAmplification += len(files)
+		} else if len(files) > 0 {
+			s.ReadAmplification++
+		}
+	}
+	if db.blockCache != nil {
+		var cacheStats = db.blockCache.Stats()
+		s.BlockCacheHits, s.BlockCacheMisses = cacheStats.Hits, cacheStats.Misses
+	}
+	if s.BytesFlushed > 0 {
+		s.WriteAmplification = float64(s.BytesFlushed+s.BytesCompacted) / float64(s.BytesFlushed)
+	}
+	return s
+}
@@ -0,0 +1,198 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"leveldb"
+	"leveldb/cache"
+	"leveldb/sst"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// tableCache opens SSTables as they are read, keeping the most recently used of them open for the next read, along
+// with the index and filter parsed when opening them.  The least recently used are closed to keep the number open
+// within a limit, except that a table stays open for as long as a reader is using it.
+//
+// It also counts the versions holding each table, which decides when a table is obsolete: once none does, its handle
+// is dropped and removeObsoleteFiles may delete the file.
+//
+// Caches made by sharing hold their tables open and counted alongside the original's, within the same limit.
+type tableCache struct {
+	dir     string
+	open    func(f *os.File, number uint64) (*sst.SSTableDB, error)
+	handles *cache.Cache[uint64, *tableHandle]
+
+	mu *sync.Mutex
+	// refs counts the versions holding each live table, by file number
+	refs map[uint64]int
+}
+
+// newTableCache returns a tableCache keeping up to capacity tables in dir open, opening them with open.
+func newTableCache(dir string, capacity int, open func(f *os.File, number uint64) (*sst.SSTableDB, error)) *tableCache {
+	return &tableCache{
+		dir:  dir,
+		open: open,
+		handles: cache.New[uint64, *tableHandle](
+			int64(capacity),
+			func(number uint64) uint64 { return number },
+			func(_ uint64, handle *tableHandle) {
+				// nothing is waiting on the close of an evicted table; it is opened again when next read
+				_ = handle.release()
+			},
+		),
+		mu:   new(sync.Mutex),
+		refs: make(map[uint64]int),
+	}
+}
+
+// sharing returns a tableCache opening its tables with open, but otherwise sharing c's open tables and counts.  Table
+// numbers are unique across the caches, which lets a column family open its tables with its own Comparator.
+func (c *tableCache) sharing(open func(f *os.File, number uint64) (*sst.SSTableDB, error)) *tableCache {
+	return &tableCache{dir: c.dir, open: open, handles: c.handles, mu: c.mu, refs: c.refs}
+}
+
+// tableHandle is an open table, closed once the cache has let go of it and no reader is left using it.
+type tableHandle struct {
+	table *sst.SSTableDB
+	// tombstones holds the table's range tombstones, decoded once when it is opened
+	tombstones rangeTombstones
+	// refs counts the cache's reference, while the handle is cached, and one for each reader using it
+	refs atomic.Int32
+}
+
+func newTableHandle(table *sst.SSTableDB, refs int32) *tableHandle {
+	var handle = &tableHandle{table: table, tombstones: decodeRangeTombstones(table.RangeTombstones())}
+	handle.refs.Store(refs)
+	return handle
+}
+
+// tryRef takes a reference to the handle, unless it has already been closed.
+func (handle *tableHandle) tryRef() bool {
+	for {
+		var refs = handle.refs.Load()
+		if refs == 0 {
+			return false
+		}
+		if handle.refs.CompareAndSwap(refs, refs+1) {
+			return true
+		}
+	}
+}
+
+// release drops a reference to the handle, closing the table once none are left.
+func (handle *tableHandle) release() error {
+	if handle.refs.Add(-1) == 0 {
+		return handle.table.Close()
+	}
+	return nil
+}
+
+// scan iterates over the table's entries for user keys in [start, limit], keyed by internal key and deletes included.
+// The caller keeps its reference to the handle until done with the iterator.
+func (handle *tableHandle) scan(
+	start leveldb.Key,
+	limit leveldb.Key,
+	opts ...leveldb.ReadOption,
+) (leveldb.Iterator, error) {
+	var internalStart, internalLimit = internalRange(start, limit)
+	return handle.table.RangeScanWithTombstones(leveldb.Key(internalStart), leveldb.Key(internalLimit), opts...)
+}
+
+// acquire returns a handle on the table numbered number, opening it if it is not already open.  The caller releases
+// the handle once done with it.
+func (c *tableCache) acquire(number uint64) (*tableHandle, error) {
+	// a handle evicted since Get found it may already be closed, in which case the table is opened again
+	if handle, ok := c.handles.Get(number); ok && handle.tryRef() {
+		return handle, nil
+	}
+	f, err := os.Open(tableFileName(c.dir, number))
+	if err != nil {
+		return nil, fmt.Errorf("error opening SSTable: %v", err)
+	}
+	table, err := c.open(f, number)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("error loading SSTable %s: %v", f.Name(), err)
+	}
+	// readers racing to open the same table each cache their own handle, and the last one replaces the others
+	var handle = newTableHandle(table, 2)
+	c.handles.Add(number, handle, 1)
+	return handle, nil
+}
+
+// add caches a table just written, which is already open.
+func (c *tableCache) add(number uint64, table *sst.SSTableDB) {
+	c.handles.Add(number, newTableHandle(table, 1), 1)
+}
+
+// find looks up key in the table numbered number, as sst.SSTableDB.Find does, and also returns the table's range
+// tombstones, which the lookup has to take into account.  The tombstones are returned even if no entry is found.
+func (c *tableCache) find(
+	number uint64,
+	key leveldb.Key,
+	opts ...leveldb.ReadOption,
+) (leveldb.Key, leveldb.Value, rangeTombstones, error) {
+	handle, err := c.acquire(number)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer func() {
+		// the lookup is done, whether or not the table closes cleanly
+		_ = handle.release()
+	}()
+	foundKey, value, err := handle.table.Find(key, opts...)
+	return foundKey, value, handle.tombstones, err
+}
+
+// evict drops the cache's handle on the table numbered number, closing it unless a reader is still using it.
+func (c *tableCache) evict(number uint64) error {
+	if handle, ok := c.handles.Remove(number); ok {
+		return handle.release()
+	}
+	return nil
+}
+
+// ref records that another version holds the table numbered number.
+func (c *tableCache) ref(number uint64) {
+	c.mu.Lock()
+	c.refs[number]++
+	c.mu.Unlock()
+}
+
+// unref records that a version holding the table numbered number is gone, and evicts the table once none are left.
+func (c *tableCache) unref(number uint64) error {
+	c.mu.Lock()
+	c.refs[number]--
+	var obsolete = c.refs[number] == 0
+	if obsolete {
+		delete(c.refs, number)
+	}
+	c.mu.Unlock()
+	if obsolete {
+		return c.evict(number)
+	}
+	return nil
+}
+
+// live reports whether any version holds the table numbered number.
+func (c *tableCache) live(number uint64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.refs[number] > 0
+}
+
+// numOpen returns the number of tables the cache holds open, not counting those only kept open by their readers.
+func (c *tableCache) numOpen() int {
+	return int(c.handles.Stats().Charge)
+}
+
+// releaseAll releases every handle in handles.
+func releaseAll(handles []*tableHandle) error {
+	var errs []error
+	for _, handle := range handles {
+		errs = append(errs, handle.release())
+	}
+	return errors.Join(errs...)
+}
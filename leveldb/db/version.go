@@ -0,0 +1,189 @@
+package db
+
+import (
+	"errors"
+	"leveldb"
+	"slices"
+	"sync/atomic"
+)
+
+const numLevels = 7
+
+// version is the set of live SSTables, arranged in levels.  Level 0 holds the output of memTable flushes; its tables
+// may overlap one another and are ordered oldest to newest.  Every other level holds tables with disjoint key ranges,
+// ordered by key, and is allowed to grow ten times larger than the level above it.  Data moves down a level at a time
+// through compaction, so within a key range each level holds older data than the one above it.
+//
+// A version is never modified once built; applying an edit produces a new one.  Readers hold a reference to the
+// version they started with, which keeps its tables from being deleted however many versions follow it.
+type version struct {
+	levels [numLevels][]*fileMetadata
+	// tables opens the version's tables, and counts the versions holding each of them
+	tables *tableCache
+	// comparator orders user keys, and so the tables in each level below 0
+	comparator leveldb.Comparator
+	// refs counts the database, while the version is current, and each reader using it
+	refs atomic.Int32
+}
+
+func (v *version) ref() {
+	v.refs.Add(1)
+}
+
+// unref drops a reference to v.  Once none are left, v drops its references to its tables, evicting those no other
+// version holds from the table cache, and returns any errors closing them.
+func (v *version) unref() error {
+	if v.refs.Add(-1) != 0 {
+		return nil
+	}
+	var errs []error
+	for _, files := range v.levels {
+		for _, meta := range files {
+			errs = append(errs, v.tables.unref(meta.number))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// installVersion makes next the family's current version, taking references to it and its tables, and drops the
+// reference to the version it replaces.
+func (cf *columnFamily) installVersion(next *version) {
+	next.ref()
+	for _, files := range next.levels {
+		for _, meta := range files {
+			next.tables.ref(meta.number)
+		}
+	}
+	cf.db.mu.Lock()
+	var previous = cf.current
+	cf.current = next
+	cf.db.mu.Unlock()
+	if previous != nil {
+		// a table that fails to close has nothing left to lose, having been replaced
+		_ = previous.unref()
+	}
+}
+
+// apply returns the version resulting from applying the edit to v.
+func (v *version) apply(edit *versionEdit) *version {
+	var next = &version{tables: v.tables, comparator: v.comparator}
+	for level := range v.levels {
+		next.levels[level] = slices.Clone(v.levels[level])
+	}
+	// a level 0 table replacing deleted level 0 tables takes their place, so that it stays newer than the tables before
+	// them and older than the ones after
+	var replaceAt = -1
+	for _, deleted := range edit.deletedFiles {
+		if deleted.level == 0 {
+			var idx = slices.IndexFunc(next.levels[0], func(meta *fileMetadata) bool { return meta.number == deleted.number })
+			if idx >= 0 && (replaceAt < 0 || idx < replaceAt) {
+				replaceAt = idx
+			}
+		}
+		next.levels[deleted.level] = slices.DeleteFunc(next.levels[deleted.level], func(meta *fileMetadata) bool {
+			return meta.number == deleted.number
+		})
+	}
+	for _, added := range edit.newFiles {
+		if added.level == 0 && replaceAt >= 0 {
+			next.levels[0] = slices.Insert(next.levels[0], replaceAt, added.meta)
+			replaceAt++
+			continue
+		}
+		// other level 0 tables are added by flushes, each newer than the last, so appending keeps them in order
+		next.levels[added.level] = append(next.levels[added.level], added.meta)
+	}
+	for level := 1; level < numLevels; level++ {
+		slices.SortFunc(next.levels[level], func(a, b *fileMetadata) int {
+			return v.comparator.Compare(a.smallest, b.smallest)
+		})
+	}
+	return next
+}
+
+// tablesForKey returns the tables that may hold key, in the order they must be searched: level 0 newest to oldest,
+// then at most one table from each deeper level.
+func (v *version) tablesForKey(key leveldb.Key) []*fileMetadata {
+	var tables []*fileMetadata
+	for j := len(v.levels[0]) - 1; j >= 0; j-- {
+		if v.levels[0][j].contains(key, v.comparator) {
+			tables = append(tables, v.levels[0][j])
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		var files = v.levels[level]
+		// the first table whose largest key is not less than key is the only one that could contain it
+		idx, _ := slices.BinarySearchFunc(files, key, func(meta *fileMetadata, key leveldb.Key) int {
+			return v.comparator.Compare(meta.largest, key)
+		})
+		if idx < len(files) && files[idx].contains(key, v.comparator) {
+			tables = append(tables, files[idx])
+		}
+	}
+	return tables
+}
+
+// tablesForRange returns the tables overlapping [smallest, largest], newest data first: level 0 newest to oldest, then
+// each deeper level in key order.
+func (v *version) tablesForRange(smallest leveldb.Key, largest leveldb.Key) []*fileMetadata {
+	var tables []*fileMetadata
+	for j := len(v.levels[0]) - 1; j >= 0; j-- {
+		if v.levels[0][j].overlaps(smallest, largest, v.comparator) {
+			tables = append(tables, v.levels[0][j])
+		}
+	}
+	for level := 1; level < numLevels; level++ {
+		tables = append(tables, v.overlapping(level, smallest, largest)...)
+	}
+	return tables
+}
+
+// overlapping returns the tables in the given level whose key ranges overlap [smallest, largest].
+func (v *version) overlapping(level int, smallest leveldb.Key, largest leveldb.Key) []*fileMetadata {
+	var tables []*fileMetadata
+	for _, meta := range v.levels[level] {
+		if meta.overlaps(smallest, largest, v.comparator) {
+			tables = append(tables, meta)
+		}
+	}
+	return tables
+}
+
+func (v *version) levelSize(level int) uint64 {
+	var size uint64
+	for _, meta := range v.levels[level] {
+		size += meta.size
+	}
+	return size
+}
+
+func (v *version) numFiles() int {
+	var count int
+	for _, files := range v.levels {
+		count += len(files)
+	}
+	return count
+}
+
+func (meta *fileMetadata) contains(key leveldb.Key, comparator leveldb.Comparator) bool {
+	return comparator.Compare(meta.smallest, key) <= 0 && comparator.Compare(key, meta.largest) <= 0
+}
+
+func (meta *fileMetadata) overlaps(smallest leveldb.Key, largest leveldb.Key, comparator leveldb.Comparator) bool {
+	return comparator.Compare(meta.smallest, largest) <= 0 && comparator.Compare(smallest, meta.largest) <= 0
+}
+
+// keyRangeOf returns the smallest and largest keys across the given tables, as ordered by comparator.
+func keyRangeOf(comparator leveldb.Comparator, tables ...[]*fileMetadata) (smallest leveldb.Key, largest leveldb.Key) {
+	for _, files := range tables {
+		for _, meta := range files {
+			if smallest == nil || comparator.Compare(meta.smallest, smallest) < 0 {
+				smallest = meta.smallest
+			}
+			if largest == nil || comparator.Compare(meta.largest, largest) > 0 {
+				largest = meta.largest
+			}
+		}
+	}
+	return smallest, largest
+}
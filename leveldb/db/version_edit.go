@@ -0,0 +1,337 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"leveldb"
+	"leveldb/encoding"
+)
+
+// fileMetadata describes an SSTable belonging to the database.
+type fileMetadata struct {
+	number   uint64
+	size     uint64
+	smallest leveldb.Key
+	largest  leveldb.Key
+}
+
+type newFile struct {
+	level int
+	meta  *fileMetadata
+}
+
+type deletedFile struct {
+	level  int
+	number uint64
+}
+
+// versionEdit is a record in the manifest.  Replaying every edit in a manifest, in order, reconstructs the set of live
+// SSTables along with the bookkeeping needed to recover the memTable from the WAL.
+//
+// An edit applies to a single column family: the comparator and files it records are the family's, and it may create
+// or drop the family.  The remaining fields concern the whole database.  Edits for other families may be nested in an
+// edit, so that changes spanning families, such as a flush, are recorded as one record and replayed all or not at all.
+type versionEdit struct {
+	// family is the ID of the column family the edit applies to, 0 being the default family
+	family uint32
+	// addedFamily is the name of the family the edit creates, along with its comparator
+	addedFamily    string
+	hasAddedFamily bool
+	// droppedFamily marks an edit dropping its family, which edits no longer mention from then on
+	droppedFamily bool
+	// comparator is the name of the Comparator ordering the family's keys.  Every manifest records it in the first
+	// edit for each family.
+	comparator    string
+	hasComparator bool
+	// logNumber is the number of the oldest WAL segment whose entries have not all been flushed.  Segments with
+	// lower numbers are obsolete.
+	logNumber         uint64
+	hasLogNumber      bool
+	nextFileNumber    uint64
+	hasNextFileNumber bool
+	// lastSequence is the sequence number of the last write in any table
+	lastSequence    uint64
+	hasLastSequence bool
+	// nextFamily is the ID the next family created takes.  IDs are never reused, so that WAL entries for a dropped
+	// family are not taken for another's.
+	nextFamily    uint32
+	hasNextFamily bool
+	deletedFiles  []deletedFile
+	newFiles      []newFile
+	// familyEdits are applied along with the edit, in order, once it has been
+	familyEdits []*versionEdit
+}
+
+type editTag uint8
+
+const (
+	_ editTag = iota
+	tagLogNumber
+	tagNextFileNumber
+	tagDeletedFile
+	tagNewFile
+	tagLastSequence
+	tagComparator
+	tagColumnFamily
+	tagAddColumnFamily
+	tagDropColumnFamily
+	tagNextColumnFamily
+	tagFamilyEdit
+)
+
+func (edit *versionEdit) setFamily(id uint32) {
+	edit.family = id
+}
+
+func (edit *versionEdit) addFamily(name string) {
+	edit.addedFamily, edit.hasAddedFamily = name, true
+}
+
+func (edit *versionEdit) dropFamily() {
+	edit.droppedFamily = true
+}
+
+func (edit *versionEdit) setNextFamily(id uint32) {
+	edit.nextFamily, edit.hasNextFamily = id, true
+}
+
+func (edit *versionEdit) setComparator(name string) {
+	edit.comparator, edit.hasComparator = name, true
+}
+
+func (edit *versionEdit) setLogNumber(number uint64) {
+	edit.logNumber, edit.hasLogNumber = number, true
+}
+
+func (edit *versionEdit) setNextFileNumber(number uint64) {
+	edit.nextFileNumber, edit.hasNextFileNumber = number, true
+}
+
+func (edit *versionEdit) setLastSequence(seq uint64) {
+	edit.lastSequence, edit.hasLastSequence = seq, true
+}
+
+func (edit *versionEdit) addFile(level int, meta *fileMetadata) {
+	edit.newFiles = append(edit.newFiles, newFile{level: level, meta: meta})
+}
+
+func (edit *versionEdit) deleteFile(level int, number uint64) {
+	edit.deletedFiles = append(edit.deletedFiles, deletedFile{level: level, number: number})
+}
+
+func (edit *versionEdit) addFamilyEdit(familyEdit *versionEdit) {
+	edit.familyEdits = append(edit.familyEdits, familyEdit)
+}
+
+func (edit *versionEdit) Encode() ([]byte, error) {
+	/**
+	 * format: a sequence of fields, each one a 1-byte tag followed by its payload
+	 * | tagColumnFamily     | 8 bytes [family ID]        |
+	 * | tagAddColumnFamily  | [family name]              |
+	 * | tagDropColumnFamily |                            |
+	 * | tagNextColumnFamily | 8 bytes [next family ID]   |
+	 * | tagComparator       | [comparator name]          |
+	 * | tagLogNumber        | 8 bytes [log number]       |
+	 * | tagNextFileNumber   | 8 bytes [next file number] |
+	 * | tagLastSequence     | 8 bytes [last sequence]    |
+	 * | tagDeletedFile      | 8 bytes [level] | 8 bytes [file number] |
+	 * | tagNewFile          | 8 bytes [level] | 8 bytes [file number] | 8 bytes [file size] | [smallest key] | [largest key] |
+	 * | tagFamilyEdit       | [edit]                     |
+	 *
+	 * , where keys, names and nested edits are encoded as by leveldb.Key.Encode.  Edits for the default family leave
+	 * out its ID.
+	 */
+	var buf = bytes.NewBuffer(nil)
+	if edit.family != 0 {
+		buf.WriteByte(byte(tagColumnFamily))
+		if err := encoding.WriteUint64(buf, uint64(edit.family)); err != nil {
+			return nil, err
+		}
+	}
+	if edit.hasAddedFamily {
+		buf.WriteByte(byte(tagAddColumnFamily))
+		encodedName, err := leveldb.Key(edit.addedFamily).Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedName)
+	}
+	if edit.droppedFamily {
+		buf.WriteByte(byte(tagDropColumnFamily))
+	}
+	if edit.hasNextFamily {
+		buf.WriteByte(byte(tagNextColumnFamily))
+		if err := encoding.WriteUint64(buf, uint64(edit.nextFamily)); err != nil {
+			return nil, err
+		}
+	}
+	if edit.hasComparator {
+		buf.WriteByte(byte(tagComparator))
+		encodedName, err := leveldb.Key(edit.comparator).Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedName)
+	}
+	if edit.hasLogNumber {
+		buf.WriteByte(byte(tagLogNumber))
+		if err := encoding.WriteUint64(buf, edit.logNumber); err != nil {
+			return nil, err
+		}
+	}
+	if edit.hasNextFileNumber {
+		buf.WriteByte(byte(tagNextFileNumber))
+		if err := encoding.WriteUint64(buf, edit.nextFileNumber); err != nil {
+			return nil, err
+		}
+	}
+	if edit.hasLastSequence {
+		buf.WriteByte(byte(tagLastSequence))
+		if err := encoding.WriteUint64(buf, edit.lastSequence); err != nil {
+			return nil, err
+		}
+	}
+	for _, deleted := range edit.deletedFiles {
+		buf.WriteByte(byte(tagDeletedFile))
+		for _, v := range []uint64{uint64(deleted.level), deleted.number} {
+			if err := encoding.WriteUint64(buf, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, added := range edit.newFiles {
+		buf.WriteByte(byte(tagNewFile))
+		for _, v := range []uint64{uint64(added.level), added.meta.number, added.meta.size} {
+			if err := encoding.WriteUint64(buf, v); err != nil {
+				return nil, err
+			}
+		}
+		for _, key := range []leveldb.Key{added.meta.smallest, added.meta.largest} {
+			encodedKey, err := key.Encode()
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(encodedKey)
+		}
+	}
+	for _, familyEdit := range edit.familyEdits {
+		buf.WriteByte(byte(tagFamilyEdit))
+		payload, err := familyEdit.Encode()
+		if err != nil {
+			return nil, err
+		}
+		encodedEdit, err := leveldb.Key(payload).Encode()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(encodedEdit)
+	}
+	return buf.Bytes(), nil
+}
+
+func (edit *versionEdit) Decode(i []byte) error {
+	var reader = bytes.NewReader(i)
+	for {
+		tag, err := reader.ReadByte()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		switch editTag(tag) {
+		case tagColumnFamily:
+			id, err := encoding.ReadUint64(reader)
+			if err != nil {
+				return err
+			}
+			edit.setFamily(uint32(id))
+		case tagAddColumnFamily:
+			name, err := readKey(reader)
+			if err != nil {
+				return err
+			}
+			edit.addFamily(string(name))
+		case tagDropColumnFamily:
+			edit.dropFamily()
+		case tagNextColumnFamily:
+			id, err := encoding.ReadUint64(reader)
+			if err != nil {
+				return err
+			}
+			edit.setNextFamily(uint32(id))
+		case tagComparator:
+			name, err := readKey(reader)
+			if err != nil {
+				return err
+			}
+			edit.setComparator(string(name))
+		case tagLogNumber:
+			if edit.logNumber, err = encoding.ReadUint64(reader); err != nil {
+				return err
+			}
+			edit.hasLogNumber = true
+		case tagNextFileNumber:
+			if edit.nextFileNumber, err = encoding.ReadUint64(reader); err != nil {
+				return err
+			}
+			edit.hasNextFileNumber = true
+		case tagLastSequence:
+			if edit.lastSequence, err = encoding.ReadUint64(reader); err != nil {
+				return err
+			}
+			edit.hasLastSequence = true
+		case tagDeletedFile:
+			level, err := encoding.ReadUint64(reader)
+			if err != nil {
+				return err
+			}
+			number, err := encoding.ReadUint64(reader)
+			if err != nil {
+				return err
+			}
+			edit.deleteFile(int(level), number)
+		case tagNewFile:
+			var meta = new(fileMetadata)
+			level, err := encoding.ReadUint64(reader)
+			if err != nil {
+				return err
+			}
+			if meta.number, err = encoding.ReadUint64(reader); err != nil {
+				return err
+			}
+			if meta.size, err = encoding.ReadUint64(reader); err != nil {
+				return err
+			}
+			if meta.smallest, err = readKey(reader); err != nil {
+				return err
+			}
+			if meta.largest, err = readKey(reader); err != nil {
+				return err
+			}
+			edit.addFile(int(level), meta)
+		case tagFamilyEdit:
+			payload, err := readKey(reader)
+			if err != nil {
+				return err
+			}
+			var familyEdit = new(versionEdit)
+			if err := familyEdit.Decode(payload); err != nil {
+				return err
+			}
+			edit.addFamilyEdit(familyEdit)
+		default:
+			return fmt.Errorf("unrecognized version edit tag %d", tag)
+		}
+	}
+}
+
+func readKey(r io.Reader) (leveldb.Key, error) {
+	keyLen, err := encoding.ReadUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	return encoding.ReadByteSlice(r, keyLen)
+}
@@ -11,6 +11,7 @@ import (
 
 const (
 	uint8Size  = 1
+	uint32Size = 4
 	uint64Size = 8
 )
 
@@ -45,6 +46,14 @@ func (o opcode) String() string {
 		return "PUT"
 	case OpDelete:
 		return "DELETE"
+	case OpBatch:
+		return "BATCH"
+	case OpPutWithExpiry:
+		return "PUT_WITH_EXPIRY"
+	case OpDeleteRange:
+		return "DELETE_RANGE"
+	case OpMerge:
+		return "MERGE"
 	default:
 		return "UNKNOWN"
 	}
@@ -52,7 +61,7 @@ func (o opcode) String() string {
 
 func (o opcode) IncludeValue() bool {
 	switch o {
-	case OpPut:
+	case OpPut, OpPutWithExpiry, OpDeleteRange, OpMerge:
 		return true
 	default:
 		return false
@@ -63,6 +72,15 @@ const (
 	_ opcode = iota
 	OpPut
 	OpDelete
+	// OpBatch groups operations written and recovered together.  It is followed by their count and then each of them,
+	// encoded as by DbOperation.Encode, in place of a key and value.
+	OpBatch
+	// OpPutWithExpiry is a put whose value expires.  The expiry follows the value, in unix nanoseconds.
+	OpPutWithExpiry
+	// OpDeleteRange deletes every key from its key to its value, the end of the range, inclusive.
+	OpDeleteRange
+	// OpMerge stacks its value on its key, as an operand for the database's MergeOperator to combine with the rest.
+	OpMerge
 )
 
 type Entry struct {
@@ -104,6 +122,13 @@ func (e *Entry) Encode() ([]byte, error) {
 type DbOperation struct {
 	Operation Opcode
 	Entry
+	// Batch holds the operations of an OpBatch, which has no Entry of its own.
+	Batch []*DbOperation
+	// ExpiresAt is when the value of an OpPutWithExpiry expires, in unix nanoseconds.
+	ExpiresAt int64
+	// ColumnFamily is the ID of the column family the operation applies to.  It follows the operation's other fields
+	// unless it is 0, the default family, so that operations on it are encoded as they were before families.
+	ColumnFamily uint32
 }
 
 func DecodeLogFile(reader *bufio.Reader) ([]*DbOperation, error) {
@@ -128,8 +153,8 @@ func DecodeLogFile(reader *bufio.Reader) ([]*DbOperation, error) {
 			opBytes = make([]byte, *lenBuf)
 			opBuf   = new(DbOperation)
 		)
-		numRead, err := reader.Read(opBytes)
-		if err != nil {
+		numRead, err := io.ReadFull(reader, opBytes)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 			return nil, fmt.Errorf("error reading operation code")
 		}
 		if numRead != len(opBytes) {
@@ -182,27 +207,40 @@ func (e *DbOperation) Decode(i []byte) error {
 		opcodeBuf opcode
 		value     Value
 		valLenBuf uint64
+		expiresAt int64
+		family    uint32
 	)
 	if err = binary.Read(buf, ByteOrder, &opcodeBuf); err != nil {
 		return err
 	}
+	if opcodeBuf == OpBatch {
+		return e.decodeBatch(buf)
+	}
 	// read key length, read key data
-	if err = binary.Read(buf, ByteOrder, &keyLenBuf); err != nil {
+	if keyLenBuf, err = binary.ReadUvarint(buf); err != nil {
 		return err
 	}
-	key = make(Key, keyLenBuf)
-	err = binary.Read(buf, ByteOrder, key)
-	if err != nil {
-		return err
+	if keyLenBuf > uint64(buf.Len()) {
+		return fmt.Errorf("encoding.DbOperation.Decode: key of %d bytes overruns its record", keyLenBuf)
 	}
+	key = bytes.Clone(buf.Next(int(keyLenBuf)))
 	if opcodeBuf.IncludeValue() {
 		// read value length, read value data
-		if err = binary.Read(buf, ByteOrder, &valLenBuf); err != nil {
+		if valLenBuf, err = binary.ReadUvarint(buf); err != nil {
 			return err
 		}
-		value = make(Value, valLenBuf)
-		err = binary.Read(buf, ByteOrder, value)
-		if err != nil {
+		if valLenBuf > uint64(buf.Len()) {
+			return fmt.Errorf("encoding.DbOperation.Decode: value of %d bytes overruns its record", valLenBuf)
+		}
+		value = bytes.Clone(buf.Next(int(valLenBuf)))
+	}
+	if opcodeBuf == OpPutWithExpiry {
+		if err = binary.Read(buf, ByteOrder, &expiresAt); err != nil {
+			return err
+		}
+	}
+	if buf.Len() > 0 {
+		if err = binary.Read(buf, ByteOrder, &family); err != nil {
 			return err
 		}
 	}
@@ -210,45 +248,93 @@ func (e *DbOperation) Decode(i []byte) error {
 	e.Operation = opcodeBuf
 	e.Key = key
 	e.Value = value
+	e.ExpiresAt = expiresAt
+	e.ColumnFamily = family
 	return nil
 }
 
-func (e *DbOperation) Encode() ([]byte, error) {
-	var (
-		buf bytes.Buffer
-		w   = io.Writer(&buf)
-	)
-	var totalLen = uint64(
-		uint8Size + // opcode is uint8
-			uint64Size + // keyLen encoding is uint64
-			len(e.Key)) // length of key (a byte array)
-
-	if e.Operation.IncludeValue() {
-		totalLen += uint64(uint64Size + len(e.Value))
+func (e *DbOperation) decodeBatch(buf *bytes.Buffer) error {
+	count, err := ReadUint64(buf)
+	if err != nil {
+		return err
+	}
+	// every operation takes at least a length, an opcode and a one-byte key length, which bounds a corrupt count
+	if count > uint64(buf.Len()/(uint64Size+uint8Size+uint8Size)) {
+		return fmt.Errorf("encoding.DbOperation.Decode: batch of %d operations overruns its record", count)
 	}
+	var batch = make([]*DbOperation, 0, count)
+	for range count {
+		opLen, err := ReadUint64(buf)
+		if err != nil {
+			return err
+		}
+		if opLen > uint64(buf.Len()) {
+			return fmt.Errorf("encoding.DbOperation.Decode: batched operation of %d bytes overruns its record", opLen)
+		}
+		var op = new(DbOperation)
+		if err := op.Decode(buf.Next(int(opLen))); err != nil {
+			return err
+		}
+		batch = append(batch, op)
+	}
+	e.Operation = OpBatch
+	e.Entry = Entry{}
+	e.Batch = batch
+	return nil
+}
 
-	if err := binary.Write(w, ByteOrder, totalLen); err != nil {
+func (e *DbOperation) encodeBatch() ([]byte, error) {
+	var body bytes.Buffer
+	body.WriteByte(byte(OpBatch))
+	if err := WriteUint64(&body, uint64(len(e.Batch))); err != nil {
 		return nil, err
 	}
-	if err := binary.Write(w, ByteOrder, e.Operation); err != nil {
+	for _, op := range e.Batch {
+		encoded, err := op.Encode()
+		if err != nil {
+			return nil, err
+		}
+		body.Write(encoded)
+	}
+	var buf bytes.Buffer
+	if err := WriteUint64(&buf, uint64(body.Len())); err != nil {
 		return nil, err
 	}
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
 
-	if err := binary.Write(w, ByteOrder, uint64(len(e.Key))); err != nil {
-		return nil, err
+// Encode encodes the operation behind its length, a fixed uint64.  Its key and value lengths are uvarints, so the
+// short keys and values most operations carry take a byte or two to delimit rather than eight each.
+func (e *DbOperation) Encode() ([]byte, error) {
+	if e.Operation == OpBatch {
+		return e.encodeBatch()
 	}
-	if err := binary.Write(w, ByteOrder, e.Key); err != nil {
+	var body bytes.Buffer
+	if err := binary.Write(&body, ByteOrder, e.Operation); err != nil {
 		return nil, err
 	}
-
+	body.Write(binary.AppendUvarint(body.AvailableBuffer(), uint64(len(e.Key))))
+	body.Write(e.Key)
 	if e.Operation.IncludeValue() {
-		if err := binary.Write(w, ByteOrder, uint64(len(e.Value))); err != nil {
+		body.Write(binary.AppendUvarint(body.AvailableBuffer(), uint64(len(e.Value))))
+		body.Write(e.Value)
+	}
+	if e.Operation == OpPutWithExpiry {
+		if err := binary.Write(&body, ByteOrder, e.ExpiresAt); err != nil {
 			return nil, err
 		}
-		if err := binary.Write(w, ByteOrder, e.Value); err != nil {
+	}
+	if e.ColumnFamily != 0 {
+		if err := binary.Write(&body, ByteOrder, e.ColumnFamily); err != nil {
 			return nil, err
 		}
 	}
 
+	var buf bytes.Buffer
+	if err := WriteUint64(&buf, uint64(body.Len())); err != nil {
+		return nil, err
+	}
+	buf.Write(body.Bytes())
 	return buf.Bytes(), nil
 }
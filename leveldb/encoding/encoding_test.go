@@ -20,6 +20,45 @@ func TestDataEntry_Encode(t *testing.T) {
 				Value: Value("over easy"),
 			},
 		},
+		{
+			Operation: OpPutWithExpiry,
+			Entry: Entry{
+				Key:   Key("eggs"),
+				Value: Value("sunny side up"),
+			},
+			ExpiresAt: 1_700_000_000_000_000_000,
+		},
+		{
+			Operation: OpDeleteRange,
+			Entry: Entry{
+				Key:   Key("eggs"),
+				Value: Value("ham"),
+			},
+		},
+		{
+			Operation: OpMerge,
+			Entry: Entry{
+				Key:   Key("eggs"),
+				Value: Value("+1"),
+			},
+		},
+		{
+			Operation: OpPutWithExpiry,
+			Entry: Entry{
+				Key:   Key("eggs"),
+				Value: Value("poached"),
+			},
+			ExpiresAt:    1_700_000_000_000_000_000,
+			ColumnFamily: 3,
+		},
+		{
+			// lengths that take more than one byte as uvarints
+			Operation: OpPut,
+			Entry: Entry{
+				Key:   bytes.Repeat(Key("e"), 300),
+				Value: bytes.Repeat(Value("g"), 70_000),
+			},
+		},
 	}
 	for _, entry := range entries {
 		t.Run(entry.Operation.String(), func(t *testing.T) {
@@ -41,6 +80,85 @@ func TestDataEntry_Encode(t *testing.T) {
 			if !bytes.Equal(entry.Value, decodedEntry.Value) {
 				t.Errorf("values do not match.  expected %q, got %q", entry.Value, decodedEntry.Value)
 			}
+			if entry.ExpiresAt != decodedEntry.ExpiresAt {
+				t.Errorf("expiries do not match.  expected %d, got %d", entry.ExpiresAt, decodedEntry.ExpiresAt)
+			}
+			if entry.ColumnFamily != decodedEntry.ColumnFamily {
+				t.Errorf("column families do not match.  expected %d, got %d", entry.ColumnFamily, decodedEntry.ColumnFamily)
+			}
 		})
 	}
 }
+
+func TestDbOperation_EncodeVarintLengths(t *testing.T) {
+	var op = DbOperation{Operation: OpPut, Entry: Entry{Key: Key("eggs"), Value: Value("over easy")}}
+	encoded, err := op.Encode()
+	if err != nil {
+		t.Fatal("error encoding DbOperation:", err)
+	}
+	// the total length, the opcode, then each of the key and value behind a one-byte length
+	if expected := 8 + 1 + 1 + len(op.Key) + 1 + len(op.Value); len(encoded) != expected {
+		t.Errorf("expected %d bytes encoded, got %d", expected, len(encoded))
+	}
+
+	t.Run("KeyOverrunsRecord", func(t *testing.T) {
+		var payload = bytes.Clone(encoded[8:])
+		payload[1] = 0x7f
+		if err := new(DbOperation).Decode(payload); err == nil {
+			t.Error("expected error decoding a key longer than its record, did not get one")
+		}
+	})
+	t.Run("ValueOverrunsRecord", func(t *testing.T) {
+		var payload = bytes.Clone(encoded[8:])
+		payload[2+len(op.Key)] = 0x7f
+		if err := new(DbOperation).Decode(payload); err == nil {
+			t.Error("expected error decoding a value longer than its record, did not get one")
+		}
+	})
+}
+
+func TestDbOperation_EncodeBatch(t *testing.T) {
+	var batch = DbOperation{
+		Operation: OpBatch,
+		Batch: []*DbOperation{
+			{Operation: OpPut, Entry: Entry{Key: Key("eggs"), Value: Value("over easy")}},
+			{Operation: OpDelete, Entry: Entry{Key: Key("spam")}, ColumnFamily: 2},
+			{Operation: OpPut, Entry: Entry{Key: Key("toast"), Value: Value("buttered")}},
+		},
+	}
+	encoded, err := batch.Encode()
+	if err != nil {
+		t.Fatal("error encoding batch:", err)
+	}
+	decoded := new(DbOperation)
+	if err := decoded.Decode(encoded[8:]); err != nil {
+		t.Fatal("error decoding encoded batch:", err)
+	}
+	if decoded.Operation != OpBatch || len(decoded.Batch) != len(batch.Batch) {
+		t.Fatalf("expected a batch of %d operations, got %s of %d", len(batch.Batch), decoded.Operation, len(decoded.Batch))
+	}
+	for j, op := range batch.Batch {
+		var got = decoded.Batch[j]
+		if got.Operation != op.Operation || !bytes.Equal(got.Key, op.Key) || !bytes.Equal(got.Value, op.Value) ||
+			got.ColumnFamily != op.ColumnFamily {
+			t.Errorf(
+				"expected operation %d to be %s %q %q in family %d, got %s %q %q in family %d",
+				j,
+				op.Operation,
+				op.Key,
+				op.Value,
+				op.ColumnFamily,
+				got.Operation,
+				got.Key,
+				got.Value,
+				got.ColumnFamily,
+			)
+		}
+	}
+
+	t.Run("Truncated", func(t *testing.T) {
+		if err := new(DbOperation).Decode(encoded[8 : len(encoded)-4]); err == nil {
+			t.Error("expected error decoding truncated batch, did not get one")
+		}
+	})
+}
@@ -14,6 +14,8 @@ type Key []byte
 func (k Key) String() string {
 	return string(k)
 }
+
+// Compare orders keys bytewise, as BytewiseComparator does.
 func (k Key) Compare(other Key) int { return bytes.Compare(k, other) }
 
 func (k Key) Encode() ([]byte, error) {
@@ -45,37 +47,166 @@ type DataEntry struct {
 
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrKeyTombstoned signals that a key was found, but as a deletion marker.  It wraps ErrKeyNotFound so that callers
+// only interested in presence can ignore the distinction, while layered lookups know to stop searching older data.
+var ErrKeyTombstoned = fmt.Errorf("%w: tombstoned", ErrKeyNotFound)
+
 func NewNotFoundError(key Key) error {
 	return fmt.Errorf("%q: %w", key, ErrKeyNotFound)
 }
 
+func NewTombstonedError(key Key) error {
+	return fmt.Errorf("%q: %w", key, ErrKeyTombstoned)
+}
+
 type ReadOnlyDB interface {
 	// Get gets the value for the given key.  It returns an error if the
 	// DB does not contain the key.
-	Get(key Key) (Value, error)
+	Get(key Key, opts ...ReadOption) (Value, error)
 
 	// Has returns true if the DB contains the given key.
-	Has(key Key) (bool, error)
+	Has(key Key, opts ...ReadOption) (bool, error)
 
 	// RangeScan returns an Iterator (see below) for scanning through all
-	// key-value pairs in the given range, ordered by key ascending.
-	RangeScan(start Key, limit Key) (Iterator, error)
+	// key-value pairs in the given range, ordered by key ascending.  The
+	// Iterator sees the DB as it was when RangeScan was called.  The
+	// caller closes the Iterator once done with it.
+	RangeScan(start Key, limit Key, opts ...ReadOption) (Iterator, error)
 }
+
+// DB holds the operations every implementation supports.  Those only some implement, such as range deletes, values
+// that expire and merges, are interfaces of their own in package db, which callers assert a DB to.  A WriteBatch holds
+// every kind of write all the same, and Write rejects those its DB does not support.
 type DB interface {
 	ReadOnlyDB
 	// Put sets the value for the given key.  It overwrites any previous value
 	// for that key; a DB is not a multi-map.
-	Put(key Key, value Value) error
+	Put(key Key, value Value, opts ...WriteOption) error
 
 	// Delete deletes the value for the given key.
-	Delete(key Key) error
+	Delete(key Key, opts ...WriteOption) error
+
+	// Write applies every put and delete in the batch atomically, in order.
+	Write(batch *WriteBatch, opts ...WriteOption) error
+
+	// GetSnapshot returns a handle on the current state of the DB, for reads given WithSnapshot.
+	GetSnapshot() Snapshot
+
+	// Close releases any resources held by the DB.  The DB must not be used afterward.
+	Close() error
+}
+
+// WriteOptions configure a single write to a DB.
+type WriteOptions struct {
+	// Sync has the write synced to stable storage before it returns, whatever the DB's sync policy.  A write that is
+	// not synced survives the process crashing once it returns, but may be lost if the machine does.
+	Sync bool
+
+	// ColumnFamily is the ID of the column family a single put or delete goes to, 0 being the default one.  The writes
+	// in a WriteBatch go to the families the batch sets instead.
+	ColumnFamily uint32
+}
+
+// WriteOption sets a field of WriteOptions.
+type WriteOption func(*WriteOptions)
+
+// WithSync sets WriteOptions.Sync.
+func WithSync(sync bool) WriteOption {
+	return func(opts *WriteOptions) {
+		opts.Sync = sync
+	}
+}
+
+// ToColumnFamily sets WriteOptions.ColumnFamily.
+func ToColumnFamily(id uint32) WriteOption {
+	return func(opts *WriteOptions) {
+		opts.ColumnFamily = id
+	}
+}
+
+// NewWriteOptions returns the WriteOptions resulting from applying opts in order.
+func NewWriteOptions(opts ...WriteOption) WriteOptions {
+	var writeOptions WriteOptions
+	for _, opt := range opts {
+		opt(&writeOptions)
+	}
+	return writeOptions
+}
+
+// Snapshot is a point-in-time view of a DB.  Reads given it with WithSnapshot see the DB as it was when the snapshot
+// was taken, whatever has been written since.  It must be released once no longer needed, since the DB keeps the data
+// it sees until then.
+type Snapshot interface {
+	Release()
 }
 
+// ReadOptions configure a single read from a DB.
+type ReadOptions struct {
+	// Snapshot has the read see the DB as of the snapshot, rather than as it is now.
+	Snapshot Snapshot
+
+	// BypassCache has the read leave the blocks it reads from disk out of the block cache, so that a one-off scan does
+	// not evict the blocks other reads keep coming back to.  Blocks already cached are still read from it.
+	BypassCache bool
+
+	// ColumnFamily is the ID of the column family to read from, 0 being the default one.
+	ColumnFamily uint32
+}
+
+// ReadOption sets a field of ReadOptions.
+type ReadOption func(*ReadOptions)
+
+// WithSnapshot sets ReadOptions.Snapshot.
+func WithSnapshot(snapshot Snapshot) ReadOption {
+	return func(opts *ReadOptions) {
+		opts.Snapshot = snapshot
+	}
+}
+
+// WithBypassCache sets ReadOptions.BypassCache.
+func WithBypassCache(bypass bool) ReadOption {
+	return func(opts *ReadOptions) {
+		opts.BypassCache = bypass
+	}
+}
+
+// FromColumnFamily sets ReadOptions.ColumnFamily.
+func FromColumnFamily(id uint32) ReadOption {
+	return func(opts *ReadOptions) {
+		opts.ColumnFamily = id
+	}
+}
+
+// NewReadOptions returns the ReadOptions resulting from applying opts in order.
+func NewReadOptions(opts ...ReadOption) ReadOptions {
+	var readOptions ReadOptions
+	for _, opt := range opts {
+		opt(&readOptions)
+	}
+	return readOptions
+}
+
+// Iterator ranges over the key/value pairs of a key range in order, in either direction.  It starts out before the
+// first pair, so that Next moves to it and Prev returns false.  Moving past either end leaves the iterator just beyond
+// it, from where the opposite move comes back to the pair at that end.
 type Iterator interface {
 	// Next moves the iterator to the next key/value pair.
 	// It returns false if the iterator is exhausted
 	Next() bool
 
+	// Prev moves the iterator to the previous key/value pair.  It returns false if there is none.
+	Prev() bool
+
+	// Seek moves the iterator to the first key/value pair whose key is at or after key, or to the first pair in range
+	// if key is before the range.  It returns false if there is none.
+	Seek(key Key) bool
+
+	// SeekToFirst moves the iterator to the first key/value pair in range, returning false if there is none.
+	SeekToFirst() bool
+
+	// SeekToLast moves the iterator to the last key/value pair in range, returning false if there is none.
+	SeekToLast() bool
+
 	// Error returns any accumulated error.  Exhausting all the key/value pairs =
 	// is not considered to be an error.
 	Error() error
@@ -85,4 +216,8 @@ type Iterator interface {
 
 	// Value returns the value of the current key/value pair, or nil if done.
 	Value() Value
+
+	// Close releases what the iterator holds, such as the files it reads from, as soon as the caller is done with it.
+	// The iterator must not be used once closed; closing it again does nothing.
+	Close() error
 }
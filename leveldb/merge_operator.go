@@ -0,0 +1,14 @@
+package leveldb
+
+// MergeOperator combines the operands written to a key with Merge into its value, so that a read-modify-write such as
+// incrementing a counter or appending to a list is a single write, with no read first.  Operands are stacked as they
+// are written and only combined when the key is read, or when compaction rewrites them.
+type MergeOperator interface {
+	// Merge returns the value resulting from applying operands, oldest first, to existing, which is nil if the key has
+	// no value.  It may be called with any run of a key's operands, but always with the value they were written over,
+	// so it need not be associative.  An error fails the read or compaction doing the merge.
+	Merge(key Key, existing Value, operands []Value) (Value, error)
+
+	// Name identifies the operator.
+	Name() string
+}
@@ -0,0 +1,218 @@
+package skiplist
+
+import (
+	"errors"
+	"leveldb"
+	"sync/atomic"
+)
+
+// List is implemented by SkipList and ConcurrentSkipList.
+type List interface {
+	Insert(searchKey leveldb.Key, newValue leveldb.Value) error
+	Search(searchKey leveldb.Key) (leveldb.Value, error)
+	TraverseUntil(key leveldb.Key, listener func(level, Node)) (Node, error)
+	Last() Node
+	Size() uint64
+}
+
+// ConcurrentSkipList is a skip list that one writer may insert into while any number of readers search and traverse
+// it, without locks.  Writers must still be serialized by the caller.
+//
+// A new node is built in full before it is linked in, then published level by level from the bottom up, each forward
+// pointer with an atomic store; readers load forward pointers atomically, so they see the node either not at all or
+// complete.  A reader racing an insert may find the node at some levels and not others, which only costs it a few
+// extra steps, since every level it can reach is a sorted sublist of the one below.  Nodes are never removed, so a
+// reader can keep following a node it has found however many inserts follow, and replacing a key's value is an atomic
+// store as well.
+type ConcurrentSkipList struct {
+	header *concurrentNode
+	// level is the highest level of any node, stored atomically so that readers know where to start
+	level      atomic.Uint32
+	numEntries atomic.Uint64
+	numBytes   atomic.Uint64
+	compare    func(a, b leveldb.Key) int
+}
+
+func NewConcurrentSkipList() *ConcurrentSkipList {
+	return NewConcurrentSkipListWithKeyComparison(leveldb.Key.Compare)
+}
+
+// NewConcurrentSkipListWithKeyComparison builds a ConcurrentSkipList ordering its keys by compare rather than
+// bytewise.
+func NewConcurrentSkipListWithKeyComparison(compare func(a, b leveldb.Key) int) *ConcurrentSkipList {
+	var sl = &ConcurrentSkipList{
+		header:  newConcurrentNode(nil, nil, maxLevel, compare),
+		compare: compare,
+	}
+	sl.level.Store(1)
+	return sl
+}
+
+// Search returns the value of searchKey, or a NotFoundError if it is not in the list.
+func (sl *ConcurrentSkipList) Search(searchKey leveldb.Key) (leveldb.Value, error) {
+	currentNode, err := sl.TraverseUntil(searchKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	currentNode = currentNode.Next()
+	if currentNode.CompareKey(searchKey) == 0 {
+		return currentNode.Value(), nil
+	}
+	return nil, leveldb.NewNotFoundError(searchKey)
+}
+
+// Insert adds the key, or replaces its value if it is already present; see SkipList.Insert.  Only one goroutine may
+// insert at a time.
+func (sl *ConcurrentSkipList) Insert(searchKey leveldb.Key, newValue leveldb.Value) error {
+	if len(searchKey) == 0 {
+		return errors.New("cannot insert blank key")
+	}
+
+	var lastNodeTraversedPerLevel = forwardList{}
+	currentNode, err := sl.TraverseUntil(searchKey, lastNodeTraversedPerLevel.setLevel)
+	if err != nil {
+		return err
+	}
+	currentNode = currentNode.Next()
+	if currentNode.CompareKey(searchKey) == 0 {
+		var oldValueLen = len(currentNode.Value())
+		if err := currentNode.SetValue(newValue); err != nil {
+			return err
+		}
+		sl.numBytes.Add(uint64(len(newValue)) - uint64(oldValueLen))
+		return nil
+	}
+
+	var (
+		insertionLevel = randomLevel()
+		listLevel      = level(sl.level.Load())
+	)
+	if insertionLevel > listLevel {
+		for lvl := listLevel + 1; lvl <= insertionLevel; lvl++ {
+			lastNodeTraversedPerLevel.setLevel(lvl, sl.header)
+		}
+		// readers starting from the new levels before the node is linked in find them empty, and move down
+		sl.level.Store(uint32(insertionLevel))
+	}
+	var newNode = newConcurrentNode(searchKey, newValue, insertionLevel, sl.compare)
+	for lvl := level(1); lvl <= insertionLevel; lvl++ {
+		// newNode is not yet reachable, so it can point forward before it is pointed to
+		var nodeToUpdate = lastNodeTraversedPerLevel.getLevel(lvl)
+		if err := newNode.SetForwardNodeAtLevel(lvl, nodeToUpdate.ForwardNodeAtLevel(lvl)); err != nil {
+			return err
+		}
+		if err := nodeToUpdate.SetForwardNodeAtLevel(lvl, newNode); err != nil {
+			return err
+		}
+	}
+
+	sl.numEntries.Add(1)
+	sl.numBytes.Add(uint64(len(searchKey) + len(newValue)))
+	return nil
+}
+
+// Last returns the node holding the greatest key in the list, or NilNode if the list is empty.
+func (sl *ConcurrentSkipList) Last() Node {
+	var currentNode Node = sl.header
+	for currentLevel := level(sl.level.Load()); currentLevel > 0; currentLevel-- {
+		for currentNode.ForwardNodeAtLevel(currentLevel) != NilNode {
+			currentNode = currentNode.ForwardNodeAtLevel(currentLevel)
+		}
+	}
+	if currentNode == Node(sl.header) {
+		return NilNode
+	}
+	return currentNode
+}
+
+// Size returns the number of key and value bytes held by the list; see SkipList.Size.
+func (sl *ConcurrentSkipList) Size() uint64 {
+	return sl.numBytes.Load()
+}
+
+// TraverseUntil returns the node just before the desired key, whether or not that key exists; see
+// SkipList.TraverseUntil.  Run alongside an insert, it may or may not see the node being inserted.
+func (sl *ConcurrentSkipList) TraverseUntil(key leveldb.Key, listener func(level, Node)) (Node, error) {
+	var currentNode Node = sl.header
+	for currentLevel := level(sl.level.Load()); currentLevel > 0; currentLevel-- {
+		for {
+			var nextNode = currentNode.ForwardNodeAtLevel(currentLevel)
+			if nextNode.CompareKey(key) >= 0 {
+				break
+			}
+			currentNode = nextNode
+		}
+		if listener != nil {
+			listener(currentLevel, currentNode)
+		}
+	}
+	return currentNode, nil
+}
+
+// concurrentNode is a node of a ConcurrentSkipList.  Its key never changes, while its value and forward pointers are
+// loaded and stored atomically.
+type concurrentNode struct {
+	key   leveldb.Key
+	value atomic.Pointer[leveldb.Value]
+	// forwardNodes has one entry per level the node is linked into; nil stands for NilNode
+	forwardNodes []atomic.Pointer[concurrentNode]
+	compare      func(a, b leveldb.Key) int
+}
+
+func newConcurrentNode(
+	key leveldb.Key,
+	value leveldb.Value,
+	height level,
+	compare func(a, b leveldb.Key) int,
+) *concurrentNode {
+	var node = &concurrentNode{
+		key:          key,
+		forwardNodes: make([]atomic.Pointer[concurrentNode], height),
+		compare:      compare,
+	}
+	node.value.Store(&value)
+	return node
+}
+
+func (cn *concurrentNode) CompareKey(k leveldb.Key) int {
+	if k == nil {
+		return 1 // see Node.CompareKey
+	}
+	return cn.compare(cn.key, k)
+}
+
+func (cn *concurrentNode) Key() leveldb.Key { return cn.key }
+
+func (cn *concurrentNode) Value() leveldb.Value { return *cn.value.Load() }
+
+func (cn *concurrentNode) SetValue(value leveldb.Value) error {
+	if len(value) == 0 {
+		return errors.New("setting empty value is not allowed")
+	}
+	cn.value.Store(&value)
+	return nil
+}
+
+func (cn *concurrentNode) Next() Node { return cn.ForwardNodeAtLevel(1) }
+
+// ForwardNodeAtLevel returns the next node at the given level, which must not be above the node's own.
+func (cn *concurrentNode) ForwardNodeAtLevel(lvl level) Node {
+	if next := cn.forwardNodes[lvl-1].Load(); next != nil {
+		return next
+	}
+	return NilNode
+}
+
+// SetForwardNodeAtLevel publishes node as the next one at the given level, which must not be above the node's own.
+func (cn *concurrentNode) SetForwardNodeAtLevel(lvl level, node Node) error {
+	if node == NilNode {
+		cn.forwardNodes[lvl-1].Store(nil)
+		return nil
+	}
+	next, ok := node.(*concurrentNode)
+	if !ok {
+		return errors.New("cannot link a node from another kind of list")
+	}
+	cn.forwardNodes[lvl-1].Store(next)
+	return nil
+}
@@ -0,0 +1,134 @@
+package skiplist
+
+import (
+	"bytes"
+	"fmt"
+	"leveldb"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentSkipList(t *testing.T) {
+	testData := []leveldb.DataEntry{
+		{Key: leveldb.Key("foo"), Value: leveldb.Value("bar")},
+		{Key: leveldb.Key("bizz"), Value: leveldb.Value("buzz")},
+		{Key: leveldb.Key("jamb"), Value: leveldb.Value("lamb")},
+		{Key: leveldb.Key("ball"), Value: leveldb.Value("fall")},
+		{Key: leveldb.Key("sun"), Value: leveldb.Value("moon")},
+		{Key: leveldb.Key("cloud"), Value: leveldb.Value("sky")},
+	}
+	sl := NewConcurrentSkipList()
+	if last := sl.Last(); last != NilNode {
+		t.Fatalf("expected NilNode for empty list, got %q", last.Key())
+	}
+	for _, datum := range testData {
+		if err := sl.Insert(datum.Key, datum.Value); err != nil {
+			t.Fatalf(insertError, datum, err)
+		}
+	}
+
+	t.Run("Search", func(t *testing.T) {
+		for _, datum := range testData {
+			searchResult, err := sl.Search(datum.Key)
+			if err != nil {
+				t.Fatal("unexpected error calling ConcurrentSkipList.Search()", err)
+			}
+			if !bytes.Equal(searchResult, datum.Value) {
+				t.Fatalf("expected search result to be %q, got %q", datum.Value, searchResult)
+			}
+		}
+		if _, err := sl.Search(leveldb.Key("nope")); err == nil {
+			t.Error("expected error searching for a missing key, did not get one")
+		}
+	})
+	t.Run("TraverseUntil", func(t *testing.T) {
+		precedingNode, err := sl.TraverseUntil(leveldb.Key("c"), nil)
+		if err != nil {
+			t.Fatal("unexpected error calling ConcurrentSkipList.TraverseUntil()", err)
+		}
+		var keys []string
+		for node := precedingNode.Next(); node != NilNode; node = node.Next() {
+			keys = append(keys, string(node.Key()))
+		}
+		if fmt.Sprint(keys) != "[cloud foo jamb sun]" {
+			t.Errorf("expected the keys from %q on in order, got %v", "c", keys)
+		}
+	})
+	t.Run("Last", func(t *testing.T) {
+		if last := sl.Last(); last == NilNode || string(last.Key()) != "sun" {
+			t.Fatalf("expected last key to be %q", "sun")
+		}
+	})
+	t.Run("Size", func(t *testing.T) {
+		var size = sl.Size()
+		if err := sl.Insert(leveldb.Key("foo"), leveldb.Value("longer")); err != nil {
+			t.Fatalf(insertError, "foo", err)
+		}
+		if sl.Size() != size+3 {
+			t.Errorf("expected updating a value to grow the size from %d by 3, got %d", size, sl.Size())
+		}
+	})
+}
+
+// TestConcurrentSkipList_ReadersAlongsideWriter has readers traverse the list while a writer inserts into it, checking
+// that every traversal finds the keys in order and never loses a key it found before.  Run it with -race.
+func TestConcurrentSkipList_ReadersAlongsideWriter(t *testing.T) {
+	const numKeys, numReaders = 2000, 4
+	var (
+		sl   = NewConcurrentSkipList()
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+	for r := 0; r < numReaders; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastCount int
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				header, _ := sl.TraverseUntil(nil, nil)
+				var (
+					count   int
+					lastKey leveldb.Key
+				)
+				for node := header.Next(); node != NilNode; node = node.Next() {
+					if lastKey != nil && bytes.Compare(node.Key(), lastKey) <= 0 {
+						t.Errorf("found %q after %q", node.Key(), lastKey)
+						return
+					}
+					if !bytes.Equal(node.Value(), node.Key()) {
+						t.Errorf("found %q with value %q", node.Key(), node.Value())
+						return
+					}
+					lastKey = node.Key()
+					count++
+				}
+				if count < lastCount {
+					t.Errorf("found %d keys after finding %d", count, lastCount)
+					return
+				}
+				lastCount = count
+			}
+		}()
+	}
+	for j := 0; j < numKeys; j++ {
+		// spread the keys out, so that they are inserted all over the list
+		var key = leveldb.Key(fmt.Sprintf("%04d", (j*7919)%numKeys))
+		if err := sl.Insert(key, leveldb.Value(key)); err != nil {
+			t.Fatalf(insertError, key, err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	for j := 0; j < numKeys; j++ {
+		var key = leveldb.Key(fmt.Sprintf("%04d", j))
+		if _, err := sl.Search(key); err != nil {
+			t.Errorf("expected to find %q, got error %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package skiplist
+
+import "leveldb"
+
+// Iterator iterates over the entries of a List with keys in [start, limit].  It follows forward pointers to move on,
+// and searches the list again to move back, since nodes do not point backward.  Over a ConcurrentSkipList, it may be
+// used alongside an insert, and may or may not see the entry being inserted.
+type Iterator struct {
+	list List
+	// header is the node before every entry, which a search returns when no entry comes before its key
+	header       Node
+	start, limit leveldb.Key
+	// current is the node the iterator is at, or nil if it is before its first entry or, if afterLast is set, after
+	// its last
+	current   Node
+	afterLast bool
+}
+
+// NewIterator returns an iterator over the entries of list with keys in [start, limit], positioned before the first.
+func NewIterator(list List, start leveldb.Key, limit leveldb.Key) (*Iterator, error) {
+	header, err := list.TraverseUntil(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Iterator{list: list, header: header, start: start, limit: limit}, nil
+}
+
+func (i *Iterator) Next() bool {
+	switch {
+	case i.afterLast:
+		return false
+	case i.current == nil:
+		return i.SeekToFirst()
+	}
+	return i.moveTo(i.current.Next())
+}
+
+func (i *Iterator) Prev() bool {
+	switch {
+	case i.afterLast:
+		return i.SeekToLast()
+	case i.current == nil:
+		return false
+	}
+	precedingNode, err := i.list.TraverseUntil(i.current.Key(), nil)
+	if err != nil {
+		return i.moveTo(nil) // TraverseUntil does not fail in practice; should it, give up
+	}
+	return i.moveBackTo(precedingNode)
+}
+
+// Seek moves to the first entry with a key at or after key, or to the first entry if key is before start.
+func (i *Iterator) Seek(key leveldb.Key) bool {
+	precedingNode, err := i.list.TraverseUntil(key, nil)
+	if err != nil {
+		return i.moveTo(nil)
+	}
+	// an entry before start means key is too, so search again from start
+	if node := precedingNode.Next(); node != NilNode && node.CompareKey(i.start) < 0 {
+		if precedingNode, err = i.list.TraverseUntil(i.start, nil); err != nil {
+			return i.moveTo(nil)
+		}
+	}
+	return i.moveTo(precedingNode.Next())
+}
+
+func (i *Iterator) SeekToFirst() bool {
+	return i.Seek(i.start)
+}
+
+func (i *Iterator) SeekToLast() bool {
+	precedingNode, err := i.list.TraverseUntil(i.limit, nil)
+	if err != nil {
+		return i.moveTo(nil)
+	}
+	// the search stops before limit, which is itself in range
+	if node := precedingNode.Next(); node.CompareKey(i.limit) == 0 {
+		precedingNode = node
+	}
+	return i.moveBackTo(precedingNode)
+}
+
+// moveTo moves forward to node, or after the last entry if node is out of range.
+func (i *Iterator) moveTo(node Node) bool {
+	if node == nil || node == NilNode || node.CompareKey(i.limit) > 0 {
+		i.current, i.afterLast = nil, true
+		return false
+	}
+	i.current, i.afterLast = node, false
+	return true
+}
+
+// moveBackTo moves back to node, or before the first entry if node is the header or out of range.
+func (i *Iterator) moveBackTo(node Node) bool {
+	if node == i.header || node.CompareKey(i.start) < 0 {
+		i.current, i.afterLast = nil, false
+		return false
+	}
+	i.current, i.afterLast = node, false
+	return true
+}
+
+func (i *Iterator) Error() error {
+	return nil
+}
+
+func (i *Iterator) Key() leveldb.Key {
+	if i.current == nil {
+		return nil
+	}
+	return i.current.Key()
+}
+
+func (i *Iterator) Value() leveldb.Value {
+	if i.current == nil {
+		return nil
+	}
+	return i.current.Value()
+}
+
+// Close does nothing, since the iterator holds no more than references into the list.
+func (i *Iterator) Close() error {
+	return nil
+}
@@ -0,0 +1,74 @@
+package skiplist
+
+import (
+	"fmt"
+	"leveldb"
+	"testing"
+)
+
+func TestIterator(t *testing.T) {
+	for _, list := range []struct {
+		name string
+		list List
+	}{
+		{name: "SkipList", list: NewSkipList()},
+		{name: "ConcurrentSkipList", list: NewConcurrentSkipList()},
+	} {
+		t.Run(list.name, func(t *testing.T) {
+			for _, key := range []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot"} {
+				if err := list.list.Insert(leveldb.Key(key), leveldb.Value(key)); err != nil {
+					t.Fatalf(insertError, key, err)
+				}
+			}
+			iterator, err := NewIterator(list.list, leveldb.Key("b"), leveldb.Key("echo"))
+			if err != nil {
+				t.Fatal("unexpected error calling NewIterator()", err)
+			}
+
+			t.Run("Forward", func(t *testing.T) {
+				var keys []string
+				for ok := iterator.SeekToFirst(); ok; ok = iterator.Next() {
+					keys = append(keys, string(iterator.Key()))
+				}
+				if fmt.Sprint(keys) != "[bravo charlie delta echo]" {
+					t.Errorf("expected the keys in range first to last, got %v", keys)
+				}
+				if iterator.Next() || iterator.Key() != nil {
+					t.Errorf("expected Next() past the last key to stay there, got %q", iterator.Key())
+				}
+			})
+			t.Run("Backward", func(t *testing.T) {
+				var keys []string
+				for iterator.Prev() {
+					keys = append(keys, string(iterator.Key()))
+				}
+				if fmt.Sprint(keys) != "[echo delta charlie bravo]" {
+					t.Errorf("expected Prev() from past the last key to go back over the range, got %v", keys)
+				}
+				if !iterator.Next() || string(iterator.Key()) != "bravo" {
+					t.Errorf("expected Next() from before the first key to find %q, got %q", "bravo", iterator.Key())
+				}
+			})
+			t.Run("Seek", func(t *testing.T) {
+				for _, seek := range []struct {
+					key, expected string
+				}{
+					{key: "alpha", expected: "bravo"},
+					{key: "charlie", expected: "charlie"},
+					{key: "cobra", expected: "delta"},
+					{key: "echo", expected: "echo"},
+				} {
+					if !iterator.Seek(leveldb.Key(seek.key)) || string(iterator.Key()) != seek.expected {
+						t.Errorf("expected Seek(%q) to find %q, got %q", seek.key, seek.expected, iterator.Key())
+					}
+				}
+				if iterator.Seek(leveldb.Key("eel")) {
+					t.Errorf("expected Seek() past the range to find nothing, got %q", iterator.Key())
+				}
+				if !iterator.SeekToLast() || string(iterator.Key()) != "echo" {
+					t.Errorf("expected SeekToLast() to find %q, got %q", "echo", iterator.Key())
+				}
+			})
+		})
+	}
+}
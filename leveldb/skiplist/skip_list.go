@@ -26,6 +26,8 @@ type SkipList struct {
 	header     Node
 	level      level
 	numEntries uint64
+	numBytes   uint64
+	compare    func(a, b leveldb.Key) int
 }
 
 // NewSkipList builds a SkipList with the appropriate state.
@@ -34,9 +36,15 @@ type SkipList struct {
 // A new list is initialized so that the level of the list is equal to 1 and
 // all forward pointers of the header
 func NewSkipList() *SkipList {
+	return NewSkipListWithKeyComparison(leveldb.Key.Compare)
+}
+
+// NewSkipListWithKeyComparison builds a SkipList ordering its keys by compare rather than bytewise.
+func NewSkipListWithKeyComparison(compare func(a, b leveldb.Key) int) *SkipList {
 	return &SkipList{
-		header: newHeaderNode(),
-		level:  1, // should this be one or zero?
+		header:  newHeaderNode(compare),
+		level:   1, // should this be one or zero?
+		compare: compare,
 	}
 }
 
@@ -91,9 +99,12 @@ func (sl *SkipList) Insert(searchKey leveldb.Key, newValue leveldb.Value) error
 	}
 	currentNode = currentNode.Next()
 	if currentNode.CompareKey(searchKey) == 0 {
+		var oldValueLen = len(currentNode.Value())
 		if err = currentNode.SetValue(newValue); err != nil {
 			return err
 		}
+		sl.numBytes += uint64(len(newValue))
+		sl.numBytes -= uint64(oldValueLen)
 		return nil
 	}
 
@@ -104,7 +115,7 @@ func (sl *SkipList) Insert(searchKey leveldb.Key, newValue leveldb.Value) error
 		}
 		sl.level = insertionLevel
 	}
-	newNode := newValueNode(searchKey, newValue)
+	newNode := newValueNode(searchKey, newValue, sl.compare)
 	for lvl := level(1); lvl <= insertionLevel; lvl++ {
 		nodeToUpdate := lastNodeTraversedPerLevel.getLevel(lvl)
 		if nodeToUpdate == nil {
@@ -125,6 +136,7 @@ func (sl *SkipList) Insert(searchKey leveldb.Key, newValue leveldb.Value) error
 	}
 
 	sl.numEntries++
+	sl.numBytes += uint64(len(searchKey) + len(newValue))
 
 	return nil
 }
@@ -155,7 +167,7 @@ func (sl *SkipList) Delete(searchKey leveldb.Key) error {
 			}
 		}
 		sl.numEntries--
-
+		sl.numBytes -= uint64(len(currentNode.Key()) + len(currentNode.Value()))
 	} /*else {
 		return leveldb.NewNotFoundError(searchKey)
 	}*/
@@ -175,10 +187,31 @@ func (sl *SkipList) Scan(start, limit leveldb.Key) ([]leveldb.Value, error) {
 	return values, nil
 }
 
+// Last returns the node holding the greatest key in the list, or NilNode if the list is empty.
+func (sl *SkipList) Last() Node {
+	var currentNode = sl.header
+	for currentLevel := sl.level; currentLevel > 0; currentLevel-- {
+		for currentNode.ForwardNodeAtLevel(currentLevel) != NilNode {
+			currentNode = currentNode.ForwardNodeAtLevel(currentLevel)
+		}
+	}
+	if currentNode == sl.header {
+		return NilNode
+	}
+	return currentNode
+}
+
+// Size returns the number of key and value bytes held by the list.  It does not account for the overhead of nodes,
+// so it is best thought of as the amount of data that would be written out by flushing the list.
+func (sl *SkipList) Size() uint64 {
+	return sl.numBytes
+}
+
 func (sl *SkipList) Reset() error {
 	sl.level = 1
 	sl.numEntries = 0
-	sl.header = newHeaderNode() // forget all data
+	sl.numBytes = 0
+	sl.header = newHeaderNode(sl.compare) // forget all data
 	return nil
 }
 
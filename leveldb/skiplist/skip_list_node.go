@@ -1,14 +1,14 @@
 package skiplist
 
 import (
-	"bytes"
 	"errors"
 	"leveldb"
 )
 
 type Node interface {
 	// CompareKey returns an int whose meaning is like that of a Compare() function:
-	// -1 if less than, 0 if equal, 1 if greater than.
+	// -1 if less than, 0 if equal, 1 if greater than.  A nil key is less than every node's, whatever the list's
+	// ordering, so that TraverseUntil(nil, nil) finds the header.
 	CompareKey(key leveldb.Key) int
 	// Key returns the key of a node
 	Key() leveldb.Key
@@ -25,7 +25,7 @@ type Node interface {
 }
 
 // newValueNode does not do validation of the values being inserted, hence private to this package
-func newValueNode(key leveldb.Key, value leveldb.Value) *valueNode {
+func newValueNode(key leveldb.Key, value leveldb.Value, compare func(a, b leveldb.Key) int) *valueNode {
 	forwardNodes := forwardList{}
 	for j := range forwardNodes {
 		// consider a singleton
@@ -35,26 +35,29 @@ func newValueNode(key leveldb.Key, value leveldb.Value) *valueNode {
 		key:          key,
 		value:        value,
 		forwardNodes: forwardNodes,
+		compare:      compare,
 	}
 }
 
 // newHeaderNode() provides a Node that conforms to the description
 // of the "header" of a SkipList. Namely, its forwardList is full of NIL
 // nodes, and its key is less than any other valid key.
-func newHeaderNode() *valueNode {
+func newHeaderNode(compare func(a, b leveldb.Key) int) *valueNode {
 	/**
 	 * An element NIL is allocated and given a key greater than any legal key.
 	 * All levels of all skip lists are terminated with NIL. A new list is
 	 * initialized so that the level of the list is equal to 1 and all forward
 	 * pointers of the list’s header point to NIL.
 	 */
-	return newValueNode(nil, nil)
+	return newValueNode(nil, nil, compare)
 }
 
 type valueNode struct {
 	key          leveldb.Key
 	value        leveldb.Value
 	forwardNodes forwardList
+	// compare is the ordering of the list the node belongs to
+	compare func(a, b leveldb.Key) int
 }
 
 func (vn *valueNode) SetForwardNodeAtLevel(lvl level, node Node) error {
@@ -64,7 +67,10 @@ func (vn *valueNode) SetForwardNodeAtLevel(lvl level, node Node) error {
 }
 
 func (vn *valueNode) CompareKey(k leveldb.Key) int {
-	return bytes.Compare(vn.key, k)
+	if k == nil {
+		return 1
+	}
+	return vn.compare(vn.key, k)
 }
 func (vn *valueNode) Next() Node           { return vn.ForwardNodeAtLevel(1) }
 func (vn *valueNode) Key() leveldb.Key     { return vn.key }
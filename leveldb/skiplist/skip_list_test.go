@@ -153,3 +153,42 @@ func TestSkipList_Scan(t *testing.T) {
 		t.Fatalf("expected values %v, got %v", expectedValues, values)
 	}
 }
+
+func TestSkipList_Size(t *testing.T) {
+	sl := NewSkipList()
+	steps := []struct {
+		name     string
+		apply    func() error
+		expected uint64
+	}{
+		{"Insert", func() error { return sl.Insert(leveldb.Key("foo"), leveldb.Value("bar")) }, 6},
+		{"InsertAnother", func() error { return sl.Insert(leveldb.Key("bizz"), leveldb.Value("buzz")) }, 14},
+		{"Update", func() error { return sl.Insert(leveldb.Key("foo"), leveldb.Value("longer")) }, 17},
+		{"Delete", func() error { return sl.Delete(leveldb.Key("bizz")) }, 9},
+		{"DeleteMissing", func() error { return sl.Delete(leveldb.Key("nope")) }, 9},
+		{"Reset", sl.Reset, 0},
+	}
+	for _, step := range steps {
+		if err := step.apply(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", step.name, err)
+		}
+		if size := sl.Size(); size != step.expected {
+			t.Errorf("%s: expected size %d, got %d", step.name, step.expected, size)
+		}
+	}
+}
+
+func TestSkipList_Last(t *testing.T) {
+	sl := NewSkipList()
+	if last := sl.Last(); last != NilNode {
+		t.Fatalf("expected NilNode for empty list, got %q", last.Key())
+	}
+	for _, key := range []string{"foo", "sun", "bizz", "jamb"} {
+		if err := sl.Insert(leveldb.Key(key), leveldb.Value(key)); err != nil {
+			t.Fatalf(insertError, key, err)
+		}
+	}
+	if last := sl.Last(); last == NilNode || string(last.Key()) != "sun" {
+		t.Fatalf("expected last key to be %q", "sun")
+	}
+}
@@ -0,0 +1,301 @@
+package sst
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"leveldb"
+	"sort"
+)
+
+/**
+ * block:
+ * | arbitrarily long | 1 byte       | 4 bytes                         |
+ * | [contents]       | [block type] | [crc32c of contents and type]   |
+ *
+ * , where a data block's contents are entries in key order, the index block's contents are entries mapping the largest
+ * key of each data block to its blockHandle, and the filter block's contents are a bloomFilter.  The block type
+ * records how the contents were compressed, if at all; see compression.go.  The checksum covers the contents as stored,
+ * so corruption is found before decompressing.
+ *
+ * contents (formatVersion3):
+ * | arbitrarily long | 4 bytes each         | 4 bytes              |
+ * | [entries]        | [restart offsets...] | [number of restarts] |
+ *
+ * entry:
+ * | varint         | varint           | varint      | arbitrary            | [0, arbitrary) |
+ * | [shared bytes] | [unshared bytes] | [value len] | [unshared key bytes] | (value)        |
+ *
+ * , where each key is stored as the number of bytes it shares with the previous key followed by the rest of it, and
+ * [value len] is 0 if key is tombstoned.  Every restartInterval entries the key is stored in full, and the offset of
+ * that entry is recorded as a restart point, so that a seek can binary search the restart points and then decode at
+ * most restartInterval entries.
+ *
+ * contents (formatVersion2), entries with full keys and no restart points:
+ * | 8 bytes   |  arbitrary |  8 bytes    |  [0, arbitrary) |
+ * | [key len] | [key]		| [value len] | (value) 	   |
+ */
+
+const defaultRestartInterval = 16
+
+// blockWriter accumulates the contents of a block.
+type blockWriter struct {
+	restartInterval int
+	buf             []byte
+	restarts        []uint32
+	// entries counts the entries since the last restart point
+	entries int
+	lastKey []byte
+}
+
+func (b *blockWriter) add(key []byte, value []byte) {
+	var shared int
+	if b.entries < b.restartInterval && len(b.restarts) > 0 {
+		for shared < min(len(key), len(b.lastKey)) && key[shared] == b.lastKey[shared] {
+			shared++
+		}
+	} else {
+		b.restarts, b.entries = append(b.restarts, uint32(len(b.buf))), 0
+	}
+	b.buf = binary.AppendUvarint(b.buf, uint64(shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(key)-shared))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(value)))
+	b.buf = append(b.buf, key[shared:]...)
+	b.buf = append(b.buf, value...)
+	b.lastKey = append(b.lastKey[:0], key...)
+	b.entries++
+}
+
+// size returns the size of the block's contents so far, restart points included.
+func (b *blockWriter) size() int {
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+// empty reports whether no entries have been added since the block was last finished.
+func (b *blockWriter) empty() bool {
+	return len(b.buf) == 0
+}
+
+// finish returns the block's contents, and resets the writer for the next block.
+func (b *blockWriter) finish() []byte {
+	if len(b.restarts) == 0 {
+		b.restarts = append(b.restarts, 0)
+	}
+	var contents = b.buf
+	for _, restart := range b.restarts {
+		contents = byteOrder.AppendUint32(contents, restart)
+	}
+	contents = byteOrder.AppendUint32(contents, uint32(len(b.restarts)))
+	b.buf, b.restarts, b.entries, b.lastKey = nil, nil, 0, nil
+	return contents
+}
+
+// encodeBlock compresses the contents with the given codec if that shrinks them, and appends the trailer.
+func encodeBlock(contents []byte, compression Compression) []byte {
+	stored, blockType := compressBlock(contents, compression)
+	var block = append(stored[:len(stored):len(stored)], blockType)
+	return byteOrder.AppendUint32(block, crc32.Checksum(block, crc32cTable))
+}
+
+// readBlock reads the block located by handle, and returns its contents once its checksum has been verified and it has
+// been decompressed.
+func readBlock(reader io.ReaderAt, handle blockHandle) ([]byte, error) {
+	var buf = make([]byte, handle.size+blockTrailerSize)
+	if _, err := reader.ReadAt(buf, int64(handle.offset)); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, newCorruptionError(int64(handle.offset), "block truncated")
+		}
+		return nil, err
+	}
+	var trailer = buf[handle.size:]
+	if crc32.Checksum(buf[:handle.size+1], crc32cTable) != byteOrder.Uint32(trailer[1:]) {
+		return nil, newCorruptionError(int64(handle.offset), "block checksum mismatch")
+	}
+	return decompressBlock(buf[:handle.size:handle.size], trailer[0], int64(handle.offset))
+}
+
+// blockIterator iterates over the entries of a block's contents.  Values share the block's memory, which is never
+// modified; keys are rebuilt for each entry, so remain valid after the iterator moves on.
+type blockIterator struct {
+	// data holds the block's entries, without its restart points
+	data []byte
+	// restarts holds the block's restart points, 4 bytes each.  Blocks written before formatVersion3 have none.
+	restarts         []byte
+	prefixCompressed bool
+	// blockOffset is the block's offset within the table, for reporting corruption
+	blockOffset int64
+	// current is where the current entry starts within data, and offset where the next one does
+	current int
+	offset  int
+	key     leveldb.Key
+	value   leveldb.Value
+	err     error
+}
+
+// newBlockIterator returns an iterator over a block's contents, as written in the given format version.
+func newBlockIterator(contents []byte, blockOffset int64, version uint64) *blockIterator {
+	var it = &blockIterator{data: contents, blockOffset: blockOffset}
+	if version < formatVersion3 {
+		return it
+	}
+	it.prefixCompressed = true
+	if len(contents) < 4 {
+		it.corrupt(0, "block too short for its restart points")
+		return it
+	}
+	var numRestarts = uint64(byteOrder.Uint32(contents[len(contents)-4:]))
+	if numRestarts*4 > uint64(len(contents)-4) {
+		it.corrupt(0, "block too short for its restart points")
+		return it
+	}
+	var restartsOffset = len(contents) - 4 - int(numRestarts)*4
+	it.data, it.restarts = contents[:restartsOffset], contents[restartsOffset:len(contents)-4]
+	return it
+}
+
+// next moves to the next entry, returning false once the block is exhausted or found corrupt.
+func (it *blockIterator) next() bool {
+	if it.err != nil || it.offset >= len(it.data) {
+		it.key, it.value = nil, nil
+		return false
+	}
+	var (
+		start      = it.offset
+		key, value []byte
+		ok         bool
+	)
+	if it.prefixCompressed {
+		key, value, ok = it.readPrefixCompressedEntry()
+	} else if key, ok = it.readSlice(); ok {
+		value, ok = it.readSlice()
+	}
+	if !ok {
+		return it.corrupt(start, "entry runs past the end of its block")
+	}
+	it.current, it.key, it.value = start, key, value
+	if len(value) == 0 {
+		it.value = nil // tombstone
+	}
+	return true
+}
+
+// seek moves to the first entry with a key greater than or equal to target in the order given by compare, returning
+// false if there is none.
+func (it *blockIterator) seek(target leveldb.Key, compare func(a, b leveldb.Key) int) bool {
+	it.offset, it.key = 0, nil
+	if it.prefixCompressed && it.err == nil {
+		// the first restart point with a key at or past target; the key is before it, if anywhere
+		var numRestarts = len(it.restarts) / 4
+		var after = sort.Search(numRestarts, func(j int) bool {
+			key, ok := it.restartKey(j)
+			return !ok || compare(key, target) >= 0 // stop at corruption, for next() to report it
+		})
+		if after > 0 {
+			it.offset = int(byteOrder.Uint32(it.restarts[4*(after-1):]))
+		}
+	}
+	for it.next() {
+		if compare(it.key, target) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// prev moves to the entry before the current one, returning false if the current entry is the block's first or the
+// block is found corrupt.  Entries can only be decoded forward, so it decodes on from the restart point before the
+// current entry, or from the start of a block without restart points.
+func (it *blockIterator) prev() bool {
+	var target = it.current
+	if it.err != nil || target == 0 {
+		it.key, it.value = nil, nil
+		return false
+	}
+	it.offset, it.key = 0, nil
+	if it.prefixCompressed {
+		// the first restart point at or past the current entry; the previous entry is after the one before it
+		var after = sort.Search(len(it.restarts)/4, func(j int) bool {
+			return int(byteOrder.Uint32(it.restarts[4*j:])) >= target
+		})
+		if after > 0 {
+			it.offset = int(byteOrder.Uint32(it.restarts[4*(after-1):]))
+		}
+	}
+	for it.next() {
+		if it.offset >= target {
+			return true
+		}
+	}
+	return false
+}
+
+// seekToLast moves to the block's last entry, returning false if it has none or is found corrupt.
+func (it *blockIterator) seekToLast() bool {
+	it.offset, it.key = 0, nil
+	if numRestarts := len(it.restarts) / 4; it.prefixCompressed && numRestarts > 0 {
+		it.offset = int(byteOrder.Uint32(it.restarts[4*(numRestarts-1):]))
+	}
+	for it.next() {
+		if it.offset >= len(it.data) {
+			return true
+		}
+	}
+	return false
+}
+
+// restartKey returns the key of the entry at the given restart point, which is stored in full.
+func (it *blockIterator) restartKey(j int) (leveldb.Key, bool) {
+	var offset = int(byteOrder.Uint32(it.restarts[4*j:]))
+	if offset >= len(it.data) {
+		return nil, false
+	}
+	var reader = &blockIterator{data: it.data, offset: offset}
+	key, _, ok := reader.readPrefixCompressedEntry()
+	return key, ok // the reader has no previous key, so this fails unless the entry shares nothing, as it must
+}
+
+// readPrefixCompressedEntry reads an entry from a formatVersion3 block, rebuilding its key from the previous one.
+func (it *blockIterator) readPrefixCompressedEntry() (key []byte, value []byte, ok bool) {
+	var lengths [3]uint64 // shared, unshared, value
+	for j := range lengths {
+		var n int
+		if lengths[j], n = binary.Uvarint(it.data[it.offset:]); n <= 0 {
+			return nil, nil, false
+		}
+		it.offset += n
+	}
+	var shared, unshared, valueLen = lengths[0], lengths[1], lengths[2]
+	if shared > uint64(len(it.key)) || unshared > uint64(len(it.data)-it.offset) ||
+		valueLen > uint64(len(it.data)-it.offset)-unshared {
+		return nil, nil, false
+	}
+	key = make([]byte, shared+unshared)
+	copy(key, it.key[:shared])
+	copy(key[shared:], it.data[it.offset:])
+	it.offset += int(unshared)
+	value = it.data[it.offset : it.offset+int(valueLen) : it.offset+int(valueLen)]
+	it.offset += int(valueLen)
+	return key, value, true
+}
+
+// readSlice reads a length-prefixed byte slice from a formatVersion2 block, returning false if it would run past the
+// end of the block.
+func (it *blockIterator) readSlice() ([]byte, bool) {
+	if len(it.data)-it.offset < 8 {
+		return nil, false
+	}
+	var length = byteOrder.Uint64(it.data[it.offset:])
+	if length > uint64(len(it.data)-it.offset-8) {
+		return nil, false
+	}
+	var start = it.offset + 8
+	it.offset = start + int(length)
+	return it.data[start:it.offset:it.offset], true
+}
+
+func (it *blockIterator) corrupt(entryOffset int, reason string) bool {
+	it.err = newCorruptionError(it.blockOffset+int64(entryOffset), reason)
+	it.key, it.value = nil, nil
+	return false
+}
@@ -0,0 +1,95 @@
+package sst
+
+import (
+	"errors"
+	"fmt"
+	"leveldb"
+	"testing"
+)
+
+func TestBlock_PrefixCompression(t *testing.T) {
+	var keys []string
+	for j := range 200 {
+		keys = append(keys, fmt.Sprintf("user:%05d:profile", 2*j))
+	}
+
+	for _, restartInterval := range []int{1, 4, defaultRestartInterval} {
+		t.Run(fmt.Sprintf("RestartInterval%d", restartInterval), func(t *testing.T) {
+			var (
+				writer   = blockWriter{restartInterval: restartInterval}
+				fullSize int
+			)
+			for _, key := range keys {
+				writer.add([]byte(key), []byte("v"))
+				fullSize += 8 + len(key) + 8 + 1 // as written in formatVersion2
+			}
+			var contents = writer.finish()
+			if restartInterval > 1 && len(contents) >= fullSize/2 {
+				t.Errorf("expected shared prefixes to at least halve the block, got %d bytes for %d", len(contents), fullSize)
+			}
+
+			var iterator = newBlockIterator(contents, 0, formatVersion3)
+			for j := 0; iterator.next(); j++ {
+				if string(iterator.key) != keys[j] || string(iterator.value) != "v" {
+					t.Fatalf("expected %q=%q, got %q=%q", keys[j], "v", iterator.key, iterator.value)
+				}
+			}
+			if iterator.err != nil {
+				t.Fatal("unexpected error iterating over block:", iterator.err)
+			}
+
+			for j, key := range keys {
+				if !iterator.seek(leveldb.Key(key), leveldb.Key.Compare) || string(iterator.key) != key {
+					t.Errorf("expected seek to %q to find it, got %q (err %v)", key, iterator.key, iterator.err)
+				}
+				// keys between those in the block find the next one
+				var between = fmt.Sprintf("user:%05d:profile", 2*j-1)
+				if !iterator.seek(leveldb.Key(between), leveldb.Key.Compare) || string(iterator.key) != key {
+					t.Errorf("expected seek to %q to find %q, got %q (err %v)", between, key, iterator.key, iterator.err)
+				}
+			}
+			if iterator.seek(leveldb.Key("user:99999"), leveldb.Key.Compare) {
+				t.Errorf("expected seek past the last key to find nothing, got %q", iterator.key)
+			}
+
+			var j = len(keys) - 1
+			for ok := iterator.seekToLast(); ok; ok = iterator.prev() {
+				if string(iterator.key) != keys[j] {
+					t.Fatalf("expected to move back to %q, got %q", keys[j], iterator.key)
+				}
+				j--
+			}
+			if j != -1 || iterator.err != nil {
+				t.Errorf("expected to move back over every key, stopped with %d to go (err %v)", j+1, iterator.err)
+			}
+		})
+	}
+}
+
+func TestBlock_Corruption(t *testing.T) {
+	var writer = blockWriter{restartInterval: 2}
+	for _, key := range []string{"alpha", "alphabet", "alpine", "beta"} {
+		writer.add([]byte(key), []byte("value"))
+	}
+	var contents = writer.finish()
+
+	tests := []struct {
+		name     string
+		contents []byte
+	}{
+		{name: "TooShort", contents: contents[:3]},
+		{name: "TooManyRestarts", contents: append(contents[:len(contents)-4:len(contents)-4], 0xff, 0xff, 0, 0)},
+		{name: "EntryPastEnd", contents: append([]byte{0, 0x7f, 1}, contents[len(contents)-12:]...)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var iterator = newBlockIterator(tc.contents, 0, formatVersion3)
+			for iterator.next() {
+			}
+			var corruption *CorruptionError
+			if !errors.As(iterator.err, &corruption) {
+				t.Errorf("expected a CorruptionError, got %v", iterator.err)
+			}
+		})
+	}
+}
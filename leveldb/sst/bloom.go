@@ -0,0 +1,67 @@
+package sst
+
+import (
+	"hash/fnv"
+	"leveldb"
+)
+
+const defaultBloomBitsPerKey = 10
+
+// bloomFilter is a bloom filter over the keys of a table, following LevelDB's: the bit array is followed by a single
+// byte holding the number of probes, and probes are derived from one hash by double hashing.
+//
+// | arbitrarily long | 1 byte            |
+// | [bits]           | [number of probes] |
+type bloomFilter []byte
+
+// newBloomFilter builds a filter over the given key hashes with about bitsPerKey bits for each.
+func newBloomFilter(hashes []uint32, bitsPerKey int) bloomFilter {
+	// ln(2) * bitsPerKey probes minimises the false positive rate
+	var probes = min(max(int(float64(bitsPerKey)*0.69), 1), 30)
+	var numBits = max(len(hashes)*bitsPerKey, 64) // small filters have a very high false positive rate
+	var numBytes = (numBits + 7) / 8
+	numBits = numBytes * 8
+
+	var filter = make(bloomFilter, numBytes+1)
+	filter[numBytes] = byte(probes)
+	for _, h := range hashes {
+		var delta = h>>17 | h<<15
+		for range probes {
+			var bit = h % uint32(numBits)
+			filter[bit/8] |= 1 << (bit % 8)
+			h += delta
+		}
+	}
+	return filter
+}
+
+// mayContain reports whether the key may have been added to the filter.  A false result is certain; a true one is
+// wrong about one time in a hundred at ten bits per key.
+func (filter bloomFilter) mayContain(key leveldb.Key) bool {
+	if len(filter) < 2 {
+		return true
+	}
+	var (
+		numBits = uint32(len(filter)-1) * 8
+		probes  = int(filter[len(filter)-1])
+		h       = bloomHash(key)
+		delta   = h>>17 | h<<15
+	)
+	if probes > 30 {
+		return true // reserved for other encodings, as in LevelDB
+	}
+	for range probes {
+		var bit = h % numBits
+		if filter[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+func bloomHash(key leveldb.Key) uint32 {
+	var h = fnv.New32a()
+	_, _ = h.Write(key)
+	return h.Sum32()
+}
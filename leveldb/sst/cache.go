@@ -0,0 +1,28 @@
+package sst
+
+import "leveldb/cache"
+
+// BlockCache holds the contents of data blocks once read, checked and decompressed, so that reads of hot blocks skip
+// all three.  One cache is shared by many tables, each opened WithBlockCache under the number of its file.
+type BlockCache struct {
+	blocks *cache.Cache[blockCacheKey, []byte]
+}
+
+// blockCacheKey locates a block by the number of its table's file and its offset within it.
+type blockCacheKey struct {
+	fileNumber uint64
+	offset     uint64
+}
+
+// NewBlockCache returns a BlockCache holding up to capacity bytes of block contents.
+func NewBlockCache(capacity int64) *BlockCache {
+	return &BlockCache{blocks: cache.New[blockCacheKey, []byte](capacity, func(key blockCacheKey) uint64 {
+		// blocks are at least a few hundred bytes apart, so mix in the offset's higher bits as well
+		return key.fileNumber*0x9e3779b97f4a7c15 ^ key.offset ^ key.offset>>10
+	}, nil)}
+}
+
+// Stats reports the cache's hits and misses, and the bytes of block contents it holds.
+func (c *BlockCache) Stats() cache.Stats {
+	return c.blocks.Stats()
+}
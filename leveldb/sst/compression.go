@@ -0,0 +1,75 @@
+package sst
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// Compression selects the codec for a table's data blocks.  The codec is recorded in each block's trailer, so tables
+// written with different codecs, or none, can always be read.
+type Compression byte
+
+const (
+	// LZCompression is a fast LZ77-style codec (see lzCompress), and the default.
+	LZCompression Compression = iota
+	// FlateCompression compresses more tightly than LZCompression, but is several times slower.
+	FlateCompression
+	// NoCompression stores data blocks as they are.
+	NoCompression
+)
+
+// Block types, recorded in each block's trailer.  These are part of the format, so must never be renumbered.
+const (
+	blockTypeUncompressed byte = 0
+	blockTypeFlate        byte = 1
+	blockTypeLZ           byte = 2
+)
+
+// compressBlock returns the contents compressed with the given codec, along with the block type to record.  Contents
+// that do not shrink by at least an eighth are returned as they are, since decompressing them would cost more than
+// reading the few bytes saved.
+func compressBlock(contents []byte, compression Compression) ([]byte, byte) {
+	var (
+		compressed []byte
+		blockType  byte
+	)
+	switch compression {
+	case LZCompression:
+		compressed, blockType = lzCompress(contents), blockTypeLZ
+	case FlateCompression:
+		var buf bytes.Buffer
+		writer, _ := flate.NewWriter(&buf, flate.DefaultCompression) // only errors on an invalid level
+		_, _ = writer.Write(contents)                                // writes to a bytes.Buffer don't fail
+		_ = writer.Close()
+		compressed, blockType = buf.Bytes(), blockTypeFlate
+	default:
+		return contents, blockTypeUncompressed
+	}
+	if len(compressed) >= len(contents)-len(contents)/8 {
+		return contents, blockTypeUncompressed
+	}
+	return compressed, blockType
+}
+
+// decompressBlock reverses compressBlock, given the block type recorded in the block's trailer.
+func decompressBlock(data []byte, blockType byte, blockOffset int64) ([]byte, error) {
+	switch blockType {
+	case blockTypeUncompressed:
+		return data, nil
+	case blockTypeLZ:
+		contents, err := lzDecompress(data)
+		if err != nil {
+			return nil, newCorruptionError(blockOffset, "error decompressing block: %v", err)
+		}
+		return contents, nil
+	case blockTypeFlate:
+		contents, err := io.ReadAll(flate.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, newCorruptionError(blockOffset, "error decompressing block: %v", err)
+		}
+		return contents, nil
+	default:
+		return nil, newCorruptionError(blockOffset, "unknown block type %d", blockType)
+	}
+}
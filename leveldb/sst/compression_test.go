@@ -0,0 +1,144 @@
+package sst
+
+import (
+	"bytes"
+	"fmt"
+	"leveldb"
+	"leveldb/skiplist"
+	"math/rand/v2"
+	"os"
+	"testing"
+)
+
+func TestLZ_RoundTrip(t *testing.T) {
+	var rng = rand.New(rand.NewPCG(1, 2))
+	var random = make([]byte, 5000)
+	for j := range random {
+		random[j] = byte(rng.IntN(256))
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "Empty", data: nil},
+		{name: "Short", data: []byte("abc")},
+		{name: "Run", data: bytes.Repeat([]byte{'x'}, 1000)},
+		{name: "RepeatedPattern", data: bytes.Repeat([]byte(`{"name":"value","count":12},`), 100)},
+		{name: "Random", data: random},
+		{name: "Mixed", data: append(bytes.Repeat([]byte("abcdefgh"), 50), random[:300]...)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var compressed = lzCompress(tc.data)
+			decompressed, err := lzDecompress(compressed)
+			if err != nil {
+				t.Fatal("unexpected error decompressing:", err)
+			}
+			if !bytes.Equal(decompressed, tc.data) {
+				t.Errorf("expected round trip to return the %d bytes compressed, got %d others", len(tc.data), len(decompressed))
+			}
+			if len(compressed) > 1 {
+				if _, err := lzDecompress(compressed[:len(compressed)-1]); err == nil {
+					t.Error("expected an error decompressing a truncated block")
+				}
+			}
+		})
+	}
+}
+
+func TestSSTable_Compression(t *testing.T) {
+	var rng = rand.New(rand.NewPCG(3, 4))
+	var memTable = skiplist.NewSkipList()
+	for j := range 500 {
+		var value = fmt.Sprintf(`{"id":%d,"name":"user %d","email":"user%d@example.com","active":true}`, j, j, j)
+		if j%2 == 1 { // incompressible
+			var random = make([]byte, 64)
+			for k := range random {
+				random[k] = byte(rng.IntN(256))
+			}
+			value = string(random)
+		}
+		if err := memTable.Insert(leveldb.Key(fmt.Sprintf("key%04d", j)), leveldb.Value(value)); err != nil {
+			t.Fatalf("error inserting key into memTable skiplist: %v", err)
+		}
+	}
+
+	var sizes = make(map[Compression]int64)
+	for _, tc := range []struct {
+		name        string
+		compression Compression
+	}{
+		{name: "None", compression: NoCompression},
+		{name: "LZ", compression: LZCompression},
+		{name: "Flate", compression: FlateCompression},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.CreateTemp(t.TempDir(), "sst")
+			if err != nil {
+				t.Fatal("failed to create SST file:", err)
+			}
+			sstDb, err := BuildSSTable(
+				file,
+				memTable,
+				skiplist.NewSkipList(),
+				withBlockSize(0x200),
+				WithCompression(tc.compression),
+			)
+			if err != nil {
+				t.Fatal("error building SSTable:", err)
+			}
+			defer func() { _ = sstDb.Close() }()
+			info, err := file.Stat()
+			if err != nil {
+				t.Fatal("error reading SSTable size:", err)
+			}
+			sizes[tc.compression] = info.Size()
+
+			results, err := sstDb.RangeScan(leveldb.Key("key0000"), leveldb.Key("key9999"))
+			if err != nil {
+				t.Fatal("unexpected error executing RangeScan()", err)
+			}
+			defer results.Close()
+			header, err := memTable.TraverseUntil(nil, nil)
+			if err != nil {
+				t.Fatal("unexpected error traversing memTable", err)
+			}
+			for expected := header.Next(); expected != skiplist.NilNode; expected = expected.Next() {
+				if !results.Next() {
+					t.Fatalf("expected %q, got end of iteration (err %v)", expected.Key(), results.Error())
+				}
+				if !bytes.Equal(results.Key(), expected.Key()) || !bytes.Equal(results.Value(), expected.Value()) {
+					t.Fatalf("expected %q=%q, got %q=%q", expected.Key(), expected.Value(), results.Key(), results.Value())
+				}
+			}
+			if results.Next() {
+				t.Errorf("expected end of iteration, got %q", results.Key())
+			}
+			if value, err := sstDb.Get(leveldb.Key("key0042")); err != nil || !bytes.Contains(value, []byte(`"id":42`)) {
+				t.Errorf("unexpected result from Get(): %q (err %v)", value, err)
+			}
+		})
+	}
+	for _, compression := range []Compression{LZCompression, FlateCompression} {
+		if sizes[compression] >= sizes[NoCompression] {
+			t.Errorf(
+				"expected codec %d to shrink the table, got %d bytes for %d uncompressed",
+				compression, sizes[compression], sizes[NoCompression],
+			)
+		}
+	}
+}
+
+func TestCompressBlock_IncompressibleStoredAsIs(t *testing.T) {
+	var rng = rand.New(rand.NewPCG(5, 6))
+	var contents = make([]byte, 4096)
+	for j := range contents {
+		contents[j] = byte(rng.IntN(256))
+	}
+	for _, compression := range []Compression{LZCompression, FlateCompression} {
+		stored, blockType := compressBlock(contents, compression)
+		if blockType != blockTypeUncompressed || !bytes.Equal(stored, contents) {
+			t.Errorf("expected codec %d to store incompressible contents as they are, got block type %d", compression, blockType)
+		}
+	}
+}
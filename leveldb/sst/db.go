@@ -1,21 +1,16 @@
 package sst
 
 import (
-	"bufio"
-	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"leveldb"
-	"leveldb/encoding"
 	"leveldb/skiplist"
 	"os"
+	"slices"
 )
 
-const (
-	sparseIndexThreshold = 0x400 // add new index every 1K bytes written
-	dataOffset           = 0x10
-)
+const defaultBlockSize = 0x1000 // cut a new data block every 4K bytes written
 
 // BuildSSTable builds an SSTable from the SkipLists for present and tombstoned entries
 func BuildSSTable(
@@ -25,30 +20,37 @@ func BuildSSTable(
 	configOptions ...ssTableOption,
 ) (*SSTableDB, error) {
 	/**
-	 * format:
-	 * | 8 bytes (int64)    | 8 bytes 		   | arbitrarily long | arbitrarily long		  |
-	 * | [directory offset] | directory size   |     [data]       | [directory entries]      |
+	 * format (formatVersion3):
+	 * | arbitrarily long | arbitrarily long | arbitrarily long | 48 bytes |
+	 * | [data blocks]    | (filter block)   | [index block]    | [footer] |
+	 *
+	 * data blocks hold entries in key order, each block cut once its contents reach the configured block size.  Keys
+	 * are prefix-compressed within a block, and a tombstone is an entry with an empty value; see block.go for the
+	 * layout.  Data blocks are compressed with the configured codec where that shrinks them, and every block ends with
+	 * a trailer recording its codec and holding a checksum.
+	 *
+	 * index block, with an entry for each data block:
+	 * | key                    | value (16 bytes)              |
+	 * | [largest key in block] | [block offset] | [block size] |
 	 *
-	 * data:
-	 * | 8 bytes   |  arbitrary |  8 bytes    |  [0, arbitrary) |
-	 * | [key len] | [key]		| [value len] | (value) 	   |
+	 * footer:
+	 * | 16 bytes             | 16 bytes              | 8 bytes   | 8 bytes      |
+	 * | [index block handle] | (filter block handle) | [version] | [tableMagic] |
 	 *
-	 * , where [value len] is 0 if key is tombstoned, and value omitted in this case
+	 * , where a block handle is the block's offset and stored size, and the filter handle is zeroed if there is no
+	 * filter.  formatVersion2 differs only in how blocks lay out their entries, and is still read, as is
+	 * formatVersion1; see v1.go.
 	 *
-	 * directory entry:
-	 * | 8 bytes   |  arbitrary |  8 bytes      |
-	 * | [key len] | [key]		| [file offset] |
+	 * formatVersion4 tables, written by a Writer given range tombstones, hold them in a block of their own after the
+	 * filter block, laid out like a data block.  Its handle comes first in a footer 16 bytes longer:
+	 * | 16 bytes                       | 48 bytes                |
+	 * | [range tombstone block handle] | [formatVersion3 footer] |
 	 */
-	var ssTableConfig = newSSTableConfig()
-	for _, option := range configOptions {
-		option(ssTableConfig)
-	}
 	// LevelDB’s approach is to flush the mem-table to disk once it reaches the mem-table once it reaches some threshold
 	// size, and then truncate the write-ahead log to remove any entries involving flushed data. The data is persisted
 	// in an immutable format called an “SSTable” (or “sorted string table”).
-	// do writing here
-
-	if _, err := f.Seek(dataOffset, io.SeekStart); err != nil { // start writing from start
+	writer, err := NewWriter(f, configOptions...)
+	if err != nil {
 		return nil, err
 	}
 
@@ -66,11 +68,6 @@ func BuildSSTable(
 	memTableNode = memTableNode.Next()
 
 	// merging loop
-	var (
-		cumulativeBytesWritten int
-		sparseKeys             []leveldb.Key
-		keyOffsets             []offset
-	)
 	for memTableNode != skiplist.NilNode || tombstonedNode != skiplist.NilNode {
 		var nodeToEncode skiplist.Node
 
@@ -82,7 +79,7 @@ func BuildSSTable(
 			nodeToEncode = tombstonedNode
 			tombstonedNode = tombstonedNode.Next()
 		} else {
-			var comparison = bytes.Compare(tombstonedNode.Key(), memTableNode.Key())
+			var comparison = writer.config.compare(tombstonedNode.Key(), memTableNode.Key())
 			switch {
 			case comparison < 0:
 				nodeToEncode = tombstonedNode
@@ -98,162 +95,270 @@ func BuildSSTable(
 			}
 		}
 
-		entryToEncode := encoding.Entry{
-			Key:   encoding.Key(nodeToEncode.Key()),
-			Value: encoding.Value(nodeToEncode.Value()),
-		}
-		encodedEntry, err := entryToEncode.Encode()
-		if err != nil {
-			return nil, err
-		}
-		bytesWritten, err := f.Write(encodedEntry)
-		if err != nil {
+		if err := writer.Add(nodeToEncode.Key(), nodeToEncode.Value()); err != nil {
 			return nil, err
 		}
-		if bytesWritten != len(encodedEntry) {
-			return nil, errors.New("failed to write all bytes")
-		}
-		cumulativeBytesWritten += bytesWritten
-		if cumulativeBytesWritten > ssTableConfig.sparseIndexThreshold {
-			var currentOffset, err = f.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return nil, err
-			}
-			sparseKeys = append(sparseKeys, leveldb.Key(entryToEncode.Key))
-			keyOffsets = append(keyOffsets, offset(currentOffset-int64(bytesWritten)))
-			cumulativeBytesWritten = 0
-		}
-
 	}
 
-	directoryOffset, err := f.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, err
+	return writer.Finish()
+}
+
+// NewSSTableDBFromFile opens the table in reader, which may be in any supported format version.  reader must be able
+// to report its size, as files do.  A table written with WithKeyComparison or WithFilterKey must be opened with the
+// same options; others are ignored.
+func NewSSTableDBFromFile(reader io.ReaderAt, configOptions ...ssTableOption) (*SSTableDB, error) {
+	var ssTableConfig = newSSTableConfig()
+	for _, option := range configOptions {
+		option(ssTableConfig)
 	}
-	// write directory
-	directory, err := NewDirectory(sparseKeys, keyOffsets)
+	return openTable(reader, ssTableConfig)
+}
+
+func openTable(reader io.ReaderAt, config *ssTableConfig) (*SSTableDB, error) {
+	fileSize, err := sizeOf(reader)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewSSTableDBFromFile: error finding size of file: %v", err)
 	}
-	encodedDirectory, err := directory.Encode()
-	if err != nil {
-		return nil, err
+	if fileSize >= footerSize {
+		var buf = make([]byte, footerSize)
+		if _, err := reader.ReadAt(buf, fileSize-footerSize); err != nil {
+			return nil, fmt.Errorf("NewSSTableDBFromFile: error reading footer: %v", err)
+		}
+		if hasFooter(buf) {
+			return openFooter(reader, decodeFooter(buf), fileSize, config)
+		}
 	}
-	_, err = f.Write(encodedDirectory)
-	if err != nil {
-		return nil, err
+	// tables without a footer predate formatVersion2
+	return openV1(reader, fileSize, config)
+}
+
+// sizeOf returns the size of what reader reads, which it must report the way files or io.SectionReaders do.
+func sizeOf(reader io.ReaderAt) (int64, error) {
+	switch r := reader.(type) {
+	case interface{ Size() int64 }:
+		return r.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		info, err := r.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
 	}
+	return 0, fmt.Errorf("cannot tell the size of a %T", reader)
+}
 
-	// go back to front of file and write metadata
-	if _, err := f.Seek(0, io.SeekStart); err != nil {
-		return nil, err
+// openFooter reads the rest of a footer whose last footerSize bytes have been decoded, then opens the table.
+func openFooter(reader io.ReaderAt, footer footer, fileSize int64, config *ssTableConfig) (*SSTableDB, error) {
+	if footer.version < formatVersion4 {
+		return openV2(reader, footer, fileSize-footerSize, config)
 	}
-	if err := encoding.WriteUint64(f, uint64(directoryOffset)); err != nil {
-		return nil, err
+	if fileSize < footerSizeV4 {
+		return nil, newCorruptionError(0, "table of %d bytes is too short for its footer", fileSize)
 	}
-	if err := encoding.WriteUint64(f, uint64(len(encodedDirectory))); err != nil {
-		return nil, err
+	var buf = make([]byte, blockHandleSize)
+	if _, err := reader.ReadAt(buf, fileSize-footerSizeV4); err != nil {
+		return nil, fmt.Errorf("NewSSTableDBFromFile: error reading footer: %v", err)
 	}
-
-	return NewSSTableDBFromFile(f)
+	footer.rangeTombstones = decodeBlockHandle(buf)
+	return openV2(reader, footer, fileSize-footerSizeV4, config)
 }
 
-func NewSSTableDBFromFile(readSeeker io.ReadSeeker) (*SSTableDB, error) {
-	var (
-		bufReader = bufio.NewReader(readSeeker)
-		err       error
-	)
-	if _, err := readSeeker.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("NewSSTableDBFromFile: error seeking to start of file: %v", err)
+func openV2(reader io.ReaderAt, footer footer, footerOffset int64, config *ssTableConfig) (*SSTableDB, error) {
+	if footer.version < formatVersion2 || footer.version > formatVersion4 {
+		return nil, newCorruptionError(footerOffset, "unsupported format version %d", footer.version)
 	}
-	// START: read directory metadata
-	endOfDataOffset, err := encoding.ReadUint64(bufReader)
-	if err != nil {
-		return nil, fmt.Errorf("NewSSTableDBFromFile: error reading data offset: %v", err)
+	for _, handle := range []blockHandle{footer.index, footer.filter, footer.rangeTombstones} {
+		if handle.offset > uint64(footerOffset) || handle.size > uint64(footerOffset)-handle.offset {
+			return nil, newCorruptionError(footerOffset, "footer locates a block outside the file")
+		}
 	}
-	dirLen, err := encoding.ReadUint64(bufReader)
+	indexBlock, err := readBlock(reader, footer.index)
 	if err != nil {
-		return nil, fmt.Errorf("NewSSTableDBFromFile: error reading directory length: %v", err)
-	}
-	// END: read directory metadata
-	// START: read directory
-	if _, err := readSeeker.Seek(int64(endOfDataOffset), io.SeekStart); err != nil {
-		return nil, err
+		return nil, fmt.Errorf("NewSSTableDBFromFile: error reading index block: %w", err)
 	}
-	var directory = NewBlankDirectory()
-	if dirLen > 0 {
-		directoryBuf := make([]byte, dirLen)
-		bytesRead, err := readSeeker.Read(directoryBuf)
-		if err != nil {
-			return nil, fmt.Errorf("sst.NewSSTableDBFromFile: error reading directory contents: %v", err)
-		}
-		if uint64(bytesRead) != dirLen {
-			return nil, fmt.Errorf("sst.NewSSTableDBFromFile: failure to read entire directory.  expected %d bytes, read %d", dirLen, bytesRead)
+	var (
+		index    []indexEntry
+		iterator = newBlockIterator(indexBlock, int64(footer.index.offset), footer.version)
+	)
+	for iterator.next() {
+		if len(iterator.value) != blockHandleSize {
+			return nil, newCorruptionError(int64(footer.index.offset), "malformed index entry for key %q", iterator.key)
 		}
-		if err := directory.Decode(directoryBuf); err != nil {
-			return nil, fmt.Errorf("NewSSTableDBFromFile: error decoding directory contents: %v", err)
+		index = append(index, indexEntry{largest: iterator.key, handle: decodeBlockHandle(iterator.value)})
+	}
+	if iterator.err != nil {
+		return nil, fmt.Errorf("NewSSTableDBFromFile: error reading index block: %w", iterator.err)
+	}
+
+	var filter bloomFilter
+	if footer.filter.size > 0 {
+		if filter, err = readBlock(reader, footer.filter); err != nil {
+			return nil, fmt.Errorf("NewSSTableDBFromFile: error reading filter block: %w", err)
 		}
 	}
-	// END: read directory
-	// reset to start of data
-	if _, err = readSeeker.Seek(dataOffset, io.SeekStart); err != nil { // 8 == 2 * size(int64)
-		return nil, fmt.Errorf("NewSSTableDBFromFile: error seeking to start of data: %v", err)
+
+	var rangeTombstones []leveldb.DataEntry
+	if footer.rangeTombstones.size > 0 {
+		if rangeTombstones, err = readRangeTombstones(reader, footer); err != nil {
+			return nil, fmt.Errorf("NewSSTableDBFromFile: error reading range tombstone block: %w", err)
+		}
 	}
 	return &SSTableDB{
-		readSeeker:      readSeeker,
-		endOfDataOffset: int64(endOfDataOffset),
-		dir:             directory,
+		reader:          reader,
+		version:         footer.version,
+		index:           index,
+		filter:          filter,
+		rangeTombstones: rangeTombstones,
+		compare:         config.compare,
+		filterKey:       config.filterKey,
+		blockCache:      config.blockCache,
+		fileNumber:      config.fileNumber,
 	}, nil
 }
 
+// readRangeTombstones reads every entry in the range tombstone block the footer locates.
+func readRangeTombstones(reader io.ReaderAt, footer footer) ([]leveldb.DataEntry, error) {
+	contents, err := readBlock(reader, footer.rangeTombstones)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		entries  []leveldb.DataEntry
+		iterator = newBlockIterator(contents, int64(footer.rangeTombstones.offset), footer.version)
+	)
+	for iterator.next() {
+		entries = append(entries, leveldb.DataEntry{Key: iterator.key, Value: iterator.value})
+	}
+	return entries, iterator.err
+}
+
+// SSTableDB is safe for concurrent use.  Every read says where in the file it reads from, so that lookups and any
+// number of iterators, each keeping its own position, can share the one open file.
 type SSTableDB struct {
-	readSeeker      io.ReadSeeker
+	reader io.ReaderAt
+	// version is the format the table was written in
+	version uint64
+	// endOfDataOffset and dir are only set for formatVersion1 tables
 	endOfDataOffset int64
 	dir             *Directory
+	// index holds an entry for each data block, in key order.  It is not set for formatVersion1 tables.
+	index []indexEntry
+	// filter is nil for tables written without a bloom filter
+	filter bloomFilter
+	// rangeTombstones holds the entries added with Writer.AddRangeTombstone, read in full when the table is opened
+	rangeTombstones []leveldb.DataEntry
+	// compare orders the table's keys, and filterKey maps a key to what the bloom filter holds for it
+	compare   func(a, b leveldb.Key) int
+	filterKey func(key leveldb.Key) leveldb.Key
+	// blockCache, if set, holds data blocks read from the table under its fileNumber
+	blockCache *BlockCache
+	fileNumber uint64
 }
 
-func (db *SSTableDB) Get(searchKey leveldb.Key) (leveldb.Value, error) {
-	var (
-		entry = new(encoding.Entry)
-		err   error
-	)
+// indexEntry locates a data block, and records the largest key within it.
+type indexEntry struct {
+	largest leveldb.Key
+	handle  blockHandle
+}
 
-	if err = db.scanTowards(searchKey); err != nil {
+func (db *SSTableDB) Get(searchKey leveldb.Key, opts ...leveldb.ReadOption) (leveldb.Value, error) {
+	if db.version == formatVersion1 {
+		if db.filter != nil && !db.filter.mayContain(searchKey) {
+			return nil, leveldb.NewNotFoundError(searchKey)
+		}
+		return db.getV1(searchKey)
+	}
+	key, value, err := db.Find(searchKey, opts...)
+	if err != nil {
 		return nil, err
 	}
+	if db.compare(key, searchKey) != 0 {
+		return nil, leveldb.NewNotFoundError(searchKey)
+	}
+	if value == nil {
+		// report tombstones distinctly so callers consulting multiple tables know not to look in older ones
+		return nil, leveldb.NewTombstonedError(searchKey)
+	}
+	return value, nil
+}
 
-	for !db.isAtEndOfData() {
-		bytesRead, err := readEntry(db.readSeeker, entry)
-		if err != nil {
-			_, _ = db.readSeeker.Seek(-bytesRead, io.SeekCurrent)
-			return nil, err
+// Find returns the first entry with a key greater than or equal to searchKey, tombstones included, provided the bloom
+// filter does not rule out the table holding searchKey's filter key (see WithFilterKey).  Callers looking up a key by
+// part of it, such as a prefix, use it to find the entry where the rest of the key is smallest.  It is not supported
+// by formatVersion1 tables.
+func (db *SSTableDB) Find(searchKey leveldb.Key, opts ...leveldb.ReadOption) (leveldb.Key, leveldb.Value, error) {
+	if db.version == formatVersion1 {
+		return nil, nil, fmt.Errorf("sst.SSTableDB.Find: not supported by format version %d", db.version)
+	}
+	if db.filter != nil && !db.filter.mayContain(db.filterKeyOf(searchKey)) {
+		return nil, nil, leveldb.NewNotFoundError(searchKey)
+	}
+	var j = db.blockFor(searchKey)
+	if j == len(db.index) {
+		return nil, nil, leveldb.NewNotFoundError(searchKey)
+	}
+	data, err := db.readBlock(db.index[j].handle, !leveldb.NewReadOptions(opts...).BypassCache)
+	if err != nil {
+		return nil, nil, err
+	}
+	var iterator = newBlockIterator(data, int64(db.index[j].handle.offset), db.version)
+	if !iterator.seek(searchKey, db.compare) {
+		if iterator.err != nil {
+			return nil, nil, iterator.err
 		}
+		return nil, nil, leveldb.NewNotFoundError(searchKey)
+	}
+	return iterator.key, iterator.value, nil
+}
 
-		var comparison = bytes.Compare(entry.Key, searchKey)
-		if comparison > 0 {
-			return nil, leveldb.NewNotFoundError(searchKey)
-		} else if comparison == 0 {
-			if len(entry.Value) == 0 {
-				// valLen == 0 implies the key has been tombstoned
-				// FIXME: consider multiple tables
-				return nil, leveldb.NewNotFoundError(searchKey)
-			}
+// RangeTombstones returns the range tombstones the table was written with (see Writer.AddRangeTombstone), in key
+// order.  The caller must not modify them.
+func (db *SSTableDB) RangeTombstones() []leveldb.DataEntry {
+	return db.rangeTombstones
+}
 
-			break
-		} else {
-			entry = new(encoding.Entry)
-			continue
-		}
+// readBlock reads the block located by handle (see readBlock) from the block cache if it is there, or else from the
+// file, adding it to the cache if fill is set.
+func (db *SSTableDB) readBlock(handle blockHandle, fill bool) ([]byte, error) {
+	if db.blockCache == nil {
+		return readBlock(db.reader, handle)
 	}
-	if entry.IsZeroEntry() {
-		return nil, leveldb.NewNotFoundError(searchKey)
-	} else {
-		return leveldb.Value(entry.Value), nil
+	var key = blockCacheKey{fileNumber: db.fileNumber, offset: handle.offset}
+	if contents, ok := db.blockCache.blocks.Get(key); ok {
+		return contents, nil
+	}
+	contents, err := readBlock(db.reader, handle)
+	if err == nil && fill {
+		db.blockCache.blocks.Add(key, contents, int64(len(contents)))
+	}
+	return contents, err
+}
+
+func (db *SSTableDB) filterKeyOf(key leveldb.Key) leveldb.Key {
+	if db.filterKey == nil {
+		return key
+	}
+	return db.filterKey(key)
+}
+
+// blockFor returns the index of the only data block that may hold key, or len(db.index) if key is past the last block.
+func (db *SSTableDB) blockFor(key leveldb.Key) int {
+	j, _ := slices.BinarySearchFunc(db.index, key, func(entry indexEntry, key leveldb.Key) int {
+		return db.compare(entry.largest, key)
+	})
+	return j
+}
+
+// Close closes the underlying file, if it can be closed.
+func (db *SSTableDB) Close() error {
+	if closer, ok := db.reader.(io.Closer); ok {
+		return closer.Close()
 	}
+	return nil
 }
 
-func (db *SSTableDB) Has(key leveldb.Key) (bool, error) {
-	_, err := db.Get(key)
+func (db *SSTableDB) Has(key leveldb.Key, opts ...leveldb.ReadOption) (bool, error) {
+	_, err := db.Get(key, opts...)
 	if err != nil {
 		if errors.Is(err, leveldb.ErrKeyNotFound) {
 			return false, nil
@@ -263,164 +368,288 @@ func (db *SSTableDB) Has(key leveldb.Key) (bool, error) {
 	return true, nil
 }
 
-func (db *SSTableDB) RangeScan(start leveldb.Key, limit leveldb.Key) (leveldb.Iterator, error) {
-	var (
-		encodingStart = encoding.Key(start)
-		currentEntry  = new(encoding.Entry)
-		err           error
-		bytesRead     int64
-	)
-	if err = db.scanTowards(start); err != nil {
-		return nil, err
-	}
-	for !db.isAtEndOfData() {
-		bytesRead, err = readEntry(db.readSeeker, currentEntry)
-		if err != nil {
-			return nil, err
-		}
-		if currentEntry.Key.Compare(encodingStart) < 0 {
-			continue
-		}
-		// we have found the entry gte our key, let's rewind so Next() returns it
-		_, err := db.readSeeker.Seek(-bytesRead, io.SeekCurrent)
+// RangeScan iterates over the entries in [start, limit].  A scan given leveldb.WithBypassCache leaves the blocks it
+// reads out of the block cache, so that a one-off scan does not evict the blocks other reads keep coming back to.
+func (db *SSTableDB) RangeScan(
+	start leveldb.Key,
+	limit leveldb.Key,
+	opts ...leveldb.ReadOption,
+) (leveldb.Iterator, error) {
+	return db.rangeScan(start, limit, false, leveldb.NewReadOptions(opts...))
+}
+
+// RangeScanWithTombstones is like RangeScan, but the returned Iterator also yields tombstoned keys (with empty values).
+// This lets callers merging several tables have a deletion in a newer table shadow a value in an older one.
+func (db *SSTableDB) RangeScanWithTombstones(
+	start leveldb.Key,
+	limit leveldb.Key,
+	opts ...leveldb.ReadOption,
+) (leveldb.Iterator, error) {
+	return db.rangeScan(start, limit, true, leveldb.NewReadOptions(opts...))
+}
+
+func (db *SSTableDB) rangeScan(
+	start leveldb.Key,
+	limit leveldb.Key,
+	includeTombstones bool,
+	readOptions leveldb.ReadOptions,
+) (leveldb.Iterator, error) {
+	if db.version == formatVersion1 {
+		iterator, err := db.rangeScanV1(start, limit, includeTombstones)
 		if err != nil {
 			return nil, err
 		}
-		break
-	}
+		return iterator, nil
+	}
+	return &tableIterator{
+		table:             db,
+		start:             start,
+		limit:             limit,
+		includeTombstones: includeTombstones,
+		fillCache:         !readOptions.BypassCache,
+	}, nil
+}
 
-	return NewIterator(
-		db.readSeeker,
-		encoding.Key(limit),
-		db.endOfDataOffset,
-	), nil
+// tableIterator is used for satisfying a RangeScan over a block-based table.  It reads one data block at a time,
+// so that other reads of the table may come between moves.
+type tableIterator struct {
+	table *SSTableDB
+	// block iterates over the current data block, and blockIndex is its index entry.  block is nil while the
+	// iterator is before its first entry or, if afterLast is set, after its last.
+	block      *blockIterator
+	blockIndex int
+	afterLast  bool
+	start      leveldb.Key
+	limit      leveldb.Key
+	key        leveldb.Key
+	value      leveldb.Value
+	err        error
+	// includeTombstones has tombstoned keys yielded with empty values instead of skipped
+	includeTombstones bool
+	// fillCache has the blocks read added to the table's block cache
+	fillCache bool
 }
 
-// scanTowards scans to the key in the sparse index that's closest to searchKey (less than or equal to)
-func (db *SSTableDB) scanTowards(searchKey leveldb.Key) error {
-	startIndex, err := db.dir.offsetFor(searchKey)
-	if err != nil {
-		return err
-	}
-	if _, err := db.readSeeker.Seek(int64(startIndex), io.SeekStart); err != nil {
-		return err
+func (i *tableIterator) Next() bool {
+	switch {
+	case i.err != nil || i.afterLast:
+		return false
+	case i.block == nil:
+		return i.SeekToFirst()
 	}
-	return nil
+	return i.settleForward(i.block.next())
 }
 
-// isAtEndOfData is a substitute for checking for EOF errors because our file has directory data
-// at the end of it.  The data offset is inferred from the encoding of where the directory starts (that encoding lives
-// at the beginning of the file)
-func (db *SSTableDB) isAtEndOfData() bool {
-	currOffset, _ := db.readSeeker.Seek(0, io.SeekCurrent) // no risk to get EOF with these parameters
-	return currOffset >= db.endOfDataOffset
+func (i *tableIterator) Prev() bool {
+	switch {
+	case i.err != nil:
+		return false
+	case i.afterLast:
+		return i.SeekToLast()
+	case i.block == nil:
+		return false
+	}
+	return i.settleBackward(i.block.prev())
 }
 
-func NewIterator(
-	readSeeker io.ReadSeeker,
-	limit encoding.Key,
-	endOfDataOffset int64,
-) *Iterator {
-	return &Iterator{
-		readSeeker:      readSeeker,
-		limit:           limit,
-		endOfDataOffset: endOfDataOffset,
-		currentEntry:    new(encoding.Entry), // call Next() first
-		err:             nil,
+func (i *tableIterator) Seek(key leveldb.Key) bool {
+	if i.err != nil {
+		return false
+	}
+	if i.table.compare(key, i.start) < 0 {
+		key = i.start
 	}
+	i.block = nil
+	var j = i.table.blockFor(key)
+	if j == len(i.table.index) || !i.loadBlock(j) {
+		return i.settleForward(false)
+	}
+	return i.settleForward(i.block.seek(key, i.table.compare))
 }
 
-// Iterator is used for satisfying a RangeScan.  It is similar to the read functions.
-type Iterator struct {
-	readSeeker      io.ReadSeeker
-	limit           encoding.Key
-	endOfDataOffset int64
-	currentEntry    *encoding.Entry // should this start at the preceding entry?
-	err             error
+func (i *tableIterator) SeekToFirst() bool {
+	return i.Seek(i.start)
 }
 
-func (i *Iterator) Next() bool {
-	if i.isAtEndOfData() {
+func (i *tableIterator) SeekToLast() bool {
+	if i.err != nil {
 		return false
 	}
-	_, err := readEntry(i.readSeeker, i.currentEntry)
-	if i.currentEntry.Key.Compare(i.limit) > 0 {
-		return false
+	i.block = nil
+	var j = min(i.table.blockFor(i.limit), len(i.table.index)-1)
+	if j < 0 || !i.loadBlock(j) {
+		return i.settleBackward(false)
+	}
+	// the last entry at or before limit: limit itself, or the one before the first entry past it
+	switch {
+	case !i.block.seek(i.limit, i.table.compare):
+		return i.settleBackward(i.block.err == nil && i.block.seekToLast())
+	case i.table.compare(i.block.key, i.limit) > 0:
+		return i.settleBackward(i.block.prev())
+	}
+	return i.settleBackward(true)
+}
+
+// settleForward moves on from the block's current entry, if ok, to the first one to yield, reading the blocks after
+// it as needed.
+func (i *tableIterator) settleForward(ok bool) bool {
+	for i.err == nil {
+		if !ok {
+			if i.block == nil || i.block.err != nil || i.blockIndex+1 >= len(i.table.index) {
+				break
+			}
+			if i.loadBlock(i.blockIndex + 1) {
+				ok = i.block.next()
+			}
+			continue
+		}
+		if i.table.compare(i.block.key, i.limit) > 0 {
+			break // nothing further can be in range
+		}
+		if i.table.compare(i.block.key, i.start) >= 0 && (i.block.value != nil || i.includeTombstones) {
+			i.key, i.value, i.afterLast = i.block.key, i.block.value, false
+			return true
+		}
+		ok = i.block.next() // before start, or tombstoned
+	}
+	return i.exhaust(true)
+}
+
+// settleBackward moves back from the block's current entry, if ok, to the first one to yield, reading the blocks
+// before it as needed.
+func (i *tableIterator) settleBackward(ok bool) bool {
+	for i.err == nil {
+		if !ok {
+			if i.block == nil || i.block.err != nil || i.blockIndex == 0 {
+				break
+			}
+			if i.loadBlock(i.blockIndex - 1) {
+				ok = i.block.seekToLast()
+			}
+			continue
+		}
+		if i.table.compare(i.block.key, i.start) < 0 {
+			break // nothing further back can be in range
+		}
+		if i.table.compare(i.block.key, i.limit) <= 0 && (i.block.value != nil || i.includeTombstones) {
+			i.key, i.value, i.afterLast = i.block.key, i.block.value, false
+			return true
+		}
+		ok = i.block.prev() // past limit, or tombstoned
 	}
+	return i.exhaust(false)
+}
+
+// loadBlock reads the data block with index entry j, returning false if it cannot be read.
+func (i *tableIterator) loadBlock(j int) bool {
+	var handle = i.table.index[j].handle
+	data, err := i.table.readBlock(handle, i.fillCache)
 	if err != nil {
 		i.err = err
 		return false
 	}
-	if len(i.currentEntry.Value) == 0 {
-		return i.Next() // don't return tombstoned data
-	}
+	i.block, i.blockIndex = newBlockIterator(data, int64(handle.offset), i.table.version), j
 	return true
 }
 
-func (i *Iterator) Error() error {
+// exhaust leaves the iterator after its last entry if afterLast is set, or else before its first, and picks up any
+// corruption found in the current block.
+func (i *tableIterator) exhaust(afterLast bool) bool {
+	if i.err == nil && i.block != nil && i.block.err != nil {
+		i.err = i.block.err
+	}
+	i.key, i.value, i.block, i.afterLast = nil, nil, nil, afterLast // Key() and Value() should return nil
+	return false
+}
+
+func (i *tableIterator) Error() error {
 	return i.err
 }
 
-func (i *Iterator) Key() leveldb.Key {
-	return leveldb.Key(i.currentEntry.Key)
+func (i *tableIterator) Key() leveldb.Key {
+	return i.key
 }
 
-func (i *Iterator) Value() leveldb.Value {
-	return leveldb.Value(i.currentEntry.Value)
+func (i *tableIterator) Value() leveldb.Value {
+	return i.value
 }
 
-func (i *Iterator) isAtEndOfData() bool {
-	currOffset, _ := i.readSeeker.Seek(0, io.SeekCurrent) // no risk to get EOF with these parameters
-	return currOffset > i.endOfDataOffset
+// Close does nothing, since the table the iterator reads is closed along with the SSTableDB.
+func (i *tableIterator) Close() error {
+	return nil
 }
 
-// readEntry reads an entry into the supplied pointer and returns how many bytes were read
-// in case the caller needs to "peek" (in which case they can seek backwards by that number of bytes)
-func readEntry(rs io.ReadSeeker, entry *encoding.Entry) (int64, error) {
-	var bytesRead int64
-	keyLen, err := encoding.ReadUint64(rs)
-	bytesRead += 8
-	if err != nil {
-		return bytesRead, err
-	}
-	var key = make(encoding.Key, keyLen)
-	keyBytes, err := io.ReadFull(rs, key)
-	bytesRead += int64(keyBytes)
-	if err != nil {
-		return bytesRead, err
+func newSSTableConfig() *ssTableConfig {
+	return &ssTableConfig{
+		blockSize:       defaultBlockSize,
+		restartInterval: defaultRestartInterval,
+		bloomBitsPerKey: defaultBloomBitsPerKey,
+		compare:         leveldb.Key.Compare,
 	}
-	valLen, err := encoding.ReadUint64(rs)
-	bytesRead += 8
-	if err != nil {
-		return bytesRead, err
+}
+
+type ssTableConfig struct {
+	blockSize       int
+	restartInterval int
+	bloomBitsPerKey int
+	compression     Compression
+	compare         func(a, b leveldb.Key) int
+	filterKey       func(key leveldb.Key) leveldb.Key
+	blockCache      *BlockCache
+	fileNumber      uint64
+}
+type ssTableOption func(*ssTableConfig)
+
+func withBlockSize(size int) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.blockSize = size
 	}
-	if valLen == 0 {
-		*entry = encoding.Entry{Key: key, Value: nil}
-		return bytesRead, nil
+}
+
+// WithBloomFilter sets the number of bits per key in the table's bloom filter.  More bits make for fewer false
+// positives, at about 1% for the default of 10; zero or less writes no filter at all.
+func WithBloomFilter(bitsPerKey int) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.bloomBitsPerKey = bitsPerKey
 	}
-	value, err := encoding.ReadByteSlice(rs, valLen)
-	bytesRead += int64(valLen)
-	if err != nil {
-		return bytesRead, err
+}
+
+// WithCompression sets the codec for the table's data blocks.
+func WithCompression(compression Compression) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.compression = compression
 	}
-	*entry = encoding.Entry{
-		Key:   key,
-		Value: value,
+}
+
+// WithRestartInterval sets the number of keys between restart points in each data block.  Fewer make for smaller
+// blocks, and more for faster seeks within them.
+func WithRestartInterval(interval int) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.restartInterval = max(interval, 1)
 	}
-	return bytesRead, nil
 }
 
-func newSSTableConfig() *ssTableConfig {
-	return &ssTableConfig{sparseIndexThreshold: sparseIndexThreshold}
+// WithKeyComparison orders the table's keys by compare instead of bytewise, as a leveldb.Comparator's Compare method
+// does.  Tables do not record their ordering, so a table must be opened with the comparison it was written with.
+func WithKeyComparison(compare func(a, b leveldb.Key) int) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.compare = compare
+	}
 }
 
-type ssTableConfig struct {
-	sparseIndexThreshold int
+// WithFilterKey has the bloom filter hold filterKey(key) for each key, rather than the key itself, so that lookups by
+// Find can be filtered by the part of the key they know in advance.
+func WithFilterKey(filterKey func(key leveldb.Key) leveldb.Key) ssTableOption {
+	return func(config *ssTableConfig) {
+		config.filterKey = filterKey
+	}
 }
-type ssTableOption func(*ssTableConfig)
 
-func withSparseIndexThreshold(threshold int) ssTableOption {
+// WithBlockCache has the table keep the data blocks it reads in blockCache, under fileNumber, which must be unique
+// among the tables sharing the cache.  A nil blockCache leaves the table uncached.  It applies to block-based tables
+// only.
+func WithBlockCache(blockCache *BlockCache, fileNumber uint64) ssTableOption {
 	return func(config *ssTableConfig) {
-		config.sparseIndexThreshold = threshold
+		config.blockCache, config.fileNumber = blockCache, fileNumber
 	}
 }
@@ -3,14 +3,16 @@ package sst
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"leveldb"
 	"leveldb/skiplist"
 	"os"
 	"slices"
+	"sync"
 	"testing"
 )
 
-const indexThreshold = 0x4 // small to force dictionary use
+const blockSize = 0x40 // small to force several blocks
 type entry struct {
 	key   string
 	value string
@@ -81,110 +83,578 @@ func TestSSTable(t *testing.T) {
 		}
 	}
 
-	sstDb, err := BuildSSTable(file, memTable, tombstones, withSparseIndexThreshold(indexThreshold))
+	built, err := BuildSSTable(file, memTable, tombstones, withBlockSize(blockSize))
 	if err != nil {
 		t.Fatal("error building SSTable:", err)
 	}
+	defer func() { _ = built.Close() }()
 
-	t.Run("Get", func(t *testing.T) {
-		t.Run("Exists", func(t *testing.T) {
-			value, err := sstDb.Get(leveldb.Key("foo"))
+	// the fixtures were written by earlier versions of BuildSSTable, from the same entries
+	for _, format := range []struct {
+		name string
+		path string
+	}{
+		{name: "Current", path: file.Name()},
+		{name: "V2", path: "testdata/v2.sst"},
+		{name: "V1", path: "testdata/v1.sst"},
+		{name: "V1WithBloomFilter", path: "testdata/v1_bloom.sst"},
+	} {
+		t.Run(format.name, func(t *testing.T) {
+			f, err := os.Open(format.path)
 			if err != nil {
-				t.Fatalf("unexpected error calling sstDb.Get(): %v", err)
+				t.Fatal("failed to open SST file:", err)
 			}
-			if bytes.Compare(leveldb.Value("bar"), value) != 0 {
-				t.Errorf("unexpected returned value.  Expected %q, got %q", "bar", value)
+			sstDb, err := NewSSTableDBFromFile(f)
+			if err != nil {
+				t.Fatal("error opening SSTable:", err)
 			}
+			defer func() { _ = sstDb.Close() }()
+
+			t.Run("Get", func(t *testing.T) {
+				t.Run("Exists", func(t *testing.T) {
+					value, err := sstDb.Get(leveldb.Key("foo"))
+					if err != nil {
+						t.Fatalf("unexpected error calling sstDb.Get(): %v", err)
+					}
+					if bytes.Compare(leveldb.Value("bar"), value) != 0 {
+						t.Errorf("unexpected returned value.  Expected %q, got %q", "bar", value)
+					}
+				})
+				t.Run("NoEntry", func(t *testing.T) {
+					_, err := sstDb.Get(leveldb.Key("baseball"))
+					if err == nil {
+						t.Error("expected error calling sstDb.Get() for non-existent value, did not get one")
+					}
+					if !errors.Is(err, leveldb.ErrKeyNotFound) {
+						t.Errorf("expected a ErrKeyNotFound, got %T: %v", err, err)
+					}
+				})
+				t.Run("NoEntryPastLastKey", func(t *testing.T) {
+					_, err := sstDb.Get(leveldb.Key("zzz"))
+					if !errors.Is(err, leveldb.ErrKeyNotFound) {
+						t.Errorf("expected a ErrKeyNotFound, got %T: %v", err, err)
+					}
+				})
+				t.Run("Tombstoned", func(t *testing.T) {
+					_, err := sstDb.Get(leveldb.Key("spam"))
+					if err == nil {
+						t.Error("expected error calling sstDb.Get() for tombstoned value, did not get one")
+					}
+					if !errors.Is(err, leveldb.ErrKeyTombstoned) {
+						t.Errorf("expected a ErrKeyTombstoned, got %T: %v", err, err)
+					}
+					if !errors.Is(err, leveldb.ErrKeyNotFound) {
+						t.Errorf("expected a ErrKeyNotFound, got %T: %v", err, err)
+					}
+				})
+			})
+
+			t.Run("Has", func(t *testing.T) {
+				t.Run("Exists", func(t *testing.T) {
+					exists, err := sstDb.Has(leveldb.Key("foo"))
+					if err != nil {
+						t.Fatalf("unexpected error calling sstDb.Has(): %v", err)
+					}
+					if !exists {
+						t.Error("unexpected returned value.  Expected true, got false")
+					}
+				})
+				t.Run("NoEntry", func(t *testing.T) {
+					var key = leveldb.Key("baseball")
+					exists, err := sstDb.Has(key)
+					if err != nil {
+						t.Errorf("unexpected error calling sstDb.Has() for non-existent value: %v", err)
+					}
+					if exists {
+						t.Errorf("expected %q not to exists, got exists", key)
+					}
+				})
+				t.Run("Tombstoned", func(t *testing.T) {
+					var key = leveldb.Key("spam")
+					exists, err := sstDb.Has(key)
+					if err != nil {
+						t.Errorf("unexpected error calling sstDb.Has() for tombstoned value: %v", err)
+					}
+					if exists {
+						t.Errorf("expected %q not to exists, got exists", key)
+					}
+				})
+			})
+
+			t.Run("RangeScan", func(t *testing.T) {
+				t.Run("NonEmptyIterator", func(t *testing.T) {
+					startIdx := slices.IndexFunc(testData, func(e entry) bool {
+						return e.key == "frolic"
+					})
+					endIdx := slices.IndexFunc(testData, func(e entry) bool {
+						return e.key == "whiskey"
+					})
+					expectedRange := testData[startIdx : endIdx+1]
+
+					results, err := sstDb.RangeScan(
+						leveldb.Key("frog"), // deleted entry before "frolic" to expect "frolic" first
+						leveldb.Key("whiskey"),
+					)
+					if err != nil {
+						t.Fatalf("error executing RangeScan: %v", err)
+					}
+					defer results.Close()
+					var j int
+					for results.Next() {
+						expectedResult := expectedRange[j]
+						if expectedResult.key != string(results.Key()) || expectedResult.value != string(results.Value()) {
+							t.Errorf("expected  %q=%q, got %q=%q", expectedResult.key, expectedResult.value, results.Key(), results.Value())
+						}
+						j++
+					}
+				})
+				t.Run("EmptyIterator", func(t *testing.T) {
+					results, err := sstDb.RangeScan( // two tombstoned keys
+						leveldb.Key("aardvark"),
+						leveldb.Key("ajax"),
+					)
+					if err != nil {
+						t.Fatalf("unexpected error executing RangeScan(): %v", err)
+					}
+					defer results.Close()
+					if results.Next() {
+						t.Errorf("expected zero results, got %q=%q", results.Key(), results.Value())
+					}
+				})
+				t.Run("SharedByReaders", func(t *testing.T) {
+					// each iterator keeps its own position, so that lookups and other iterators reading between its
+					// moves leave it be
+					var wg sync.WaitGroup
+					for range 4 {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							results, err := sstDb.RangeScan(leveldb.Key("alpha"), leveldb.Key("zebra"))
+							if err != nil {
+								t.Errorf("error executing RangeScan: %v", err)
+								return
+							}
+							defer results.Close()
+							var j int
+							for ; results.Next(); j++ {
+								if j >= len(testData) || string(results.Key()) != testData[j].key {
+									t.Errorf("expected key %d to be in order, got %q", j, results.Key())
+									return
+								}
+								var key = leveldb.Key(testData[len(testData)-1-j].key)
+								if _, err := sstDb.Get(key); err != nil {
+									t.Errorf("unexpected error calling sstDb.Get(%q) mid-scan: %v", key, err)
+									return
+								}
+							}
+							if err := results.Error(); err != nil || j != len(testData) {
+								t.Errorf("expected all %d keys, got %d (err %v)", len(testData), j, err)
+							}
+						}()
+					}
+					wg.Wait()
+				})
+				t.Run("Backward", func(t *testing.T) {
+					results, err := sstDb.RangeScan(leveldb.Key("frog"), leveldb.Key("whiskey"))
+					if err != nil {
+						t.Fatalf("error executing RangeScan: %v", err)
+					}
+					defer results.Close()
+					var keys []string
+					for ok := results.SeekToLast(); ok; ok = results.Prev() {
+						keys = append(keys, string(results.Key()))
+					}
+					if fmt.Sprint(keys) != "[whiskey victor uniform tango sierra romeo quebec papa oscar november "+
+						"mike kilo juliett igloo hotel hostel grape golf glazed garage frolic]" {
+						t.Errorf("expected the keys in range last to first, got %v", keys)
+					}
+					if !results.Next() || string(results.Key()) != "frolic" {
+						t.Errorf("expected Next() from before the first key to find %q, got %q", "frolic", results.Key())
+					}
+					if err := results.Error(); err != nil {
+						t.Error("iterator generated unexpected error", err)
+					}
+				})
+				t.Run("Seek", func(t *testing.T) {
+					results, err := sstDb.RangeScan(leveldb.Key("frog"), leveldb.Key("whiskey"))
+					if err != nil {
+						t.Fatalf("error executing RangeScan: %v", err)
+					}
+					defer results.Close()
+					for _, seek := range []struct {
+						key, expected string
+					}{
+						{key: "hovel", expected: "igloo"}, // tombstoned
+						{key: "alpha", expected: "frolic"},
+						{key: "tango", expected: "tango"},
+						{key: "whiskey", expected: "whiskey"},
+					} {
+						if !results.Seek(leveldb.Key(seek.key)) || string(results.Key()) != seek.expected {
+							t.Errorf("expected Seek(%q) to find %q, got %q", seek.key, seek.expected, results.Key())
+						}
+					}
+					if results.Seek(leveldb.Key("x-ray")) {
+						t.Errorf("expected Seek() past the range to find nothing, got %q", results.Key())
+					}
+					if !results.Prev() || string(results.Key()) != "whiskey" {
+						t.Errorf("expected Prev() from past the range to find %q, got %q", "whiskey", results.Key())
+					}
+					if !results.Seek(leveldb.Key("igloo")) || !results.Prev() || string(results.Key()) != "hotel" {
+						t.Errorf("expected Prev() from %q to find %q, got %q", "igloo", "hotel", results.Key())
+					}
+					if !results.Next() || string(results.Key()) != "igloo" {
+						t.Errorf("expected Next() to turn back to %q, got %q", "igloo", results.Key())
+					}
+				})
+			})
 		})
-		t.Run("NoEntry", func(t *testing.T) {
-			_, err := sstDb.Get(leveldb.Key("baseball"))
-			if err == nil {
-				t.Error("expected error calling sstDb.Get() for non-existent value, did not get one")
+	}
+}
+
+func TestSSTable_BloomFilter(t *testing.T) {
+	// absent keys fall between the keys in the table, so that the index alone can't rule them out
+	const absentKeys = 998
+	var memTable, tombstones = skiplist.NewSkipList(), skiplist.NewSkipList()
+	for j := range 1000 {
+		if err := memTable.Insert(leveldb.Key(fmt.Sprintf("key%04d", 2*j)), leveldb.Value("value")); err != nil {
+			t.Fatalf("error inserting key into memTable skiplist: %v", err)
+		}
+	}
+	if err := tombstones.Insert(leveldb.Key("key0001"), nil); err != nil {
+		t.Fatalf("error inserting into tombstone skiplist: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		bitsPerKey int
+		// maxReads bounds how many of the absent keys may cause data to be read
+		maxReads int
+	}{
+		{name: "Default", bitsPerKey: defaultBloomBitsPerKey, maxReads: 30},
+		{name: "NoFilter", bitsPerKey: 0, maxReads: absentKeys},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.CreateTemp(t.TempDir(), "sst")
+			if err != nil {
+				t.Fatal("failed to create SST file:", err)
+			}
+			built, err := BuildSSTable(file, memTable, tombstones, WithBloomFilter(tc.bitsPerKey))
+			if err != nil {
+				t.Fatal("error building SSTable:", err)
 			}
-			if !errors.Is(err, leveldb.ErrKeyNotFound) {
-				t.Errorf("expected a ErrKeyNotFound, got %T: %v", err, err)
+			var counter = &countingReaderAt{File: file}
+			sstDb, err := NewSSTableDBFromFile(counter)
+			if err != nil {
+				t.Fatal("error reopening SSTable:", err)
 			}
-		})
-		t.Run("Tombstoned", func(t *testing.T) {
-			_, err := sstDb.Get(leveldb.Key("spam"))
-			if err == nil {
-				t.Error("expected error calling sstDb.Get() for tombstoned value, did not get one")
+			defer func() { _ = built.Close() }()
+
+			counter.reads = 0
+			var readsFor int
+			for j := range absentKeys {
+				var before = counter.reads
+				if exists, err := sstDb.Has(leveldb.Key(fmt.Sprintf("key%04d", 2*j+3))); err != nil || exists {
+					t.Fatalf("expected key to be absent, got %t (err %v)", exists, err)
+				}
+				if counter.reads > before {
+					readsFor++
+				}
 			}
-			if !errors.Is(err, leveldb.ErrKeyNotFound) {
-				t.Errorf("expected a ErrKeyNotFound, got %T: %v", err, err)
+			if readsFor > tc.maxReads {
+				t.Errorf("expected at most %d absent keys to read data, %d did", tc.maxReads, readsFor)
+			}
+			if tc.bitsPerKey == 0 && readsFor != absentKeys {
+				t.Errorf("expected every absent key to read data without a filter, %d did", readsFor)
 			}
-		})
-	})
 
-	t.Run("Has", func(t *testing.T) {
-		t.Run("Exists", func(t *testing.T) {
-			exists, err := sstDb.Has(leveldb.Key("foo"))
-			if err != nil {
-				t.Fatalf("unexpected error calling sstDb.Has(): %v", err)
+			// keys in the table, tombstones included, must never be filtered out
+			for j := range 1000 {
+				if exists, err := sstDb.Has(leveldb.Key(fmt.Sprintf("key%04d", 2*j))); err != nil || !exists {
+					t.Fatalf("expected key to exist, got %t (err %v)", exists, err)
+				}
 			}
-			if !exists {
-				t.Error("unexpected returned value.  Expected true, got false")
+			if _, err := sstDb.Get(leveldb.Key("key0001")); !errors.Is(err, leveldb.ErrKeyTombstoned) {
+				t.Errorf("expected a ErrKeyTombstoned, got %T: %v", err, err)
 			}
 		})
-		t.Run("NoEntry", func(t *testing.T) {
-			var key = leveldb.Key("baseball")
-			exists, err := sstDb.Has(key)
+	}
+}
+
+func TestSSTable_KeyComparison(t *testing.T) {
+	var (
+		reverse    = func(a, b leveldb.Key) int { return bytes.Compare(b, a) }
+		memTable   = skiplist.NewSkipListWithKeyComparison(reverse)
+		tombstones = skiplist.NewSkipListWithKeyComparison(reverse)
+	)
+	for j := range 100 {
+		if err := memTable.Insert(leveldb.Key(fmt.Sprintf("key%03d", j)), leveldb.Value("value")); err != nil {
+			t.Fatalf("error inserting key into memTable skiplist: %v", err)
+		}
+	}
+	if err := tombstones.Insert(leveldb.Key("key100"), nil); err != nil {
+		t.Fatalf("error inserting into tombstone skiplist: %v", err)
+	}
+	file, err := os.CreateTemp(t.TempDir(), "sst")
+	if err != nil {
+		t.Fatal("failed to create SST file:", err)
+	}
+	built, err := BuildSSTable(file, memTable, tombstones, withBlockSize(blockSize), WithKeyComparison(reverse))
+	if err != nil {
+		t.Fatal("error building SSTable:", err)
+	}
+	defer func() { _ = built.Close() }()
+	sstDb, err := NewSSTableDBFromFile(file, WithKeyComparison(reverse))
+	if err != nil {
+		t.Fatal("error reopening SSTable:", err)
+	}
+
+	if value, err := sstDb.Get(leveldb.Key("key042")); err != nil || string(value) != "value" {
+		t.Errorf("expected Get() to find %q, got %q (err %v)", "value", value, err)
+	}
+	if _, err := sstDb.Get(leveldb.Key("key100")); !errors.Is(err, leveldb.ErrKeyTombstoned) {
+		t.Errorf("expected a ErrKeyTombstoned, got %T: %v", err, err)
+	}
+	// in reverse, the range from key060 to key050 holds the keys between them counting down
+	iterator, err := sstDb.RangeScan(leveldb.Key("key060"), leveldb.Key("key050"))
+	if err != nil {
+		t.Fatal("unexpected error executing RangeScan()", err)
+	}
+	defer iterator.Close()
+	var keys []string
+	for iterator.Next() {
+		keys = append(keys, string(iterator.Key()))
+	}
+	if len(keys) != 11 || keys[0] != "key060" || keys[10] != "key050" {
+		t.Errorf("expected the keys from key060 down to key050, got %v", keys)
+	}
+}
+
+func TestSSTable_RangeTombstones(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "sst")
+	if err != nil {
+		t.Fatal("failed to create SST file:", err)
+	}
+	writer, err := NewWriter(file, withBlockSize(blockSize))
+	if err != nil {
+		t.Fatal("error creating writer:", err)
+	}
+	for j := range 50 {
+		if err := writer.Add(leveldb.Key(fmt.Sprintf("key%03d", j)), leveldb.Value("value")); err != nil {
+			t.Fatal("unexpected error adding entry:", err)
+		}
+	}
+	for _, tombstone := range []leveldb.DataEntry{
+		{Key: leveldb.Key("key010"), Value: leveldb.Value("key019")},
+		{Key: leveldb.Key("key030"), Value: leveldb.Value("key099")},
+	} {
+		if err := writer.AddRangeTombstone(tombstone.Key, tombstone.Value); err != nil {
+			t.Fatal("unexpected error adding range tombstone:", err)
+		}
+	}
+	if err := writer.AddRangeTombstone(leveldb.Key("key020"), leveldb.Value("key025")); err == nil {
+		t.Error("expected a range tombstone added out of order to be rejected")
+	}
+	built, err := writer.Finish()
+	if err != nil {
+		t.Fatal("error finishing SSTable:", err)
+	}
+	defer func() { _ = built.Close() }()
+	sstDb, err := NewSSTableDBFromFile(file)
+	if err != nil {
+		t.Fatal("error reopening SSTable:", err)
+	}
+
+	if tombstones := fmt.Sprint(sstDb.RangeTombstones()); tombstones != "[{key010 key019} {key030 key099}]" {
+		t.Errorf("expected the range tombstones added, got %v", tombstones)
+	}
+	// range tombstones are kept apart from the table's entries, which they leave as they are
+	if value, err := sstDb.Get(leveldb.Key("key042")); err != nil || string(value) != "value" {
+		t.Errorf("expected Get() to find %q, got %q (err %v)", "value", value, err)
+	}
+	if _, err := sstDb.Get(leveldb.Key("key050")); !errors.Is(err, leveldb.ErrKeyNotFound) {
+		t.Errorf("expected a ErrKeyNotFound past the last entry, got %T: %v", err, err)
+	}
+}
+
+func TestSSTable_BlockCache(t *testing.T) {
+	var memTable = skiplist.NewSkipList()
+	for j := range 200 {
+		if err := memTable.Insert(leveldb.Key(fmt.Sprintf("key%04d", j)), leveldb.Value("value")); err != nil {
+			t.Fatalf("error inserting key into memTable skiplist: %v", err)
+		}
+	}
+	file, err := os.CreateTemp(t.TempDir(), "sst")
+	if err != nil {
+		t.Fatal("failed to create SST file:", err)
+	}
+	built, err := BuildSSTable(file, memTable, skiplist.NewSkipList(), withBlockSize(blockSize))
+	if err != nil {
+		t.Fatal("error building SSTable:", err)
+	}
+	defer func() { _ = built.Close() }()
+	var (
+		blockCache = NewBlockCache(1 << 20)
+		counter    = &countingReaderAt{File: file}
+	)
+	sstDb, err := NewSSTableDBFromFile(counter, WithBlockCache(blockCache, 1))
+	if err != nil {
+		t.Fatal("error reopening SSTable:", err)
+	}
+
+	// scan counts the reads made scanning the whole table
+	var scan = func(opts ...leveldb.ReadOption) int {
+		var before = counter.reads
+		results, err := sstDb.RangeScan(leveldb.Key("key"), leveldb.Key("key9999"), opts...)
+		if err != nil {
+			t.Fatalf("error executing RangeScan: %v", err)
+		}
+		defer results.Close()
+		for results.Next() {
+		}
+		if err := results.Error(); err != nil {
+			t.Fatal("iterator generated unexpected error", err)
+		}
+		return counter.reads - before
+	}
+
+	t.Run("BypassCache", func(t *testing.T) {
+		if reads := scan(leveldb.WithBypassCache(true)); reads == 0 {
+			t.Fatal("expected a scan of an empty cache to read blocks")
+		}
+		if stats := blockCache.Stats(); stats.Charge != 0 || stats.Hits != 0 {
+			t.Errorf("expected a bypassing scan to cache nothing, got %+v", stats)
+		}
+	})
+	t.Run("ScanFillsCache", func(t *testing.T) {
+		if reads := scan(); reads == 0 {
+			t.Fatal("expected a scan of an empty cache to read blocks")
+		}
+		if reads := scan(); reads != 0 {
+			t.Errorf("expected a second scan to be served from the cache, got %d reads", reads)
+		}
+		var before = blockCache.Stats()
+		if _, err := sstDb.Get(leveldb.Key("key0100")); err != nil {
+			t.Fatalf("unexpected error calling sstDb.Get(): %v", err)
+		}
+		if after := blockCache.Stats(); after.Hits != before.Hits+1 || after.Misses != before.Misses {
+			t.Errorf("expected a lookup to hit the cache, went from %+v to %+v", before, after)
+		}
+	})
+}
+
+func TestSSTable_Corruption(t *testing.T) {
+	var memTable = skiplist.NewSkipList()
+	for j := range 100 {
+		if err := memTable.Insert(leveldb.Key(fmt.Sprintf("key%04d", j)), leveldb.Value("value")); err != nil {
+			t.Fatalf("error inserting key into memTable skiplist: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		// corrupt damages the table's contents, given its index
+		corrupt func(contents []byte, index []indexEntry) []byte
+		// failsOpen says whether the damage is found on opening the table, rather than on reading the first block
+		failsOpen bool
+	}{
+		{
+			name: "DataBlockChecksum",
+			corrupt: func(contents []byte, index []indexEntry) []byte {
+				contents[index[0].handle.offset+3] ^= 0x10
+				return contents
+			},
+		},
+		{
+			name: "IndexBlockChecksum",
+			corrupt: func(contents []byte, index []indexEntry) []byte {
+				contents[len(contents)-footerSize-blockTrailerSize-1] ^= 0x10
+				return contents
+			},
+			failsOpen: true,
+		},
+		{
+			name: "Truncated",
+			corrupt: func(contents []byte, index []indexEntry) []byte {
+				return contents[:len(contents)-1]
+			},
+			failsOpen: true,
+		},
+		{
+			name: "UnsupportedVersion",
+			corrupt: func(contents []byte, index []indexEntry) []byte {
+				contents[len(contents)-16] = 0xff
+				return contents
+			},
+			failsOpen: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			file, err := os.CreateTemp(t.TempDir(), "sst")
 			if err != nil {
-				t.Errorf("unexpected error calling sstDb.Has() for non-existent value: %v", err)
+				t.Fatal("failed to create SST file:", err)
 			}
-			if exists {
-				t.Errorf("expected %q not to exists, got exists", key)
+			built, err := BuildSSTable(file, memTable, skiplist.NewSkipList(), withBlockSize(blockSize))
+			if err != nil {
+				t.Fatal("error building SSTable:", err)
 			}
-		})
-		t.Run("Tombstoned", func(t *testing.T) {
-			var key = leveldb.Key("spam")
-			exists, err := sstDb.Has(key)
+			contents, err := os.ReadFile(file.Name())
 			if err != nil {
-				t.Errorf("unexpected error calling sstDb.Has() for tombstoned value: %v", err)
+				t.Fatal("error reading SSTable:", err)
 			}
-			if exists {
-				t.Errorf("expected %q not to exists, got exists", key)
+			_ = built.Close()
+			if err := os.WriteFile(file.Name(), tc.corrupt(contents, built.index), 0o644); err != nil {
+				t.Fatal("error corrupting SSTable:", err)
 			}
-		})
-	})
-
-	t.Run("RangeScan", func(t *testing.T) {
-		t.Run("NonEmptyIterator", func(t *testing.T) {
-			startIdx := slices.IndexFunc(testData, func(e entry) bool {
-				return e.key == "frolic"
-			})
-			endIdx := slices.IndexFunc(testData, func(e entry) bool {
-				return e.key == "whiskey"
-			})
-			expectedRange := testData[startIdx : endIdx+1]
 
-			results, err := sstDb.RangeScan(
-				leveldb.Key("frog"), // deleted entry before "frolic" to expect "frolic" first
-				leveldb.Key("whiskey"),
-			)
+			f, err := os.Open(file.Name())
 			if err != nil {
-				t.Fatalf("error executing RangeScan: %v", err)
+				t.Fatal("failed to open SST file:", err)
 			}
-			var j int
-			for results.Next() {
-				expectedResult := expectedRange[j]
-				if expectedResult.key != string(results.Key()) || expectedResult.value != string(results.Value()) {
-					t.Errorf("expected  %q=%q, got %q=%q", expectedResult.key, expectedResult.value, results.Key(), results.Value())
+			defer func() { _ = f.Close() }()
+			var corruption *CorruptionError
+			sstDb, err := NewSSTableDBFromFile(f)
+			if tc.failsOpen {
+				if !errors.As(err, &corruption) {
+					t.Errorf("expected a CorruptionError opening the table, got %T: %v", err, err)
 				}
-				j++
+				return
+			} else if err != nil {
+				t.Fatal("error opening SSTable:", err)
 			}
-		})
-		t.Run("EmptyIterator", func(t *testing.T) {
-			results, err := sstDb.RangeScan( // two tombstoned keys
-				leveldb.Key("aardvark"),
-				leveldb.Key("ajax"),
-			)
+
+			if _, err := sstDb.Get(leveldb.Key("key0000")); !errors.As(err, &corruption) {
+				t.Errorf("expected a CorruptionError from Get(), got %T: %v", err, err)
+			} else if corruption.Offset != int64(built.index[0].handle.offset) {
+				t.Errorf("expected corruption at offset %d, got %d", built.index[0].handle.offset, corruption.Offset)
+			}
+			iterator, err := sstDb.RangeScan(leveldb.Key("key0000"), leveldb.Key("key0099"))
 			if err != nil {
-				t.Fatalf("unexpected error executing RangeScan(): %v", err)
+				t.Fatal("unexpected error executing RangeScan()", err)
 			}
-			if results.Next() {
-				t.Errorf("expected zero results, got %q=%q", results.Key(), results.Value())
+			defer iterator.Close()
+			if iterator.Next() {
+				t.Errorf("expected no results from a corrupt block, got %q=%q", iterator.Key(), iterator.Value())
+			}
+			if !errors.As(iterator.Error(), &corruption) {
+				t.Errorf("expected a CorruptionError from the iterator, got %T: %v", iterator.Error(), iterator.Error())
+			}
+			// blocks past the corrupt one are still readable
+			if value, err := sstDb.Get(leveldb.Key("key0099")); err != nil || string(value) != "value" {
+				t.Errorf("expected %q=%q, got %q (err %v)", "key0099", "value", value, err)
 			}
 		})
-	})
+	}
+}
+
+// countingReaderAt counts the calls to ReadAt on the underlying file.
+type countingReaderAt struct {
+	*os.File
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	return r.File.ReadAt(p, off)
 }
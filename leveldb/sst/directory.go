@@ -89,13 +89,16 @@ func NewDirectory(sparseKeys []leveldb.Key, offsets []offset) (*Directory, error
 	}, nil
 }
 
-func (dir *Directory) offsetFor(searchKey leveldb.Key) (offset, error) {
-	if len(dir.sparseKeys) == 0 {
+// offsetFor returns the offset of the greatest sparse key less than or equal to searchKey, as ordered by compare, or
+// the start of the data if every sparse key is greater than searchKey.
+func (dir *Directory) offsetFor(searchKey leveldb.Key, compare func(a, b leveldb.Key) int) (offset, error) {
+	offsetIndex, found := slices.BinarySearchFunc(dir.sparseKeys, searchKey, compare)
+	if !found {
+		offsetIndex-- // BinarySearchFunc gives us the insertion point, we want the key preceding it
+	}
+	if offsetIndex < 0 {
 		return dataOffset, nil
 	}
-	offsetIndex, _ := slices.BinarySearchFunc(dir.sparseKeys, searchKey, func(key, key2 leveldb.Key) int {
-		return bytes.Compare(key, key2)
-	})
 
 	return dir.offsets[offsetIndex], nil
 }
@@ -0,0 +1,113 @@
+package sst
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+const (
+	// formatVersion1 tables have a header, raw entries, a sparse directory and optionally a bloom filter; see v1.go.
+	formatVersion1 = 1
+	// formatVersion2 tables are made of checksummed blocks and end with a footer; see BuildSSTable.
+	formatVersion2 = 2
+	// formatVersion3 tables prefix-compress the keys within each block, and add restart points to seek by; see
+	// block.go.
+	formatVersion3 = 3
+	// formatVersion4 tables add a block of range tombstones, located by a longer footer; see BuildSSTable.  Tables
+	// without range tombstones are still written as formatVersion3.
+	formatVersion4 = 4
+
+	// tableMagic ends every table from formatVersion2 on, and tells them apart from formatVersion1 tables.
+	tableMagic uint64 = 0x4244_4c56_4c54_5353 // "SSTLVLDB" in little endian
+
+	blockHandleSize  = 16
+	footerSize       = 2*blockHandleSize + 16
+	footerSizeV4     = footerSize + blockHandleSize
+	blockTrailerSize = 5
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// byteOrder is encoding.ByteOrder, with the append methods of its concrete type.
+var byteOrder = binary.LittleEndian
+
+// CorruptionError reports a table whose contents fail validation, such as a block with a bad checksum or a footer
+// without the magic number.
+type CorruptionError struct {
+	// Offset is where in the file the corrupt data starts.
+	Offset int64
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("sst: corrupted table at offset %d: %s", e.Offset, e.Reason)
+}
+
+func newCorruptionError(offset int64, format string, args ...any) *CorruptionError {
+	return &CorruptionError{Offset: offset, Reason: fmt.Sprintf(format, args...)}
+}
+
+// blockHandle locates a block within a table.  size is the size of the block as stored, so compressed if it is, and
+// excludes the block's trailer.
+type blockHandle struct {
+	offset uint64
+	size   uint64
+}
+
+func (h blockHandle) encode(dst []byte) []byte {
+	dst = byteOrder.AppendUint64(dst, h.offset)
+	return byteOrder.AppendUint64(dst, h.size)
+}
+
+func decodeBlockHandle(src []byte) blockHandle {
+	return blockHandle{
+		offset: byteOrder.Uint64(src),
+		size:   byteOrder.Uint64(src[8:]),
+	}
+}
+
+// footer is the fixed-size end of a table, which locates the index and filter blocks, and from formatVersion4 on the
+// range tombstone block.
+type footer struct {
+	// rangeTombstones is only encoded from formatVersion4 on
+	rangeTombstones blockHandle
+	index           blockHandle
+	// filter has a zero size if the table has no bloom filter
+	filter  blockHandle
+	version uint64
+}
+
+// size returns the length of the encoded footer, which depends on its version.
+func (f footer) size() int {
+	if f.version >= formatVersion4 {
+		return footerSizeV4
+	}
+	return footerSize
+}
+
+func (f footer) encode() []byte {
+	var buf = make([]byte, 0, f.size())
+	if f.version >= formatVersion4 {
+		buf = f.rangeTombstones.encode(buf)
+	}
+	buf = f.index.encode(buf)
+	buf = f.filter.encode(buf)
+	buf = byteOrder.AppendUint64(buf, f.version)
+	return byteOrder.AppendUint64(buf, tableMagic)
+}
+
+// hasFooter reports whether buf, the last footerSize bytes of a file, ends with the magic number.
+func hasFooter(buf []byte) bool {
+	return len(buf) == footerSize && byteOrder.Uint64(buf[footerSize-8:]) == tableMagic
+}
+
+// decodeFooter decodes the last footerSize bytes of a footer.  From formatVersion4 on, the rangeTombstones handle
+// before them is decoded separately.
+func decodeFooter(buf []byte) footer {
+	return footer{
+		index:   decodeBlockHandle(buf),
+		filter:  decodeBlockHandle(buf[blockHandleSize:]),
+		version: byteOrder.Uint64(buf[2*blockHandleSize:]),
+	}
+}
@@ -0,0 +1,128 @@
+package sst
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+/**
+ * LZ-compressed block:
+ * | varint                | arbitrarily long |
+ * | [decompressed length] | [tokens]         |
+ *
+ * literal token, copying the bytes that follow it to the output:
+ * | 1 byte              | 1 to 128 bytes |
+ * | [0 | length - 1]    | [literal]      |
+ *
+ * copy token, repeating bytes already in the output:
+ * | 1 byte              | varint                    |
+ * | [1 | length - 4]    | [distance back to copy from] |
+ *
+ * A copy may overlap the bytes it produces, so that runs of a repeated byte or pattern cost a single token.
+ */
+
+const (
+	lzMinMatch   = 4
+	lzMaxLiteral = 0x80
+	lzMaxCopy    = 0x7f + lzMinMatch
+	lzHashBits   = 14
+)
+
+var errLZCorrupted = errors.New("corrupted LZ block")
+
+// lzCompress compresses src, finding earlier occurrences of each 4 bytes through a hash table of where they were last
+// seen.  Like Snappy and LZ4 it favours speed over ratio: it takes the first match it finds rather than searching for
+// the longest.
+func lzCompress(src []byte) []byte {
+	var (
+		dst          = binary.AppendUvarint(make([]byte, 0, len(src)/2+16), uint64(len(src)))
+		table        [1 << lzHashBits]int32 // position + 1 of the last occurrence of each hash, zero if none
+		literalStart int
+		j            int
+	)
+	for j+lzMinMatch <= len(src) {
+		var (
+			sequence  = binary.LittleEndian.Uint32(src[j:])
+			hash      = (sequence * 0x1e35a7bd) >> (32 - lzHashBits)
+			candidate = int(table[hash]) - 1
+		)
+		table[hash] = int32(j + 1)
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != sequence {
+			j++
+			continue
+		}
+		var length = lzMinMatch
+		for j+length < len(src) && src[candidate+length] == src[j+length] {
+			length++
+		}
+		dst = appendLZLiterals(dst, src[literalStart:j])
+		dst = appendLZCopies(dst, j-candidate, length)
+		j += length
+		literalStart = j
+	}
+	return appendLZLiterals(dst, src[literalStart:])
+}
+
+func appendLZLiterals(dst []byte, literals []byte) []byte {
+	for len(literals) > 0 {
+		var n = min(len(literals), lzMaxLiteral)
+		dst = append(dst, byte(n-1))
+		dst = append(dst, literals[:n]...)
+		literals = literals[n:]
+	}
+	return dst
+}
+
+func appendLZCopies(dst []byte, distance int, length int) []byte {
+	for length > 0 {
+		var n = min(length, lzMaxCopy)
+		if remaining := length - n; remaining > 0 && remaining < lzMinMatch {
+			n = length - lzMinMatch // leave enough for another copy token
+		}
+		dst = append(dst, 0x80|byte(n-lzMinMatch))
+		dst = binary.AppendUvarint(dst, uint64(distance))
+		length -= n
+	}
+	return dst
+}
+
+// lzDecompress reverses lzCompress.
+func lzDecompress(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	// no token expands more than 131 times, which bounds what we allocate for a corrupt length
+	if n <= 0 || length > uint64(len(src))*lzMaxCopy {
+		return nil, errLZCorrupted
+	}
+	src = src[n:]
+	var dst = make([]byte, 0, length)
+	for len(src) > 0 {
+		var token = src[0]
+		src = src[1:]
+		if token&0x80 == 0 {
+			var literalLength = int(token) + 1
+			if literalLength > len(src) {
+				return nil, errLZCorrupted
+			}
+			dst, src = append(dst, src[:literalLength]...), src[literalLength:]
+		} else {
+			var copyLength = int(token&0x7f) + lzMinMatch
+			distance, n := binary.Uvarint(src)
+			if n <= 0 || distance == 0 || distance > uint64(len(dst)) {
+				return nil, errLZCorrupted
+			}
+			src = src[n:]
+			// byte by byte, since the copy may overlap the bytes it produces
+			for start := len(dst) - int(distance); copyLength > 0; copyLength-- {
+				dst = append(dst, dst[start])
+				start++
+			}
+		}
+		if uint64(len(dst)) > length {
+			return nil, errLZCorrupted
+		}
+	}
+	if uint64(len(dst)) != length {
+		return nil, errLZCorrupted
+	}
+	return dst, nil
+}
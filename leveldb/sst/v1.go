@@ -0,0 +1,377 @@
+package sst
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"leveldb"
+	"leveldb/encoding"
+)
+
+/**
+ * Tables written before formatVersion2 have no checksums, and are read with the functions in this file.
+ *
+ * format:
+ * | 8 bytes (int64)    | 8 bytes 		   | arbitrarily long | arbitrarily long		  | arbitrarily long |
+ * | [directory offset] | directory size   |     [data]       | [directory entries]      | (bloom filter)   |
+ *
+ * data:
+ * | 8 bytes   |  arbitrary |  8 bytes    |  [0, arbitrary) |
+ * | [key len] | [key]		| [value len] | (value) 	   |
+ *
+ * , where [value len] is 0 if key is tombstoned, and value omitted in this case
+ *
+ * directory entry:
+ * | 8 bytes   |  arbitrary |  8 bytes      |
+ * | [key len] | [key]		| [file offset] |
+ *
+ * , and the bloom filter (see bloomFilter) runs to the end of the file.  Tables written without one end with the
+ * directory, and every lookup reads their data.
+ */
+
+const dataOffset = 0x10
+
+// openV1 opens a formatVersion1 table of the given size.
+func openV1(reader io.ReaderAt, fileSize int64, config *ssTableConfig) (*SSTableDB, error) {
+	var (
+		bufReader = bufio.NewReader(io.NewSectionReader(reader, 0, fileSize))
+		err       error
+	)
+	// START: read directory metadata
+	endOfDataOffset, err := encoding.ReadUint64(bufReader)
+	if err != nil {
+		return nil, newCorruptionError(0, "no footer, and no v1 header: %v", err)
+	}
+	dirLen, err := encoding.ReadUint64(bufReader)
+	if err != nil {
+		return nil, newCorruptionError(0, "no footer, and no v1 header: %v", err)
+	}
+	if endOfDataOffset < dataOffset || endOfDataOffset > uint64(fileSize) || dirLen > uint64(fileSize)-endOfDataOffset {
+		return nil, newCorruptionError(0, "no footer, and v1 header locates directory outside the file")
+	}
+	// END: read directory metadata
+	// START: read directory
+	var directory = NewBlankDirectory()
+	if dirLen > 0 {
+		directoryBuf := make([]byte, dirLen)
+		if _, err := reader.ReadAt(directoryBuf, int64(endOfDataOffset)); err != nil {
+			return nil, fmt.Errorf("sst.NewSSTableDBFromFile: error reading directory contents: %v", err)
+		}
+		if err := directory.Decode(directoryBuf); err != nil {
+			return nil, newCorruptionError(int64(endOfDataOffset), "error decoding directory contents: %v", err)
+		}
+	}
+	// END: read directory
+	// START: read bloom filter
+	var filter bloomFilter
+	if filterLen := fileSize - int64(endOfDataOffset+dirLen); filterLen > 0 {
+		filter = make(bloomFilter, filterLen)
+		if _, err := reader.ReadAt(filter, int64(endOfDataOffset+dirLen)); err != nil {
+			return nil, fmt.Errorf("NewSSTableDBFromFile: error reading bloom filter: %v", err)
+		}
+	}
+	// END: read bloom filter
+	return &SSTableDB{
+		reader:          reader,
+		version:         formatVersion1,
+		endOfDataOffset: int64(endOfDataOffset),
+		dir:             directory,
+		filter:          filter,
+		compare:         config.compare,
+	}, nil
+}
+
+func (db *SSTableDB) getV1(searchKey leveldb.Key) (leveldb.Value, error) {
+	cursor, err := db.cursorTowards(searchKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry = new(encoding.Entry)
+	for !cursor.atEndOfData() {
+		if entry, err = cursor.next(); err != nil {
+			return nil, err
+		}
+
+		var comparison = db.compare(leveldb.Key(entry.Key), searchKey)
+		if comparison > 0 {
+			return nil, leveldb.NewNotFoundError(searchKey)
+		} else if comparison == 0 {
+			if len(entry.Value) == 0 {
+				// valLen == 0 implies the key has been tombstoned.  Report that distinctly so callers consulting
+				// multiple tables know not to look in older ones.
+				return nil, leveldb.NewTombstonedError(searchKey)
+			}
+
+			break
+		} else {
+			entry = new(encoding.Entry)
+			continue
+		}
+	}
+	if entry.IsZeroEntry() {
+		return nil, leveldb.NewNotFoundError(searchKey)
+	} else {
+		return leveldb.Value(entry.Value), nil
+	}
+}
+
+func (db *SSTableDB) rangeScanV1(start leveldb.Key, limit leveldb.Key, includeTombstones bool) (*Iterator, error) {
+	cursor, err := db.cursorTowards(start)
+	if err != nil {
+		return nil, err
+	}
+	// the iterator starts at the first entry gte our key
+	var startOffset = cursor.offset
+	for !cursor.atEndOfData() {
+		entry, err := cursor.next()
+		if err != nil {
+			return nil, err
+		}
+		if db.compare(leveldb.Key(entry.Key), start) >= 0 {
+			break
+		}
+		startOffset = cursor.offset
+	}
+
+	var iterator = NewIterator(
+		db.reader,
+		startOffset,
+		encoding.Key(limit),
+		db.endOfDataOffset,
+	)
+	iterator.includeTombstones, iterator.compare = includeTombstones, db.compare
+	return iterator, nil
+}
+
+// cursorTowards returns a cursor at the key in the sparse index that's closest to searchKey (less than or equal to)
+func (db *SSTableDB) cursorTowards(searchKey leveldb.Key) (*cursor, error) {
+	startIndex, err := db.dir.offsetFor(searchKey, db.compare)
+	if err != nil {
+		return nil, err
+	}
+	return newCursor(db.reader, int64(startIndex), db.endOfDataOffset), nil
+}
+
+// cursor reads the entries of a formatVersion1 table in order, from where one starts.  Each cursor buffers its reads
+// on its own, so that any number of them can read the table at once.
+type cursor struct {
+	reader *bufio.Reader
+	// offset is where the next entry starts, and endOfDataOffset where the directory does
+	offset          int64
+	endOfDataOffset int64
+}
+
+func newCursor(reader io.ReaderAt, offset int64, endOfDataOffset int64) *cursor {
+	return &cursor{
+		reader:          bufio.NewReader(io.NewSectionReader(reader, offset, endOfDataOffset-offset)),
+		offset:          offset,
+		endOfDataOffset: endOfDataOffset,
+	}
+}
+
+// atEndOfData is a substitute for checking for EOF errors because our file has directory data at the end of it.  The
+// data offset is inferred from the encoding of where the directory starts (that encoding lives at the beginning of the
+// file)
+func (c *cursor) atEndOfData() bool {
+	return c.offset >= c.endOfDataOffset
+}
+
+// next reads the entry at the cursor's offset, and moves on past it.
+func (c *cursor) next() (*encoding.Entry, error) {
+	var entry = new(encoding.Entry)
+	bytesRead, err := readEntry(c.reader, entry)
+	c.offset += bytesRead
+	return entry, err
+}
+
+// NewIterator returns an iterator over the entries of the table in reader from startOffset, which must be where an
+// entry starts, up to limit.  It expects the table's keys in bytewise order.
+func NewIterator(
+	reader io.ReaderAt,
+	startOffset int64,
+	limit encoding.Key,
+	endOfDataOffset int64,
+) *Iterator {
+	return &Iterator{
+		reader:          reader,
+		limit:           limit,
+		endOfDataOffset: endOfDataOffset,
+		startOffset:     startOffset,
+		currentEntry:    new(encoding.Entry), // call Next() first
+		compare:         leveldb.Key.Compare,
+	}
+}
+
+// Iterator is used for satisfying a RangeScan.  It is similar to the read functions, and reads through a cursor of its
+// own.  Entries can only be read forward, from where one is known to start, so moving back or seeking reads on from
+// the first entry in range.
+type Iterator struct {
+	reader          io.ReaderAt
+	limit           encoding.Key
+	endOfDataOffset int64
+	// startOffset is where the first entry in range starts, and entryOffset and nextOffset where the current entry
+	// and the one after it do
+	startOffset, entryOffset, nextOffset int64
+	currentEntry                         *encoding.Entry // should this start at the preceding entry?
+	// cursor is kept from move to move, so that moving on reads from its buffer
+	cursor *cursor
+	// afterLast is set once the iterator has moved past its last entry, rather than before its first
+	afterLast bool
+	err       error
+	// includeTombstones has tombstoned keys yielded with empty values instead of skipped
+	includeTombstones bool
+	// compare orders the table's keys
+	compare func(a, b leveldb.Key) int
+}
+
+func (i *Iterator) Next() bool {
+	switch {
+	case i.err != nil || i.afterLast:
+		return false
+	case i.currentEntry.IsZeroEntry():
+		return i.SeekToFirst()
+	}
+	return i.scanFrom(i.nextOffset, nil)
+}
+
+func (i *Iterator) Prev() bool {
+	switch {
+	case i.err != nil:
+		return false
+	case i.afterLast:
+		return i.SeekToLast()
+	case i.currentEntry.IsZeroEntry():
+		return false
+	}
+	return i.scanBefore(i.entryOffset)
+}
+
+func (i *Iterator) Seek(key leveldb.Key) bool {
+	if i.err != nil {
+		return false
+	}
+	return i.scanFrom(i.startOffset, encoding.Key(key))
+}
+
+func (i *Iterator) SeekToFirst() bool {
+	return i.Seek(nil)
+}
+
+func (i *Iterator) SeekToLast() bool {
+	if i.err != nil {
+		return false
+	}
+	return i.scanBefore(i.endOfDataOffset)
+}
+
+// scanFrom moves to the first entry to yield from offset on with a key at or after key.
+func (i *Iterator) scanFrom(offset int64, key encoding.Key) bool {
+	var cursor = i.cursorAt(offset)
+	for !cursor.atEndOfData() {
+		var entryOffset = cursor.offset
+		entry, err := cursor.next()
+		if i.err = err; err != nil || i.compare(leveldb.Key(entry.Key), leveldb.Key(i.limit)) > 0 {
+			break
+		}
+		var atOrAfterKey = key == nil || i.compare(leveldb.Key(entry.Key), leveldb.Key(key)) >= 0
+		if atOrAfterKey && (len(entry.Value) != 0 || i.includeTombstones) {
+			i.currentEntry, i.entryOffset, i.nextOffset = entry, entryOffset, cursor.offset
+			return true
+		}
+	}
+	i.currentEntry, i.afterLast = new(encoding.Entry), true // exhausted, Key() and Value() should return nil
+	return false
+}
+
+// scanBefore moves to the last entry to yield that starts before offset.
+func (i *Iterator) scanBefore(offset int64) bool {
+	var (
+		cursor                       = i.cursorAt(i.startOffset)
+		found                        = new(encoding.Entry)
+		foundOffset, foundNextOffset int64
+	)
+	for !cursor.atEndOfData() && cursor.offset < offset {
+		var entryOffset = cursor.offset
+		entry, err := cursor.next()
+		if i.err = err; err != nil {
+			found = new(encoding.Entry)
+			break
+		}
+		if i.compare(leveldb.Key(entry.Key), leveldb.Key(i.limit)) > 0 {
+			break
+		}
+		if len(entry.Value) != 0 || i.includeTombstones {
+			found, foundOffset, foundNextOffset = entry, entryOffset, cursor.offset
+		}
+	}
+	i.currentEntry, i.entryOffset, i.nextOffset, i.afterLast = found, foundOffset, foundNextOffset, false
+	return !found.IsZeroEntry()
+}
+
+// cursorAt returns the iterator's cursor if it is at offset already, or else a new one there.
+func (i *Iterator) cursorAt(offset int64) *cursor {
+	if i.cursor == nil || i.cursor.offset != offset {
+		i.cursor = newCursor(i.reader, offset, i.endOfDataOffset)
+	}
+	return i.cursor
+}
+
+func (i *Iterator) Error() error {
+	return i.err
+}
+
+func (i *Iterator) Key() leveldb.Key {
+	if i.currentEntry.IsZeroEntry() {
+		return nil
+	}
+	return leveldb.Key(i.currentEntry.Key)
+}
+
+func (i *Iterator) Value() leveldb.Value {
+	if i.currentEntry.IsZeroEntry() {
+		return nil
+	}
+	return leveldb.Value(i.currentEntry.Value)
+}
+
+// Close does nothing, since the reader belongs to the caller.
+func (i *Iterator) Close() error {
+	return nil
+}
+
+// readEntry reads an entry into the supplied pointer and returns how many bytes were read, so that the caller can keep
+// track of where the next entry starts
+func readEntry(rs io.Reader, entry *encoding.Entry) (int64, error) {
+	var bytesRead int64
+	keyLen, err := encoding.ReadUint64(rs)
+	bytesRead += 8
+	if err != nil {
+		return bytesRead, err
+	}
+	var key = make(encoding.Key, keyLen)
+	keyBytes, err := io.ReadFull(rs, key)
+	bytesRead += int64(keyBytes)
+	if err != nil {
+		return bytesRead, err
+	}
+	valLen, err := encoding.ReadUint64(rs)
+	bytesRead += 8
+	if err != nil {
+		return bytesRead, err
+	}
+	if valLen == 0 {
+		*entry = encoding.Entry{Key: key, Value: nil}
+		return bytesRead, nil
+	}
+	value, err := encoding.ReadByteSlice(rs, valLen)
+	bytesRead += int64(valLen)
+	if err != nil {
+		return bytesRead, err
+	}
+	*entry = encoding.Entry{
+		Key:   key,
+		Value: value,
+	}
+	return bytesRead, nil
+}
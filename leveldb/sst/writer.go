@@ -0,0 +1,139 @@
+package sst
+
+import (
+	"fmt"
+	"io"
+	"leveldb"
+	"os"
+)
+
+// Writer streams entries into an SSTable (see BuildSSTable for the format), so that tables can be produced from
+// sources other than a memTable without holding all of their entries in memory.  Entries must be added in ascending
+// key order, and an entry with an empty value is written as a tombstone.
+type Writer struct {
+	f      *os.File
+	config *ssTableConfig
+	// offset is where the next block will be written
+	offset int64
+	// block holds the entries of the data block being built, and index an entry for each data block written
+	block   blockWriter
+	index   blockWriter
+	lastKey leveldb.Key
+	// keyHashes holds the bloom filter hash of every key added, tombstones included, or of its filter key
+	keyHashes []uint32
+	// rangeTombstones holds the range tombstones added, written to a block of their own by Finish
+	rangeTombstones    blockWriter
+	lastRangeTombstone leveldb.Key
+}
+
+func NewWriter(f *os.File, configOptions ...ssTableOption) (*Writer, error) {
+	var ssTableConfig = newSSTableConfig()
+	for _, option := range configOptions {
+		option(ssTableConfig)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		f:      f,
+		config: ssTableConfig,
+		block:  blockWriter{restartInterval: ssTableConfig.restartInterval},
+		// index entries are few and far between, so they are kept whole for binary searching
+		index:           blockWriter{restartInterval: 1},
+		rangeTombstones: blockWriter{restartInterval: ssTableConfig.restartInterval},
+	}, nil
+}
+
+func (w *Writer) Add(key leveldb.Key, value leveldb.Value) error {
+	if w.lastKey != nil && w.config.compare(key, w.lastKey) <= 0 {
+		return fmt.Errorf("sst.Writer.Add: key %q added after %q", key, w.lastKey)
+	}
+	w.block.add(key, value)
+	w.lastKey = key
+	if w.config.bloomBitsPerKey > 0 {
+		var filterKey = key
+		if w.config.filterKey != nil {
+			filterKey = w.config.filterKey(key)
+		}
+		w.keyHashes = append(w.keyHashes, bloomHash(filterKey))
+	}
+	if w.block.size() >= w.config.blockSize {
+		return w.flushBlock()
+	}
+	return nil
+}
+
+// AddRangeTombstone adds an entry to the table's range tombstones, which are kept apart from its other entries and
+// read in full when the table is opened; see SSTableDB.RangeTombstones.  What a range tombstone covers is up to the
+// caller, which typically keys it by the start of the range and holds its end in value.  Range tombstones must be
+// added in ascending key order, but may be added at any point before Finish.
+func (w *Writer) AddRangeTombstone(key leveldb.Key, value leveldb.Value) error {
+	if w.lastRangeTombstone != nil && w.config.compare(key, w.lastRangeTombstone) <= 0 {
+		return fmt.Errorf("sst.Writer.AddRangeTombstone: key %q added after %q", key, w.lastRangeTombstone)
+	}
+	w.rangeTombstones.add(key, value)
+	w.lastRangeTombstone = key
+	return nil
+}
+
+// Size returns the number of bytes written so far, counting the data block being built.
+func (w *Writer) Size() int64 {
+	if w.block.empty() {
+		return w.offset
+	}
+	return w.offset + int64(w.block.size())
+}
+
+// Finish writes the last data block, the filter block, any range tombstones, the index block and the footer, then
+// returns the completed table opened for reading.
+func (w *Writer) Finish() (*SSTableDB, error) {
+	if !w.block.empty() {
+		if err := w.flushBlock(); err != nil {
+			return nil, err
+		}
+	}
+	var (
+		footer = footer{version: formatVersion3}
+		err    error
+	)
+	if w.config.bloomBitsPerKey > 0 {
+		var filter = newBloomFilter(w.keyHashes, w.config.bloomBitsPerKey)
+		if footer.filter, err = w.writeBlock(filter, NoCompression); err != nil {
+			return nil, err
+		}
+	}
+	if !w.rangeTombstones.empty() {
+		footer.version = formatVersion4
+		if footer.rangeTombstones, err = w.writeBlock(w.rangeTombstones.finish(), w.config.compression); err != nil {
+			return nil, err
+		}
+	}
+	if footer.index, err = w.writeBlock(w.index.finish(), NoCompression); err != nil {
+		return nil, err
+	}
+	if _, err := w.f.Write(footer.encode()); err != nil {
+		return nil, err
+	}
+	return openTable(w.f, w.config)
+}
+
+// flushBlock writes the data block being built, and indexes it by its largest key.
+func (w *Writer) flushBlock() error {
+	handle, err := w.writeBlock(w.block.finish(), w.config.compression)
+	if err != nil {
+		return err
+	}
+	w.index.add(w.lastKey, handle.encode(nil))
+	return nil
+}
+
+// writeBlock writes out the block, compressed with the given codec if that shrinks it, and returns its handle.
+func (w *Writer) writeBlock(contents []byte, compression Compression) (blockHandle, error) {
+	var encoded = encodeBlock(contents, compression)
+	var handle = blockHandle{offset: uint64(w.offset), size: uint64(len(encoded) - blockTrailerSize)}
+	if _, err := w.f.Write(encoded); err != nil {
+		return blockHandle{}, err
+	}
+	w.offset += int64(len(encoded))
+	return handle, nil
+}
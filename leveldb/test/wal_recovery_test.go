@@ -2,6 +2,9 @@ package test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"leveldb"
 	"leveldb/db"
 	"leveldb/wal"
@@ -43,3 +46,143 @@ func TestRecovery(t *testing.T) {
 		t.Errorf("expected value to be %q, got %q", "Eno", val)
 	}
 }
+
+func TestRecovery_TornWrite(t *testing.T) {
+	writeFile, err := os.CreateTemp(t.TempDir(), "wal")
+	if err != nil {
+		t.Fatal("failed to create temp WAL file:", err)
+	}
+	log := wal.NewLog(writeFile)
+	for _, entry := range []leveldb.DataEntry{
+		{Key: leveldb.Key("genre"), Value: leveldb.Value("ambient")},
+		{Key: leveldb.Key("artist"), Value: leveldb.Value("Eno")},
+	} {
+		if err := log.Put(entry.Key, entry.Value); err != nil {
+			t.Fatal("failed to put entry in log:", err)
+		}
+	}
+	// a crash part way through appending the next record leaves a partial one behind
+	if _, err := writeFile.Write([]byte{0x12, 0x34, 0x56, 0x78, 0x20}); err != nil {
+		t.Fatal("failed to write torn record:", err)
+	}
+	if err := writeFile.Close(); err != nil {
+		t.Error("failed to close file descriptor used for writing WAL:", err)
+	}
+
+	for _, strict := range []bool{false, true} {
+		walFile, err := os.OpenFile(writeFile.Name(), os.O_RDWR, os.ModeType)
+		if err != nil {
+			t.Fatal("error opening WAL file:", err)
+		}
+		database, err := db.NewDbFromWalWithOptions(walFile, &db.Options{StrictRecovery: strict})
+		if err != nil {
+			t.Fatal("error initializing DB from WAL with a torn final record:", err)
+		}
+		// the torn record is dropped the first time around, so there's nothing left to drop the second
+		var expectedDiscarded uint64
+		if !strict {
+			expectedDiscarded = 5
+		}
+		if discarded := database.(db.StatsReporter).Stats().WALBytesDiscarded; discarded != expectedDiscarded {
+			t.Errorf("expected %d bytes to be discarded, got %d", expectedDiscarded, discarded)
+		}
+		val, err := database.Get(leveldb.Key("artist"))
+		if err != nil || !bytes.Equal(val, leveldb.Value("Eno")) {
+			t.Errorf("expected value to be %q, got %q (err %v)", "Eno", val, err)
+		}
+		// new records follow on from the intact ones
+		if err := database.Put(leveldb.Key(fmt.Sprintf("strict %t", strict)), leveldb.Value("yes")); err != nil {
+			t.Fatal("error putting value:", err)
+		}
+		if err := walFile.Close(); err != nil {
+			t.Error("failed to close WAL file:", err)
+		}
+	}
+
+	walFile, err := os.Open(writeFile.Name())
+	if err != nil {
+		t.Fatal("error opening WAL file:", err)
+	}
+	defer func() { _ = walFile.Close() }()
+	recovered, err := wal.ReadLog(walFile, true)
+	if err != nil {
+		t.Fatal("error reading WAL file:", err)
+	}
+	if len(recovered.Operations) != 4 || recovered.Discarded != 0 {
+		t.Errorf("expected 4 operations and nothing discarded, got %d and %d", len(recovered.Operations), recovered.Discarded)
+	}
+}
+
+// TestRecovery_DamagedRecord damages a record in the middle of a WAL, and checks that lenient recovery skips it while
+// keeping the intact records after it, in the file as well as in the database.
+func TestRecovery_DamagedRecord(t *testing.T) {
+	writeFile, err := os.CreateTemp(t.TempDir(), "wal")
+	if err != nil {
+		t.Fatal("failed to create temp WAL file:", err)
+	}
+	log := wal.NewLog(writeFile)
+	for j := range 4 {
+		if err := log.Put(leveldb.Key(fmt.Sprintf("key%d", j)), leveldb.Value("value")); err != nil {
+			t.Fatal("failed to put entry in log:", err)
+		}
+	}
+	if err := writeFile.Close(); err != nil {
+		t.Error("failed to close file descriptor used for writing WAL:", err)
+	}
+	contents, err := os.ReadFile(writeFile.Name())
+	if err != nil {
+		t.Fatal("error reading WAL file:", err)
+	}
+	var recordSize = len(contents) / 4 // every key and value is the same length
+	contents[recordSize+10] ^= 1
+	if err := os.WriteFile(writeFile.Name(), contents, 0o644); err != nil {
+		t.Fatal("error damaging WAL file:", err)
+	}
+
+	walFile, err := os.OpenFile(writeFile.Name(), os.O_RDWR, os.ModeType)
+	if err != nil {
+		t.Fatal("error opening WAL file:", err)
+	}
+	var strict = &db.Options{StrictRecovery: true}
+	if _, err := db.NewDbFromWalWithOptions(walFile, strict); !errors.Is(err, wal.ErrCorrupted) {
+		t.Errorf("expected ErrCorrupted in strict mode, got %v", err)
+	}
+	if _, err := walFile.Seek(0, io.SeekStart); err != nil {
+		t.Fatal("error seeking to the start of the WAL file:", err)
+	}
+	database, err := db.NewDbFromWalWithOptions(walFile, nil)
+	if err != nil {
+		t.Fatal("error initializing DB from WAL with a damaged record:", err)
+	}
+	for j := range 4 {
+		var key = leveldb.Key(fmt.Sprintf("key%d", j))
+		if _, err := database.Get(key); j != 1 && err != nil {
+			t.Errorf("unexpected error getting %q: %v", key, err)
+		} else if j == 1 && !errors.Is(err, leveldb.ErrKeyNotFound) {
+			t.Errorf("expected %q to be lost with the damaged record, got err %v", key, err)
+		}
+	}
+	if err := database.Put(leveldb.Key("appended"), leveldb.Value("value")); err != nil {
+		t.Fatal("error putting value:", err)
+	}
+	if err := walFile.Close(); err != nil {
+		t.Error("failed to close WAL file:", err)
+	}
+
+	walFile, err = os.Open(writeFile.Name())
+	if err != nil {
+		t.Fatal("error opening WAL file:", err)
+	}
+	defer func() { _ = walFile.Close() }()
+	recovered, err := wal.ReadLog(walFile, false)
+	if err != nil {
+		t.Fatal("error reading WAL file:", err)
+	}
+	var keys []string
+	for _, op := range recovered.Operations {
+		keys = append(keys, string(op.Key))
+	}
+	if fmt.Sprint(keys) != "[key0 key2 key3 appended]" || recovered.Skipped != int64(recordSize) {
+		t.Errorf("expected the intact records kept around the skipped one, got %v (%d skipped)", keys, recovered.Skipped)
+	}
+}
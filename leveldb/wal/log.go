@@ -3,32 +3,112 @@ package wal
 import (
 	"bufio"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"leveldb"
 	"leveldb/encoding"
+	"sync"
+	"time"
+)
+
+/**
+ * The log is a sequence of 32KiB blocks, following LevelDB's.  Each operation is written as a record, split into
+ * fragments so that none crosses a block boundary:
+ * | 4 bytes                                   | 2 bytes  | 1 byte        | arbitrarily long |
+ * | [crc32c of record type, length and data]  | [length] | [record type] | [data]           |
+ *
+ * , where the record type says whether the fragment holds the whole record or its first, a middle or its last part.
+ * A block with fewer than 7 bytes left is padded with zeros.  A crash part way through an append leaves at most the
+ * final record torn, which recovery can tell apart from damage to earlier records; see ReadLog.
+ */
+const (
+	blockSize  = 32 << 10
+	headerSize = 4 + 2 + 1
+)
+
+type recordType byte
+
+const (
+	// zeroType is reserved for preallocated, never written, space
+	zeroType recordType = iota
+	fullType
+	firstType
+	middleType
+	lastType
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// SyncPolicy decides when the records appended to a Log are synced to stable storage.  Writes asking for it with
+// leveldb.WithSync are synced before they return whatever the policy.
+type SyncPolicy int
+
+const (
+	// SyncNever leaves syncing to the operating system.  Records survive the process crashing, but not the machine.
+	SyncNever SyncPolicy = iota
+	// SyncEveryWrite syncs each write before it returns.  Concurrent writes are group committed: writes appended while a
+	// sync is in progress wait for it to finish, and are then covered together by a single sync.
+	SyncEveryWrite
+	// SyncPeriodically syncs in the background at a fixed interval, bounding how much a machine crash can lose.
+	SyncPeriodically
 )
 
 type Log struct {
-	//operations []DbOperation
+	mu sync.Mutex
+	// synced is signalled whenever a sync finishes
+	synced *sync.Cond
 	writer *bufio.Writer
+	// syncer is the underlying writer, if it can be synced; logs over writers that cannot treat syncs as done
+	syncer interface{ Sync() error }
+	// blockOffset is where the next fragment will be written, relative to the start of its block
+	blockOffset int
+	policy      SyncPolicy
+	interval    time.Duration
+	// writtenCount and syncedCount count the records appended to the log and those known to be on stable storage, and
+	// requestedCount the records up to the last one whose write asked to be synced
+	writtenCount, syncedCount, requestedCount uint64
+	syncing                                   bool
+	// err is the first error writing or syncing; once the log may have lost a record, every later write fails
+	err error
+	// stop and stopped end the background syncing of SyncPeriodically
+	stop, stopped chan struct{}
 }
 
-func NewLog(writer io.Writer) *Log {
-	var bufferedWriter = bufio.NewWriter(writer)
-	//go func() {
-	//	time.Sleep(time.Second)
-	//	var err = bufferedWriter.Flush()
-	//	if err != nil {
-	//		fmt.Println("WARN:", err)
-	//	}
-	//}()
-	//recoveryReader := bufio.NewReader(rw)
-	return &Log{
-		writer: bufferedWriter,
+type LogOption func(*Log)
+
+// WithSyncPolicy sets when the log is synced to stable storage.  The interval is only used by SyncPeriodically.
+func WithSyncPolicy(policy SyncPolicy, interval time.Duration) LogOption {
+	return func(log *Log) {
+		log.policy = policy
+		log.interval = interval
 	}
 }
 
-func (log *Log) Put(key leveldb.Key, value leveldb.Value) error {
+func NewLog(writer io.Writer, opts ...LogOption) *Log {
+	return NewLogAt(writer, 0, opts...)
+}
+
+// NewLogAt returns a Log appending to one that is already size bytes long, as reported by ReadLog.
+func NewLogAt(writer io.Writer, size int64, opts ...LogOption) *Log {
+	var log = &Log{
+		writer:      bufio.NewWriter(writer),
+		blockOffset: int(size % blockSize),
+	}
+	log.synced = sync.NewCond(&log.mu)
+	if syncer, ok := writer.(interface{ Sync() error }); ok {
+		log.syncer = syncer
+	}
+	for _, opt := range opts {
+		opt(log)
+	}
+	if log.policy == SyncPeriodically && log.interval > 0 {
+		log.stop, log.stopped = make(chan struct{}), make(chan struct{})
+		go log.syncPeriodically()
+	}
+	return log
+}
+
+func (log *Log) Put(key leveldb.Key, value leveldb.Value, opts ...leveldb.WriteOption) error {
 	if log == nil {
 		return nil
 	}
@@ -38,10 +118,10 @@ func (log *Log) Put(key leveldb.Key, value leveldb.Value) error {
 			Key:   encoding.Key(key),
 			Value: encoding.Value(value),
 		},
-	})
+	}, opts)
 }
 
-func (log *Log) Delete(key leveldb.Key) error {
+func (log *Log) Delete(key leveldb.Key, opts ...leveldb.WriteOption) error {
 	if log == nil {
 		return nil
 	}
@@ -50,24 +130,254 @@ func (log *Log) Delete(key leveldb.Key) error {
 		Entry: encoding.Entry{
 			Key: encoding.Key(key),
 		},
-	})
+	}, opts)
 }
 
-func (log *Log) write(dbOp encoding.DbOperation) error {
-	encoded, err := dbOp.Encode()
+// Write logs the whole batch as a single record, so that it is recovered all or nothing.
+func (log *Log) Write(batch *leveldb.WriteBatch, opts ...leveldb.WriteOption) error {
+	if log == nil {
+		return nil
+	}
+	var dbOp = encoding.DbOperation{Operation: encoding.OpBatch, Batch: batchOperations(batch)}
+	return log.write(dbOp, opts)
+}
+
+// batchOperations returns the operations logging each write in the batch.
+func batchOperations(batch *leveldb.WriteBatch) []*encoding.DbOperation {
+	var ops = make([]*encoding.DbOperation, 0, batch.Len())
+	for _, entry := range batch.Entries() {
+		var op = &encoding.DbOperation{
+			Operation:    encoding.OpPut,
+			Entry:        encoding.Entry{Key: encoding.Key(entry.Key), Value: encoding.Value(entry.Value)},
+			ColumnFamily: entry.ColumnFamily,
+		}
+		switch {
+		case entry.Deleted && entry.Limit != nil:
+			op.Operation, op.Value = encoding.OpDeleteRange, encoding.Value(entry.Limit)
+		case entry.Deleted:
+			op.Operation, op.Value = encoding.OpDelete, nil
+		case entry.Merge:
+			op.Operation = encoding.OpMerge
+		case !entry.ExpiresAt.IsZero():
+			op.Operation, op.ExpiresAt = encoding.OpPutWithExpiry, entry.ExpiresAt.UnixNano()
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+// Sync returns once every record appended so far is on stable storage.
+func (log *Log) Sync() error {
+	if log == nil {
+		return nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	return log.syncUpTo(log.writtenCount)
+}
+
+// Close stops background syncing and syncs any records not yet synced.  Under SyncNever, only records up to the last
+// one whose write asked to be synced are, so that writes still waiting on a Pending are covered before the caller
+// closes the underlying writer, which belongs to it.
+func (log *Log) Close() error {
+	if log == nil {
+		return nil
+	}
+	if log.stop != nil {
+		close(log.stop)
+		<-log.stopped
+		log.stop = nil
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.policy == SyncNever {
+		return log.syncUpTo(log.requestedCount)
+	}
+	return log.syncUpTo(log.writtenCount)
+}
+
+func (log *Log) write(dbOp encoding.DbOperation, opts []leveldb.WriteOption) error {
+	pending, err := log.append(dbOp, opts)
 	if err != nil {
 		return err
 	}
-	bytesWritten, err := log.writer.Write(encoded)
+	return pending.Wait()
+}
+
+// Append logs the batch as a single record like Write, but returns without waiting for it to be synced.  This lets a
+// caller append under a lock of its own, so that records are logged in the order it applies them, then wait outside
+// that lock, so that concurrent writes still share syncs.  A batch holding one write is logged as that write alone.
+func (log *Log) Append(batch *leveldb.WriteBatch, opts ...leveldb.WriteOption) (Pending, error) {
+	if log == nil {
+		return Pending{}, nil
+	}
+	var ops = batchOperations(batch)
+	if len(ops) == 1 {
+		return log.append(*ops[0], opts)
+	}
+	return log.append(encoding.DbOperation{Operation: encoding.OpBatch, Batch: ops}, opts)
+}
+
+// Pending is a record appended to a Log that may still have to be synced before its write is acknowledged.
+type Pending struct {
+	log *Log
+	// count is the number of records appended up to and including this one
+	count uint64
+	sync  bool
+}
+
+// Wait returns once the record is on stable storage, if its write has to be synced, and otherwise straight away, with
+// the error that failed the log, if any.
+func (p Pending) Wait() error {
+	if p.log == nil {
+		return nil
+	}
+	if !p.sync {
+		return p.Err()
+	}
+	p.log.mu.Lock()
+	defer p.log.mu.Unlock()
+	return p.log.syncUpTo(p.count)
+}
+
+// Err returns the error that failed the log, if any, even if the record was written or synced before.  Once the log
+// has failed, records may have been lost ahead of this one, so its write is failed along with theirs.
+func (p Pending) Err() error {
+	if p.log == nil {
+		return nil
+	}
+	p.log.mu.Lock()
+	defer p.log.mu.Unlock()
+	return p.log.err
+}
+
+func (log *Log) append(dbOp encoding.DbOperation, opts []leveldb.WriteOption) (Pending, error) {
+	encoded, err := dbOp.Encode()
 	if err != nil {
-		return err
+		return Pending{}, err
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	if log.err != nil {
+		return Pending{}, log.err
+	}
+	if err := log.writeRecord(encoded); err != nil {
+		log.err = fmt.Errorf("wal.Log.write: error appending record: %v", err)
+		return Pending{}, log.err
+	}
+	if err := log.writer.Flush(); err != nil {
+		log.err = fmt.Errorf("wal.Log.write: error flushing record: %v", err)
+		return Pending{}, log.err
+	}
+	log.writtenCount++
+	var pending = Pending{log: log, count: log.writtenCount}
+	if log.policy == SyncEveryWrite || leveldb.NewWriteOptions(opts...).Sync {
+		pending.sync, log.requestedCount = true, log.writtenCount
+	}
+	return pending, nil
+}
+
+// syncUpTo returns once the first count records are on stable storage.  If no sync is in progress, the caller leads
+// one covering every record appended so far; otherwise it waits for the sync in progress, then leads the next if that
+// one did not cover its record.  The lock is held on entry and released while syncing, so that other writers can
+// append in the meantime and join the next group.
+func (log *Log) syncUpTo(count uint64) error {
+	for log.syncedCount < count {
+		if log.err != nil {
+			return log.err
+		}
+		if log.syncing {
+			log.synced.Wait()
+			continue
+		}
+		log.syncing = true
+		var target = log.writtenCount
+		log.mu.Unlock()
+		var err error
+		if log.syncer != nil {
+			err = log.syncer.Sync()
+		}
+		log.mu.Lock()
+		log.syncing = false
+		if err != nil {
+			log.err = fmt.Errorf("wal.Log.syncUpTo: error syncing: %v", err)
+		} else {
+			log.syncedCount = target
+		}
+		log.synced.Broadcast()
 	}
-	if bytesWritten != len(encoded) {
-		return fmt.Errorf(
-			"expected to write %d bytes, only wrote %d",
-			len(encoded),
-			bytesWritten,
+	return nil
+}
+
+func (log *Log) syncPeriodically() {
+	defer close(log.stopped)
+	var ticker = time.NewTicker(log.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-log.stop:
+			return
+		case <-ticker.C:
+			log.mu.Lock()
+			// a failure is kept in log.err and reported to the next writer
+			_ = log.syncUpTo(log.writtenCount)
+			log.mu.Unlock()
+		}
+	}
+}
+
+// writeRecord writes data as a record, fragmented across as many blocks as it takes.
+func (log *Log) writeRecord(data []byte) error {
+	for begin := true; begin || len(data) > 0; begin = false {
+		if leftover := blockSize - log.blockOffset; leftover < headerSize {
+			if _, err := log.writer.Write(make([]byte, leftover)); err != nil {
+				return err
+			}
+			log.blockOffset = 0
+		}
+		var (
+			fragmentLen = min(len(data), blockSize-log.blockOffset-headerSize)
+			end         = fragmentLen == len(data)
+			typ         recordType
 		)
+		switch {
+		case begin && end:
+			typ = fullType
+		case begin:
+			typ = firstType
+		case end:
+			typ = lastType
+		default:
+			typ = middleType
+		}
+		if err := log.writeFragment(typ, data[:fragmentLen]); err != nil {
+			return err
+		}
+		data = data[fragmentLen:]
+	}
+	return nil
+}
+
+func (log *Log) writeFragment(typ recordType, data []byte) error {
+	var header = make([]byte, headerSize)
+	encoding.ByteOrder.PutUint16(header[4:6], uint16(len(data)))
+	header[6] = byte(typ)
+	var checksum = crc32.Update(crc32.Checksum(header[4:], crc32cTable), crc32cTable, data)
+	encoding.ByteOrder.PutUint32(header[:4], checksum)
+
+	for _, buf := range [][]byte{header, data} {
+		bytesWritten, err := log.writer.Write(buf)
+		if err != nil {
+			return err
+		}
+		if bytesWritten != len(buf) {
+			return fmt.Errorf(
+				"expected to write %d bytes, only wrote %d",
+				len(buf),
+				bytesWritten,
+			)
+		}
 	}
-	return log.writer.Flush()
+	log.blockOffset += headerSize + len(data)
+	return nil
 }
@@ -1,12 +1,18 @@
 package wal
 
 import (
-	"bufio"
 	"bytes"
+	"errors"
+	"fmt"
 	"leveldb"
 	"leveldb/encoding"
 	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestLog_Put(t *testing.T) {
@@ -51,11 +57,17 @@ func TestLog_Put(t *testing.T) {
 	if err != nil {
 		t.Fatal("error opening WAL file for reading:", err)
 	}
-	decodedOps, err := encoding.DecodeLogFile(bufio.NewReader(readFile))
+	recovered, err := ReadLog(readFile, true)
 	if err != nil {
 		t.Fatal("error decoding WAL file:", err)
 	}
-	for j, decodedOp := range decodedOps {
+	if len(recovered.Operations) != len(operations) || recovered.Discarded != 0 {
+		t.Errorf(
+			"expected %d operations and nothing discarded, got %d and %d bytes discarded",
+			len(operations), len(recovered.Operations), recovered.Discarded,
+		)
+	}
+	for j, decodedOp := range recovered.Operations {
 		originalOp := operations[j]
 		if decodedOp.Operation != originalOp.Operation {
 			t.Errorf("expected operation %s, got %s", originalOp.Operation, decodedOp.Operation)
@@ -68,3 +80,325 @@ func TestLog_Put(t *testing.T) {
 		}
 	}
 }
+
+func TestReadLog(t *testing.T) {
+	// the last value is large enough to be split across blocks
+	var values = []string{"one", "two", "three", "four", strings.Repeat("five", 20000)}
+	var buf bytes.Buffer
+	var log = NewLog(&buf)
+	var ends []int // where each record ends
+	for j, value := range values {
+		if err := log.Put(leveldb.Key(fmt.Sprintf("key%d", j)), leveldb.Value(value)); err != nil {
+			t.Fatal("error writing PUT to log:", err)
+		}
+		ends = append(ends, buf.Len())
+	}
+	var intact = buf.Bytes()
+
+	tests := []struct {
+		name string
+		// damage returns a damaged copy of the log
+		damage func(log []byte) []byte
+		// recovered is the number of operations expected back, and discarded the bytes expected to be dropped
+		recovered int
+		discarded int
+		// skipped is the number of bytes expected to be skipped over, along with the value at index lost
+		skipped int
+		lost    int
+		// strictFails says whether strict mode should fail rather than drop the damaged records
+		strictFails bool
+	}{
+		{
+			name:      "Intact",
+			damage:    func(log []byte) []byte { return log },
+			recovered: 5,
+		},
+		{
+			name:      "TornHeader",
+			damage:    func(log []byte) []byte { return log[:ends[2]+3] },
+			recovered: 3,
+			discarded: 3,
+		},
+		{
+			name:      "TornData",
+			damage:    func(log []byte) []byte { return log[:ends[3]-1] },
+			recovered: 3,
+			discarded: ends[3] - 1 - ends[2],
+		},
+		{
+			name:      "TornFragmentedRecord",
+			damage:    func(log []byte) []byte { return log[:blockSize+100] },
+			recovered: 4,
+			discarded: blockSize + 100 - ends[3],
+		},
+		{
+			name: "DamagedLastRecord",
+			damage: func(log []byte) []byte {
+				log[ends[4]-1] ^= 1
+				return log
+			},
+			recovered: 4,
+			discarded: ends[4] - ends[3],
+		},
+		{
+			name:      "PreallocatedZeros",
+			damage:    func(log []byte) []byte { return append(log[:ends[1]], make([]byte, 100)...) },
+			recovered: 2,
+			discarded: 100,
+		},
+		{
+			name: "DamagedMiddleRecord",
+			damage: func(log []byte) []byte {
+				log[ends[1]+headerSize] ^= 1
+				return log
+			},
+			recovered:   4,
+			skipped:     ends[2] - ends[1],
+			lost:        2,
+			strictFails: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, strict := range []bool{false, true} {
+				var damaged = tc.damage(bytes.Clone(intact))
+				recovered, err := ReadLog(bytes.NewReader(damaged), strict)
+				if strict && tc.strictFails {
+					if !errors.Is(err, ErrCorrupted) {
+						t.Errorf("expected ErrCorrupted in strict mode, got %v", err)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("unexpected error reading log (strict %t): %v", strict, err)
+				}
+				if len(recovered.Operations) != tc.recovered ||
+					recovered.Discarded != int64(tc.discarded) ||
+					recovered.Skipped != int64(tc.skipped) {
+					t.Errorf(
+						"expected %d operations, %d bytes discarded and %d skipped (strict %t), got %d, %d and %d",
+						tc.recovered, tc.discarded, tc.skipped, strict,
+						len(recovered.Operations), recovered.Discarded, recovered.Skipped,
+					)
+				}
+				if recovered.Size+recovered.Discarded != int64(len(damaged)) {
+					t.Errorf(
+						"expected size %d and %d discarded to make up the log of %d bytes",
+						recovered.Size, recovered.Discarded, len(damaged),
+					)
+				}
+				var expected = values
+				if tc.skipped > 0 {
+					expected = slices.Delete(slices.Clone(values), tc.lost, tc.lost+1)
+				}
+				for j, op := range recovered.Operations {
+					if string(op.Value) != expected[j] {
+						t.Errorf("expected operation %d to have value %.10q, got %.10q", j, expected[j], op.Value)
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("DamagedLength", func(t *testing.T) {
+		// enough records to fill two blocks, the second of which claims to run past the end of the log
+		var buf bytes.Buffer
+		var log = NewLog(&buf)
+		var second int
+		for j := range 400 {
+			if j == 1 {
+				second = buf.Len()
+			}
+			if err := log.Put(leveldb.Key(fmt.Sprintf("key%03d", j)), leveldb.Value(strings.Repeat("v", 100))); err != nil {
+				t.Fatal("error writing PUT to log:", err)
+			}
+		}
+		var damaged = buf.Bytes()
+		if second+headerSize+0xffff <= len(damaged) {
+			t.Fatalf("expected a log shorter than the damaged length, got %d bytes", len(damaged))
+		}
+		encoding.ByteOrder.PutUint16(damaged[second+4:second+6], 0xffff)
+
+		if _, err := ReadLog(bytes.NewReader(damaged), true); !errors.Is(err, ErrCorrupted) {
+			t.Errorf("expected ErrCorrupted in strict mode, got %v", err)
+		}
+		// the rest of the first block is skipped, and every record from the next one on is kept in place
+		recovered, err := ReadLog(bytes.NewReader(damaged), false)
+		if err != nil {
+			t.Fatal("unexpected error reading log:", err)
+		}
+		var ops = recovered.Operations
+		if len(ops) < 2 || string(ops[0].Key) != "key000" || string(ops[len(ops)-1].Key) != "key399" {
+			t.Fatalf("expected the records before and after the skipped block, got %d", len(ops))
+		}
+		if recovered.Size != int64(len(damaged)) || recovered.Discarded != 0 || recovered.Skipped == 0 {
+			t.Errorf(
+				"expected the skipped block to be left in place, got size %d of %d, %d discarded and %d skipped",
+				recovered.Size, len(damaged), recovered.Discarded, recovered.Skipped,
+			)
+		}
+	})
+	t.Run("AppendAfterRecovery", func(t *testing.T) {
+		var damaged = bytes.Clone(intact[:ends[3]-1])
+		recovered, err := ReadLog(bytes.NewReader(damaged), true)
+		if err != nil {
+			t.Fatal("unexpected error reading log:", err)
+		}
+		var appended = bytes.NewBuffer(damaged[:recovered.Size])
+		if err := NewLogAt(appended, recovered.Size).Put(leveldb.Key("appended"), leveldb.Value("value")); err != nil {
+			t.Fatal("error writing PUT to log:", err)
+		}
+		recovered, err = ReadLog(appended, true)
+		if err != nil {
+			t.Fatal("unexpected error reading appended log:", err)
+		}
+		if len(recovered.Operations) != 4 || string(recovered.Operations[3].Key) != "appended" {
+			t.Errorf("expected the appended operation to follow the 3 intact ones, got %d", len(recovered.Operations))
+		}
+	})
+}
+
+// syncCountingBuffer is a log destination counting its syncs, each of which takes long enough for other writers to
+// pile up behind it.
+type syncCountingBuffer struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	syncs atomic.Int64
+}
+
+func (b *syncCountingBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncCountingBuffer) Sync() error {
+	time.Sleep(time.Millisecond)
+	b.syncs.Add(1)
+	return nil
+}
+
+func TestLog_SyncPolicy(t *testing.T) {
+	t.Run("Never", func(t *testing.T) {
+		var buf = new(syncCountingBuffer)
+		var log = NewLog(buf)
+		for j := range 10 {
+			if err := log.Put(leveldb.Key(fmt.Sprint(j)), leveldb.Value("value")); err != nil {
+				t.Fatal("error writing PUT to log:", err)
+			}
+		}
+		if syncs := buf.syncs.Load(); syncs != 0 {
+			t.Errorf("expected no syncs, got %d", syncs)
+		}
+		if err := log.Delete(leveldb.Key("0"), leveldb.WithSync(true)); err != nil {
+			t.Fatal("error writing DELETE to log:", err)
+		}
+		if syncs := buf.syncs.Load(); syncs != 1 {
+			t.Errorf("expected a write asking for a sync to get one, got %d syncs", syncs)
+		}
+	})
+
+	t.Run("EveryWriteGroupCommits", func(t *testing.T) {
+		const writers, writesPerWriter = 16, 20
+		var (
+			buf = new(syncCountingBuffer)
+			log = NewLog(buf, WithSyncPolicy(SyncEveryWrite, 0))
+			wg  sync.WaitGroup
+		)
+		for w := range writers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := range writesPerWriter {
+					var key = leveldb.Key(fmt.Sprintf("%02d-%02d", w, j))
+					if err := log.Put(key, leveldb.Value("value")); err != nil {
+						t.Error("error writing PUT to log:", err)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		var syncs = buf.syncs.Load()
+		if syncs == 0 || syncs >= writers*writesPerWriter {
+			t.Errorf(
+				"expected concurrent writes to share syncs, got %d syncs for %d writes",
+				syncs,
+				writers*writesPerWriter,
+			)
+		}
+		recovered, err := ReadLog(&buf.buf, true)
+		if err != nil {
+			t.Fatal("unexpected error reading log:", err)
+		}
+		if len(recovered.Operations) != writers*writesPerWriter {
+			t.Errorf(
+				"expected %d operations, got %d",
+				writers*writesPerWriter,
+				len(recovered.Operations),
+			)
+		}
+	})
+
+	t.Run("Periodically", func(t *testing.T) {
+		var buf = new(syncCountingBuffer)
+		var log = NewLog(buf, WithSyncPolicy(SyncPeriodically, 5*time.Millisecond))
+		if err := log.Put(leveldb.Key("key"), leveldb.Value("value")); err != nil {
+			t.Fatal("error writing PUT to log:", err)
+		}
+		for deadline := time.Now().Add(5 * time.Second); buf.syncs.Load() == 0; {
+			if time.Now().After(deadline) {
+				t.Fatal("expected the write to be synced in the background, it was not")
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err := log.Close(); err != nil {
+			t.Fatal("unexpected error closing log:", err)
+		}
+	})
+
+	t.Run("PeriodicallySyncsOnClose", func(t *testing.T) {
+		var buf = new(syncCountingBuffer)
+		var log = NewLog(buf, WithSyncPolicy(SyncPeriodically, time.Hour))
+		if err := log.Put(leveldb.Key("key"), leveldb.Value("value")); err != nil {
+			t.Fatal("error writing PUT to log:", err)
+		}
+		if err := log.Close(); err != nil {
+			t.Fatal("unexpected error closing log:", err)
+		}
+		if syncs := buf.syncs.Load(); syncs != 1 {
+			t.Errorf("expected Close to sync the outstanding write, got %d syncs", syncs)
+		}
+	})
+
+	t.Run("CloseSyncsPendingAppends", func(t *testing.T) {
+		var buf = new(syncCountingBuffer)
+		var log = NewLog(buf)
+		var batch leveldb.WriteBatch
+		batch.Put(leveldb.Key("key"), leveldb.Value("value"))
+		pending, err := log.Append(&batch, leveldb.WithSync(true))
+		if err != nil {
+			t.Fatal("error appending to log:", err)
+		}
+		if err := log.Close(); err != nil {
+			t.Fatal("unexpected error closing log:", err)
+		}
+		if syncs := buf.syncs.Load(); syncs != 1 {
+			t.Errorf("expected Close to sync the append asking for it, got %d syncs", syncs)
+		}
+		if err := pending.Wait(); err != nil {
+			t.Fatal("unexpected error waiting for append:", err)
+		}
+		if syncs := buf.syncs.Load(); syncs != 1 {
+			t.Errorf("expected the append to be synced once, got %d syncs", syncs)
+		}
+		recovered, err := ReadLog(&buf.buf, true)
+		if err != nil {
+			t.Fatal("unexpected error reading log:", err)
+		}
+		if len(recovered.Operations) != 1 || recovered.Operations[0].Operation != encoding.OpPut {
+			t.Errorf("expected a batch of one write to be logged as that write, got %v", recovered.Operations)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"leveldb/encoding"
+)
+
+// ErrCorrupted is reported by ReadLog in strict mode for damage to a record followed by intact ones.
+var ErrCorrupted = errors.New("wal: log corrupted")
+
+// Recovered is what ReadLog reads back from a log.
+type Recovered struct {
+	Operations []*encoding.DbOperation
+	// Size is the length of the log up to the end of its last intact record, which is where appending should resume.
+	Size int64
+	// Discarded is the number of bytes past the last intact record, which were dropped.  They hold nothing but a torn
+	// record or zero padding, so they can be truncated away.
+	Discarded int64
+	// Skipped is the number of bytes of damaged records that intact ones follow, which were dropped but must be left
+	// in place.
+	Skipped int64
+}
+
+// ReadLog reads back the operations recorded in a log.  A final record cut short or failing its checksum is the
+// remains of an append interrupted by a crash; it was never acknowledged, so it is dropped.  Damage to a record that
+// intact ones follow means the log itself was corrupted.  In strict mode that is reported as ErrCorrupted; otherwise
+// the damaged record is skipped, along with the rest of its block if its length cannot be trusted, as LevelDB does,
+// and reading resumes after it.
+func ReadLog(r io.Reader, strict bool) (*Recovered, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		recovered = new(Recovered)
+		offset    int
+		// pending holds the fragments read so far of a record split across blocks, which started at recordStart
+		pending     []byte
+		inFragment  bool
+		recordStart int
+	)
+	for offset < len(data) {
+		var blockLeft = blockSize - offset%blockSize
+		if blockLeft < headerSize {
+			offset += blockLeft // padding
+			continue
+		}
+		if len(data)-offset < headerSize {
+			break // torn header
+		}
+		var (
+			header    = data[offset : offset+headerSize]
+			length    = int(encoding.ByteOrder.Uint16(header[4:6]))
+			typ       = recordType(header[6])
+			recordEnd = offset + headerSize + length
+		)
+		if typ == zeroType && length == 0 && isZero(data[offset:]) {
+			break // preallocated space, never written
+		}
+
+		// resume is where reading resumes if the record is damaged: after it, unless its length is damaged too
+		var (
+			reason string
+			resume = recordEnd
+		)
+		switch {
+		case headerSize+length > blockLeft:
+			reason, resume = "record crosses a block boundary", offset+blockLeft
+		case recordEnd > len(data):
+			reason, resume = "record cut short", offset+blockLeft
+		case crc32.Checksum(data[offset+4:recordEnd], crc32cTable) != encoding.ByteOrder.Uint32(header[:4]):
+			reason = "checksum mismatch"
+		case (typ == fullType || typ == firstType) && inFragment:
+			// the fragments before it are the damaged record, and it is read again once they are dropped
+			reason, resume = "record ends without its last fragment", offset
+		case (typ == middleType || typ == lastType) && !inFragment:
+			reason = "fragment without the start of its record"
+		case typ < fullType || typ > lastType:
+			reason = fmt.Sprintf("unknown record type %d", typ)
+		}
+		if reason != "" {
+			if !intactFrom(data, resume) {
+				break // torn final record
+			}
+			if strict {
+				return nil, fmt.Errorf("%w at offset %d: %s", ErrCorrupted, offset, reason)
+			}
+			var skipFrom = offset
+			if inFragment {
+				skipFrom = recordStart
+			}
+			recovered.Skipped += int64(resume - skipFrom)
+			pending, inFragment = nil, false
+			offset = resume
+			continue
+		}
+
+		if typ == fullType || typ == firstType {
+			recordStart = offset
+		}
+		var fragment = data[offset+headerSize : recordEnd]
+		offset = recordEnd
+		switch typ {
+		case firstType, middleType:
+			pending, inFragment = append(pending, fragment...), true
+			continue
+		case lastType:
+			fragment, pending, inFragment = append(pending, fragment...), nil, false
+		}
+		ops, err := encoding.DecodeLogFile(bufio.NewReader(bytes.NewReader(fragment)))
+		if err != nil {
+			// the checksum matched, so this was written this way
+			return nil, fmt.Errorf("%w at offset %d: error decoding operation: %v", ErrCorrupted, recordStart, err)
+		}
+		recovered.Operations = append(recovered.Operations, ops...)
+		recovered.Size = int64(offset)
+	}
+	recovered.Discarded = int64(len(data)) - recovered.Size
+	return recovered, nil
+}
+
+// intactFrom reports whether any record at or after offset from passes its checksum.  The records are found by
+// following their lengths from from to the end of its block, and from the start of each block after it, as far as
+// the records before them are intact.
+func intactFrom(data []byte, from int) bool {
+	for offset := from; offset < len(data); {
+		var blockEnd = offset - offset%blockSize + blockSize
+		if blockEnd-offset < headerSize || len(data)-offset < headerSize {
+			offset = blockEnd
+			continue
+		}
+		var (
+			header    = data[offset : offset+headerSize]
+			recordEnd = offset + headerSize + int(encoding.ByteOrder.Uint16(header[4:6]))
+		)
+		if recordEnd > blockEnd || recordEnd > len(data) ||
+			crc32.Checksum(data[offset+4:recordEnd], crc32cTable) != encoding.ByteOrder.Uint32(header[:4]) {
+			offset = blockEnd
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}